@@ -2,18 +2,69 @@ package main
 
 import (
 	"context"
+	"errors"
 	"io"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	"golang.org/x/oauth2"
 )
 
+// Fitbit access tokens are valid for 8 hours
+const ACCESS_TOKEN_CACHE_TTL = 1 * time.Hour
+
+const REFRESH_TOKEN_NAME = "fitbit_refresh_token"
+
+// TokenStore keeps tokens in place of S3, e.g. when running outside of AWS.
+type TokenStore interface {
+	GetToken(ctx context.Context, name string) (string, error)
+	PutToken(ctx context.Context, name string, value string) error
+}
+
 type Instances struct {
-	SSMClient *ssm.Client
-	S3Client  *s3.Client
+	SSMClient      *ssm.Client
+	S3Client       *s3.Client
+	DynamoDBClient *dynamodb.Client
+	// the refresh token is stored on S3 when nil
+	Tokens TokenStore
+}
+
+func newInstances(ctx context.Context) (*Instances, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := newTokenStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Instances{
+		SSMClient:      ssm.NewFromConfig(cfg),
+		S3Client:       s3.NewFromConfig(cfg),
+		DynamoDBClient: dynamodb.NewFromConfig(cfg),
+		Tokens:         tokens,
+	}, nil
+}
+
+// newTokenStore creates the store selected by TOKEN_STORE ("sqlite", in the database at HISTORY_PATH).
+// It returns nil when TOKEN_STORE is not set.
+func newTokenStore(ctx context.Context) (TokenStore, error) {
+	switch os.Getenv("TOKEN_STORE") {
+	case "":
+		return nil, nil
+	case TOKEN_STORE_SQLITE:
+		return sharedSQLiteHistoryStore(ctx, os.Getenv("HISTORY_PATH"))
+	default:
+		return nil, errors.New(UnknownTokenStore)
+	}
 }
 
 func (instances *Instances) getParameter(parameterName string) (*string, error) {
@@ -29,6 +80,14 @@ func (instances *Instances) getParameter(parameterName string) (*string, error)
 }
 
 func (instances *Instances) getRefreshToken() (*string, error) {
+	if instances.Tokens != nil {
+		refreshToken, err := instances.Tokens.GetToken(context.TODO(), REFRESH_TOKEN_NAME)
+		if err != nil {
+			return nil, err
+		}
+		return &refreshToken, nil
+	}
+
 	getObjectOutput, err := instances.S3Client.GetObject(context.TODO(), &s3.GetObjectInput{
 		Bucket: refreshCbBucketName,
 		Key:    refreshCbFileName,
@@ -69,6 +128,13 @@ func (instances *Instances) refreshAccessToken(ctx context.Context, clientID str
 		return nil, err
 	}
 
+	if instances.Tokens != nil {
+		if err := instances.Tokens.PutToken(ctx, REFRESH_TOKEN_NAME, newToken.RefreshToken); err != nil {
+			return nil, err
+		}
+		return &newToken.AccessToken, nil
+	}
+
 	tempFileName := "/tmp/" + *refreshCbBucketName
 
 	tempFile, err := os.Create(tempFileName)
@@ -101,3 +167,52 @@ func (instances *Instances) refreshAccessToken(ctx context.Context, clientID str
 
 	return &newToken.AccessToken, nil
 }
+
+// getAccessToken gets a new Fitbit access token with the stored refresh token.
+func (instances *Instances) getAccessToken(ctx context.Context) (*string, error) {
+	clientIDParameterName := os.Getenv("CLIENT_ID_PARAMETER_NAME_GO")
+	clientID, err := instances.getParameter(clientIDParameterName)
+	if err != nil {
+		return nil, err
+	}
+
+	clientSecretParameterName := os.Getenv("CLIENT_SECRET_PARAMETER_NAME_GO")
+	clientSecret, err := instances.getParameter(clientSecretParameterName)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := instances.getRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return instances.refreshAccessToken(ctx, *clientID, *clientSecret, *refreshToken)
+}
+
+// accessTokenCache reuses an access token across webhook requests. Every refresh rotates the
+// refresh token stored on S3, so concurrent requests must not refresh at the same time.
+type accessTokenCache struct {
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+	fetch     func(ctx context.Context) (*string, error)
+}
+
+func (cache *accessTokenCache) get(ctx context.Context) (string, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.token != "" && time.Now().Before(cache.expiresAt) {
+		return cache.token, nil
+	}
+
+	token, err := cache.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	cache.token = *token
+	cache.expiresAt = time.Now().Add(ACCESS_TOKEN_CACHE_TTL)
+	return cache.token, nil
+}
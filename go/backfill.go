@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	RESTING_HEART_RATE_RESOURCE = "restingHeartRate"
+
+	// the longest ranges the Fitbit API accepts
+	SLEEP_CHUNK_DAYS = 100
+	HEART_CHUNK_DAYS = 365
+
+	BACKFILL_STREAM_ACTIVITIES = "activities"
+	BACKFILL_STREAM_SLEEP      = "sleep"
+	BACKFILL_STREAM_HEART      = "heart"
+
+	DEFAULT_BACKFILL_STATE_DIR = "backfill-state"
+	// wait for the hourly rate limit rather than failing
+	BACKFILL_MAX_RATE_LIMIT_WAIT = time.Hour + time.Minute
+)
+
+// backfillCheckpoint is the progress of a stream: everything before Next has been stored.
+type backfillCheckpoint struct {
+	Next time.Time `json:"next"`
+}
+
+// Backfiller copies the history of the account from the Fitbit API into a HistoryStore.
+// Each stream saves a checkpoint after every chunk so that an interrupted run resumes.
+type Backfiller struct {
+	History   HistoryStore
+	State     StateStore
+	Resources []TimeSeriesResource
+
+	getAccessToken       func(ctx context.Context) (string, error)
+	fetchTimeSeries      timeSeriesFetchFunc
+	getActivityRecords   func(ctx context.Context, access_token string, afterDate time.Time) ([]ActivityRecord, error)
+	getSleepRecords      func(ctx context.Context, access_token string, startDate time.Time, endDate time.Time) ([]SleepRecord, error)
+	getRestingHeartRates func(ctx context.Context, access_token string, startDate time.Time, endDate time.Time) ([]DailyValue, error)
+}
+
+func backfillCheckpointKey(stream string) string {
+	return "backfill/" + stream
+}
+
+func (backfiller *Backfiller) checkpoint(ctx context.Context, stream string, from time.Time) (time.Time, error) {
+	value, err := backfiller.State.Get(ctx, backfillCheckpointKey(stream))
+	if err != nil || value == nil {
+		return from, err
+	}
+
+	var checkpoint backfillCheckpoint
+	if err := json.Unmarshal(value, &checkpoint); err != nil {
+		return from, fmt.Errorf("failed to decode backfill checkpoint: %v", err)
+	}
+	if checkpoint.Next.Before(from) {
+		return from, nil
+	}
+	return checkpoint.Next, nil
+}
+
+func (backfiller *Backfiller) saveCheckpoint(ctx context.Context, stream string, next time.Time) error {
+	value, err := json.Marshal(backfillCheckpoint{Next: next})
+	if err != nil {
+		return err
+	}
+	return backfiller.State.Put(ctx, backfillCheckpointKey(stream), value)
+}
+
+// Run backfills every stream from from to to (inclusive dates).
+func (backfiller *Backfiller) Run(ctx context.Context, from time.Time, to time.Time) error {
+	for _, resource := range backfiller.Resources {
+		resource := resource
+		err := backfiller.backfillDates(ctx, "timeseries/"+resource.Name, from, to, resource.LimitDays, func(accessToken string, start time.Time, end time.Time) error {
+			data, err := backfiller.fetchTimeSeries(ctx, accessToken, resource, start.Format(DATE_FORMAT), end.Format(DATE_FORMAT))
+			if err != nil {
+				return err
+			}
+
+			var values []DailyValue
+			for _, dailyHistory := range data[resource.responseKey()] {
+				date, err := time.ParseInLocation(DATE_FORMAT, dailyHistory["dateTime"], time.Local)
+				if err != nil {
+					return err
+				}
+				value, err := resource.parseValue(dailyHistory["value"])
+				if err != nil {
+					return err
+				}
+				values = append(values, DailyValue{date, value})
+			}
+			return backfiller.History.PutDailyValues(ctx, resource.Name, values)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := backfiller.backfillActivities(ctx, from); err != nil {
+		return err
+	}
+
+	err := backfiller.backfillDates(ctx, BACKFILL_STREAM_SLEEP, from, to, SLEEP_CHUNK_DAYS, func(accessToken string, start time.Time, end time.Time) error {
+		records, err := backfiller.getSleepRecords(ctx, accessToken, start, end)
+		if err != nil {
+			return err
+		}
+		return backfiller.History.PutSleep(ctx, records)
+	})
+	if err != nil {
+		return err
+	}
+
+	return backfiller.backfillDates(ctx, BACKFILL_STREAM_HEART, from, to, HEART_CHUNK_DAYS, func(accessToken string, start time.Time, end time.Time) error {
+		values, err := backfiller.getRestingHeartRates(ctx, accessToken, start, end)
+		if err != nil {
+			return err
+		}
+		return backfiller.History.PutDailyValues(ctx, RESTING_HEART_RATE_RESOURCE, values)
+	})
+}
+
+// backfillDates walks the dates in chunks of chunkDays. The last chunk is fetched again on the
+// next run, as the data of today is not final.
+func (backfiller *Backfiller) backfillDates(ctx context.Context, stream string, from time.Time, to time.Time, chunkDays int, fetch func(accessToken string, start time.Time, end time.Time) error) error {
+	if chunkDays <= 0 {
+		return errors.New(InvalidLimitDays)
+	}
+
+	start, err := backfiller.checkpoint(ctx, stream, from)
+	if err != nil {
+		return err
+	}
+
+	for !start.After(to) {
+		end := start.AddDate(0, 0, chunkDays-1)
+		if end.After(to) {
+			end = to
+		}
+
+		accessToken, err := backfiller.getAccessToken(ctx)
+		if err != nil {
+			return err
+		}
+		if err := fetch(accessToken, start, end); err != nil {
+			return fmt.Errorf("failed to backfill %s from %s: %v", stream, start.Format(DATE_FORMAT), err)
+		}
+
+		next := end.AddDate(0, 0, 1)
+		if next.After(to) {
+			// the last chunk is fetched again next time
+			next = start
+		}
+		if err := backfiller.saveCheckpoint(ctx, stream, next); err != nil {
+			return err
+		}
+		log.Printf("backfilled %s until %s", stream, end.Format(DATE_FORMAT))
+
+		start = end.AddDate(0, 0, 1)
+	}
+	return nil
+}
+
+// backfillActivities pages through the activity logs, oldest first. The checkpoint is the start
+// time of the last activity stored.
+func (backfiller *Backfiller) backfillActivities(ctx context.Context, from time.Time) error {
+	// activities started after the day before from
+	after, err := backfiller.checkpoint(ctx, BACKFILL_STREAM_ACTIVITIES, from.AddDate(0, 0, -1))
+	if err != nil {
+		return err
+	}
+
+	for {
+		accessToken, err := backfiller.getAccessToken(ctx)
+		if err != nil {
+			return err
+		}
+
+		records, err := backfiller.getActivityRecords(ctx, accessToken, after)
+		if err != nil {
+			return fmt.Errorf("failed to backfill %s after %s: %v", BACKFILL_STREAM_ACTIVITIES, after.Format(FITBIT_AFTER_DATE_FORMAT), err)
+		}
+		if len(records) == 0 {
+			return nil
+		}
+		if err := backfiller.History.PutActivities(ctx, records); err != nil {
+			return err
+		}
+
+		// Fitbit takes the local time of the account
+		last := records[len(records)-1].StartTime
+		next := time.Date(last.Year(), last.Month(), last.Day(), last.Hour(), last.Minute(), last.Second(), 0, time.Local)
+		if !next.After(after) {
+			return nil
+		}
+		after = next
+		if err := backfiller.saveCheckpoint(ctx, BACKFILL_STREAM_ACTIVITIES, after); err != nil {
+			return err
+		}
+		log.Printf("backfilled %s until %s", BACKFILL_STREAM_ACTIVITIES, after.Format(FITBIT_AFTER_DATE_FORMAT))
+
+		if len(records) < FITBIT_ACTIVITY_PAGE_SIZE {
+			return nil
+		}
+	}
+}
+
+// runBackfill copies the history from START_DATE, or from the date the account was created,
+// until today into the history store.
+func runBackfill(args []string) error {
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	fromFlag := flags.String("from", "", "first date to backfill (default START_DATE or the member-since date)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	instances, err := newInstances(ctx)
+	if err != nil {
+		return err
+	}
+
+	history, err := newHistoryStore(ctx)
+	if err != nil {
+		return err
+	}
+	if history == nil {
+		return errors.New(MissingHistoryStore)
+	}
+
+	stateStore, err := instances.newStateStore()
+	if err != nil {
+		return err
+	}
+	if stateStore == nil {
+		stateStore = &FileStateStore{Dir: DEFAULT_BACKFILL_STATE_DIR}
+	}
+
+	extraResources, err := lookupExtraTimeSeriesResources()
+	if err != nil {
+		return err
+	}
+
+	fitbitHTTPClient.Transport = newRateLimitedTransport(BACKFILL_MAX_RATE_LIMIT_WAIT)
+	tokens := &accessTokenCache{fetch: instances.getAccessToken}
+
+	today := time.Now().Local()
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.Local)
+
+	var from time.Time
+	switch {
+	case *fromFlag != "":
+		from, err = time.ParseInLocation(DATE_FORMAT, *fromFlag, time.Local)
+	case startDate != "":
+		from, err = time.ParseInLocation(DATE_FORMAT, startDate, time.Local)
+	default:
+		accessToken, tokenErr := tokens.get(ctx)
+		if tokenErr != nil {
+			return tokenErr
+		}
+		from, err = getMemberSince(ctx, accessToken)
+	}
+	if err != nil {
+		return err
+	}
+
+	backfiller := &Backfiller{
+		History:              history,
+		State:                stateStore,
+		Resources:            append([]TimeSeriesResource{stepsResource}, extraResources...),
+		getAccessToken:       tokens.get,
+		fetchTimeSeries:      getTimeSeriesByDateRange,
+		getActivityRecords:   getActivityRecords,
+		getSleepRecords:      getSleepRecordsByDateRange,
+		getRestingHeartRates: getRestingHeartRatesByDateRange,
+	}
+	return backfiller.Run(ctx, from, today)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type backfillCalls struct {
+	timeSeries []string
+	activities []time.Time
+	sleep      []string
+	heart      []string
+	failSleep  bool
+}
+
+func newTestBackfiller(t *testing.T, calls *backfillCalls, state StateStore) (*Backfiller, HistoryStore) {
+	history := &FileHistoryStore{Path: t.TempDir() + "/history.json"}
+	resource := stepsResource
+	resource.LimitDays = 10
+
+	return &Backfiller{
+		History:   history,
+		State:     state,
+		Resources: []TimeSeriesResource{resource},
+		getAccessToken: func(ctx context.Context) (string, error) {
+			return "token", nil
+		},
+		fetchTimeSeries: func(ctx context.Context, access_token string, resource TimeSeriesResource, startDate string, endDate string) (map[string][]map[string]string, error) {
+			calls.timeSeries = append(calls.timeSeries, startDate+"/"+endDate)
+			return map[string][]map[string]string{
+				"activities-steps": {{"dateTime": startDate, "value": "100"}, {"dateTime": endDate, "value": "200"}},
+			}, nil
+		},
+		getActivityRecords: func(ctx context.Context, access_token string, afterDate time.Time) ([]ActivityRecord, error) {
+			calls.activities = append(calls.activities, afterDate)
+			var records []ActivityRecord
+			// two full pages and a last one
+			if len(calls.activities) <= 2 {
+				for i := 0; i < FITBIT_ACTIVITY_PAGE_SIZE; i++ {
+					records = append(records, ActivityRecord{LogID: int64(len(calls.activities)*1000 + i), StartTime: afterDate.Add(time.Duration(i+1) * time.Hour)})
+				}
+			} else {
+				records = append(records, ActivityRecord{LogID: 1, StartTime: afterDate.Add(time.Hour)})
+			}
+			return records, nil
+		},
+		getSleepRecords: func(ctx context.Context, access_token string, startDate time.Time, endDate time.Time) ([]SleepRecord, error) {
+			if calls.failSleep {
+				return nil, errors.New("rate limited")
+			}
+			calls.sleep = append(calls.sleep, startDate.Format(DATE_FORMAT)+"/"+endDate.Format(DATE_FORMAT))
+			return nil, nil
+		},
+		getRestingHeartRates: func(ctx context.Context, access_token string, startDate time.Time, endDate time.Time) ([]DailyValue, error) {
+			calls.heart = append(calls.heart, startDate.Format(DATE_FORMAT)+"/"+endDate.Format(DATE_FORMAT))
+			return []DailyValue{{startDate, 60}}, nil
+		},
+	}, history
+}
+
+func TestBackfiller(t *testing.T) {
+	ctx := context.Background()
+	from := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local)
+	to := time.Date(2024, time.January, 25, 0, 0, 0, 0, time.Local)
+	state := &MemoryStateStore{}
+
+	calls := &backfillCalls{failSleep: true}
+	backfiller, history := newTestBackfiller(t, calls, state)
+
+	err := backfiller.Run(ctx, from, to)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"2024-01-01/2024-01-10", "2024-01-11/2024-01-20", "2024-01-21/2024-01-25"}, calls.timeSeries)
+	assert.Len(t, calls.activities, 3)
+	assert.Equal(t, from.AddDate(0, 0, -1), calls.activities[0])
+
+	steps, err := history.GetDailyValues(ctx, "steps")
+	assert.NoError(t, err)
+	assert.Equal(t, 200.0, steps[time.Date(2024, time.January, 20, 0, 0, 0, 0, time.Local)])
+
+	activities, err := history.GetActivities(ctx, from.AddDate(0, 0, -1), to.AddDate(1, 0, 0))
+	assert.NoError(t, err)
+	assert.Len(t, activities, 2*FITBIT_ACTIVITY_PAGE_SIZE+1)
+
+	// resumed from the checkpoints: only the last chunk of the finished streams is fetched again
+	calls.failSleep = false
+	calls.timeSeries = nil
+	err = backfiller.Run(ctx, from, to)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2024-01-21/2024-01-25"}, calls.timeSeries)
+	assert.Equal(t, []string{"2024-01-01/2024-01-25"}, calls.sleep)
+	assert.Equal(t, []string{"2024-01-01/2024-01-25"}, calls.heart)
+	// the activities continue after the last one stored
+	assert.Len(t, calls.activities, 4)
+	assert.True(t, calls.activities[2].Add(time.Hour).Equal(calls.activities[3]))
+
+	heart, err := history.GetDailyValues(ctx, RESTING_HEART_RATE_RESOURCE)
+	assert.NoError(t, err)
+	assert.Equal(t, map[time.Time]float64{from: 60}, heart)
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRateLimitedTransport(t *testing.T) {
+	requests := 0
+	var waits []time.Duration
+	transport := &rateLimitedTransport{
+		Base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			requests += 1
+			if requests == 1 {
+				header := http.Header{}
+				header.Set(FITBIT_RATE_LIMIT_RESET_HEADER, "120")
+				return &http.Response{StatusCode: http.StatusTooManyRequests, Header: header, Body: io.NopCloser(strings.NewReader(""))}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}),
+		MaxWait: time.Hour,
+		sleep: func(ctx context.Context, d time.Duration) error {
+			waits = append(waits, d)
+			return nil
+		},
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://api.fitbit.com/", nil)
+	resp, err := transport.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []time.Duration{121 * time.Second}, waits)
+
+	// the reset is too far to wait for
+	requests = 0
+	transport.MaxWait = time.Minute
+	resp, err = transport.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+}
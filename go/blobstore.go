@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	BLOB_STORE_S3    = "s3"
+	BLOB_STORE_LOCAL = "local"
+
+	// LINE fetches images some time after the push, so keep presigned URLs valid long enough
+	PRESIGN_EXPIRES = 7 * 24 * time.Hour
+)
+
+// BlobStore stores files such as chart images and returns an HTTPS URL LINE can fetch them from.
+type BlobStore interface {
+	Put(ctx context.Context, key string, body []byte, contentType string) (string, error)
+}
+
+// S3BlobStore stores files in an S3 bucket. The URL is built from BaseURL (e.g. a CloudFront
+// distribution) when set, otherwise a presigned URL is returned.
+type S3BlobStore struct {
+	Client  *s3.Client
+	Bucket  string
+	BaseURL string
+}
+
+func (store *S3BlobStore) Put(ctx context.Context, key string, body []byte, contentType string) (string, error) {
+	_, err := store.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(store.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if store.BaseURL != "" {
+		return strings.TrimSuffix(store.BaseURL, "/") + "/" + key, nil
+	}
+
+	presigned, err := s3.NewPresignClient(store.Client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(PRESIGN_EXPIRES))
+	if err != nil {
+		return "", err
+	}
+
+	return presigned.URL, nil
+}
+
+// LocalBlobStore stores files in Dir. They are served by Handler under BaseURL.
+type LocalBlobStore struct {
+	Dir     string
+	BaseURL string
+}
+
+func (store *LocalBlobStore) Put(ctx context.Context, key string, body []byte, contentType string) (string, error) {
+	path := filepath.Join(store.Dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, filepath.Clean(store.Dir)+string(os.PathSeparator)) {
+		return "", errors.New(InvalidBlobKey)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, body, 0644); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(store.BaseURL, "/") + "/" + key, nil
+}
+
+// Handler serves the stored files. Mount it at the path of BaseURL.
+func (store *LocalBlobStore) Handler(prefix string) http.Handler {
+	fileServer := http.FileServer(http.Dir(store.Dir))
+	return http.StripPrefix(prefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the keys are not listed
+		if strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+}
+
+// mount serves the stored files on mux under the path of BaseURL, e.g. /blobs/ for
+// https://example.com/blobs.
+func (store *LocalBlobStore) mount(mux *http.ServeMux) error {
+	baseURL, err := url.Parse(store.BaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse BLOB_BASE_URL: %v", err)
+	}
+	prefix := strings.TrimSuffix(baseURL.Path, "/")
+	if prefix == "" {
+		return errors.New(InvalidBlobBaseURL)
+	}
+
+	mux.Handle(prefix+"/", store.Handler(prefix))
+	return nil
+}
+
+func newLocalBlobStore() *LocalBlobStore {
+	return &LocalBlobStore{
+		Dir:     os.Getenv("BLOB_DIR"),
+		BaseURL: os.Getenv("BLOB_BASE_URL"),
+	}
+}
+
+// mountBlobStore serves the local blob store on mux when BLOB_STORE is "local", so that LINE
+// fetches the charts from the same server.
+func mountBlobStore(mux *http.ServeMux) error {
+	if os.Getenv("BLOB_STORE") != BLOB_STORE_LOCAL {
+		return nil
+	}
+	return newLocalBlobStore().mount(mux)
+}
+
+// newBlobStore creates the store selected by BLOB_STORE ("s3" or "local").
+func (instances *Instances) newBlobStore() (BlobStore, error) {
+	switch os.Getenv("BLOB_STORE") {
+	case BLOB_STORE_S3:
+		return &S3BlobStore{
+			Client:  instances.S3Client,
+			Bucket:  os.Getenv("BLOB_BUCKET_NAME"),
+			BaseURL: os.Getenv("BLOB_BASE_URL"),
+		}, nil
+	case BLOB_STORE_LOCAL:
+		return newLocalBlobStore(), nil
+	default:
+		return nil, errors.New(UnknownBlobStore)
+	}
+}
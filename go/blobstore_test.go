@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalBlobStore(t *testing.T) {
+	store := &LocalBlobStore{Dir: t.TempDir(), BaseURL: "https://example.com/blobs/"}
+
+	url, err := store.Put(context.Background(), "charts/a/b.png", []byte("png"), "image/png")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/blobs/charts/a/b.png", url)
+
+	server := httptest.NewServer(store.Handler("/blobs"))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/blobs/charts/a/b.png")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "png", string(body))
+
+	_, err = store.Put(context.Background(), "../escape.png", []byte("png"), "image/png")
+	assert.EqualError(t, err, InvalidBlobKey)
+}
+
+func TestMountLocalBlobStore(t *testing.T) {
+	store := &LocalBlobStore{Dir: t.TempDir(), BaseURL: "https://example.com/blobs"}
+	_, err := store.Put(context.Background(), "charts/a/b.png", []byte("png"), "image/png")
+	assert.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "webhook")
+	})
+	assert.NoError(t, store.mount(mux))
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/blobs/charts/a/b.png", nil))
+	assert.Equal(t, "png", recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/blobs/charts/a/", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, "webhook", recorder.Body.String())
+
+	// the root would shadow the webhook
+	store.BaseURL = "https://example.com/"
+	assert.EqualError(t, store.mount(http.NewServeMux()), InvalidBlobBaseURL)
+}
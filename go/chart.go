@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strconv"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	CHART_WIDTH         = 800
+	CHART_HEIGHT        = 450
+	CHART_MARGIN_LEFT   = 60
+	CHART_MARGIN_RIGHT  = 20
+	CHART_MARGIN_TOP    = 40
+	CHART_MARGIN_BOTTOM = 40
+	CHART_TREND_WEEKS   = 52
+	// more labels overlap under the x axis
+	CHART_MAX_X_LABELS = 14
+	DEFAULT_STEPS_GOAL = 10000
+)
+
+var (
+	chartBackgroundColor = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	chartAxisColor       = color.RGBA{0x88, 0x88, 0x88, 0xFF}
+	chartTextColor       = color.RGBA{0x33, 0x33, 0x33, 0xFF}
+	chartBarColor        = color.RGBA{0x1D, 0xB4, 0x46, 0xFF}
+	chartGoalColor       = color.RGBA{0xE5, 0x39, 0x35, 0xFF}
+)
+
+type chartCanvas struct {
+	img    *image.RGBA
+	plot   image.Rectangle
+	maxY   float64
+	labels []string
+}
+
+func newChartCanvas(title string, labels []string, maxY float64) *chartCanvas {
+	img := image.NewRGBA(image.Rect(0, 0, CHART_WIDTH, CHART_HEIGHT))
+	draw.Draw(img, img.Bounds(), &image.Uniform{chartBackgroundColor}, image.Point{}, draw.Src)
+
+	c := &chartCanvas{
+		img:    img,
+		plot:   image.Rect(CHART_MARGIN_LEFT, CHART_MARGIN_TOP, CHART_WIDTH-CHART_MARGIN_RIGHT, CHART_HEIGHT-CHART_MARGIN_BOTTOM),
+		maxY:   niceCeil(maxY),
+		labels: labels,
+	}
+
+	c.text(CHART_MARGIN_LEFT, CHART_MARGIN_TOP/2+5, title, chartTextColor)
+
+	// y axis with 4 grid lines
+	for i := 0; i <= 4; i++ {
+		value := c.maxY * float64(i) / 4
+		y := c.y(value)
+		c.line(c.plot.Min.X, y, c.plot.Max.X, y, color.RGBA{0xEE, 0xEE, 0xEE, 0xFF})
+		c.text(5, y+4, formatCompactNumber(value), chartTextColor)
+	}
+	c.line(c.plot.Min.X, c.plot.Min.Y, c.plot.Min.X, c.plot.Max.Y, chartAxisColor)
+	c.line(c.plot.Min.X, c.plot.Max.Y, c.plot.Max.X, c.plot.Max.Y, chartAxisColor)
+
+	return c
+}
+
+// x returns the center of the i-th slot along the x axis.
+func (c *chartCanvas) x(i int) int {
+	slot := float64(c.plot.Dx()) / float64(len(c.labels))
+	return c.plot.Min.X + int(slot*(float64(i)+0.5))
+}
+
+func (c *chartCanvas) y(value float64) int {
+	if c.maxY <= 0 {
+		return c.plot.Max.Y
+	}
+	return c.plot.Max.Y - int(float64(c.plot.Dy())*math.Min(value, c.maxY)/c.maxY)
+}
+
+// xLabels draws the labels under the x axis, skipping some of them when there are more than
+// CHART_MAX_X_LABELS.
+func (c *chartCanvas) xLabels() {
+	step := (len(c.labels) + CHART_MAX_X_LABELS - 1) / CHART_MAX_X_LABELS
+	for i, label := range c.labels {
+		if i%step != 0 {
+			continue
+		}
+		width := font.MeasureString(basicfont.Face7x13, label).Ceil()
+		c.text(c.x(i)-width/2, c.plot.Max.Y+18, label, chartTextColor)
+	}
+}
+
+func (c *chartCanvas) bars(values []float64) {
+	// e.g. a report window without days
+	if len(c.labels) == 0 {
+		return
+	}
+	slot := c.plot.Dx() / len(c.labels)
+	for i, value := range values {
+		rect := image.Rect(c.x(i)-slot*3/10, c.y(value), c.x(i)+slot*3/10, c.plot.Max.Y)
+		draw.Draw(c.img, rect, &image.Uniform{chartBarColor}, image.Point{}, draw.Src)
+	}
+}
+
+func (c *chartCanvas) polyline(values []float64) {
+	for i := 1; i < len(values); i++ {
+		c.thickLine(c.x(i-1), c.y(values[i-1]), c.x(i), c.y(values[i]), chartBarColor)
+	}
+}
+
+func (c *chartCanvas) dashedHorizontalLine(value float64, col color.Color) {
+	y := c.y(value)
+	for x := c.plot.Min.X; x < c.plot.Max.X; x += 12 {
+		c.thickLine(x, y, int(math.Min(float64(x+6), float64(c.plot.Max.X))), y, col)
+	}
+}
+
+// line draws a line with Bresenham's algorithm.
+func (c *chartCanvas) line(x0, y0, x1, y1 int, col color.Color) {
+	dx := int(math.Abs(float64(x1 - x0)))
+	dy := -int(math.Abs(float64(y1 - y0)))
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		c.img.Set(x0, y0, col)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func (c *chartCanvas) thickLine(x0, y0, x1, y1 int, col color.Color) {
+	for offset := -1; offset <= 1; offset++ {
+		c.line(x0, y0+offset, x1, y1+offset, col)
+	}
+}
+
+func (c *chartCanvas) text(x, y int, text string, col color.Color) {
+	drawer := &font.Drawer{
+		Dst:  c.img,
+		Src:  image.NewUniform(col),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y),
+	}
+	drawer.DrawString(text)
+}
+
+func (c *chartCanvas) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// chartTitle localizes a title of a chart. The font of the charts only has Latin glyphs, so a title
+// in another script, e.g. Japanese, falls back to English.
+func chartTitle(locale Locale, title func(locale Locale) string) string {
+	text := title(locale)
+	for _, r := range text {
+		if _, ok := basicfont.Face7x13.GlyphAdvance(r); !ok {
+			return title(locales[DEFAULT_LOCALE])
+		}
+	}
+	return text
+}
+
+// renderWeeklyStepsChart draws a bar chart of the days of the report window with the goal line.
+func renderWeeklyStepsChart(weekly []DailyValue, window ReportWindow, goal float64, locale Locale) ([]byte, error) {
+	var labels []string
+	var values []float64
+	maxY := goal
+	for _, dailyValue := range weekly {
+		labels = append(labels, dailyValue.Date.Format(YEARLY_REPORT_DATE_FORMAT))
+		values = append(values, dailyValue.Value)
+		maxY = math.Max(maxY, dailyValue.Value)
+	}
+
+	title := chartTitle(locale, func(locale Locale) string {
+		return fmt.Sprintf(locale.message("chartSteps"), locale.formatDate(window.Start), locale.formatDate(window.End.AddDate(0, 0, -1)))
+	})
+	c := newChartCanvas(title, labels, maxY*1.1)
+	c.bars(values)
+	c.dashedHorizontalLine(goal, chartGoalColor)
+	c.xLabels()
+	return c.encode()
+}
+
+// renderWeeklyTrendChart draws a line of the weekly totals for the last CHART_TREND_WEEKS weeks ending yesterday.
+func renderWeeklyTrendChart(lifetimeData map[time.Time]float64, today time.Time, locale Locale) ([]byte, error) {
+	weekEnd := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+	firstDate := weekEnd.AddDate(0, 0, -7*CHART_TREND_WEEKS)
+
+	labels := make([]string, CHART_TREND_WEEKS)
+	values := make([]float64, CHART_TREND_WEEKS)
+	maxY := 0.0
+	for i := 0; i < CHART_TREND_WEEKS; i++ {
+		weekStart := firstDate.AddDate(0, 0, 7*i)
+		labels[i] = weekStart.Format(YEARLY_REPORT_DATE_FORMAT)
+		for d := 0; d < 7; d++ {
+			values[i] += lifetimeData[weekStart.AddDate(0, 0, d)]
+		}
+		maxY = math.Max(maxY, values[i])
+	}
+
+	title := chartTitle(locale, func(locale Locale) string {
+		return fmt.Sprintf(locale.message("chartWeeklyTrend"), CHART_TREND_WEEKS)
+	})
+	c := newChartCanvas(title, labels, maxY*1.1)
+	c.polyline(values)
+	c.xLabels()
+	return c.encode()
+}
+
+// renderMonthlyRunningChart draws a bar chart of the running distance per month of this year.
+func renderMonthlyRunningChart(yearlyRunningLog map[time.Time]float64, today time.Time, locale Locale) ([]byte, error) {
+	labels := make([]string, int(today.Month()))
+	values := make([]float64, int(today.Month()))
+	for i := range labels {
+		labels[i] = time.Month(i + 1).String()[:3]
+	}
+
+	maxY := 0.0
+	for startTime, distance := range yearlyRunningLog {
+		if startTime.Year() != today.Year() || startTime.Month() > today.Month() {
+			continue
+		}
+		values[startTime.Month()-1] += distance
+		maxY = math.Max(maxY, values[startTime.Month()-1])
+	}
+
+	title := chartTitle(locale, func(locale Locale) string {
+		return locale.message("chartMonthlyRunning")
+	})
+	c := newChartCanvas(title, labels, maxY*1.1)
+	c.bars(values)
+	c.xLabels()
+	return c.encode()
+}
+
+// uploadChart puts the image under charts/<hashed user ID>/<date>/ so that URLs do not reveal the user ID.
+func uploadChart(ctx context.Context, store BlobStore, userID string, today time.Time, name string, image []byte) (string, error) {
+	hash := sha256.Sum256([]byte(userID))
+	key := "charts/" + hex.EncodeToString(hash[:8]) + "/" + today.Format(DATE_FORMAT) + "/" + name + ".png"
+	return store.Put(ctx, key, image, "image/png")
+}
+
+func attachStepsCharts(ctx context.Context, store BlobStore, userID string, report *Report, data TimeSeriesReportData, lifetimeData map[time.Time]float64, settings UserSettings) error {
+	locale, err := lookupLocale(settings.Locale)
+	if err != nil {
+		return err
+	}
+
+	weekly, err := renderWeeklyStepsChart(data.Weekly, data.Window, float64(settings.stepsGoal()), locale)
+	if err != nil {
+		return err
+	}
+
+	trend, err := renderWeeklyTrendChart(lifetimeData, data.Today, locale)
+	if err != nil {
+		return err
+	}
+
+	charts := []struct {
+		name  string
+		image []byte
+	}{
+		{"weekly-steps", weekly},
+		{"weekly-trend", trend},
+	}
+	for _, chart := range charts {
+		url, err := uploadChart(ctx, store, userID, data.Today, chart.name, chart.image)
+		if err != nil {
+			return err
+		}
+		report.ImageURLs = append(report.ImageURLs, url)
+	}
+
+	return nil
+}
+
+func attachRunningCharts(ctx context.Context, store BlobStore, userID string, report *Report, yearlyRunningLog map[time.Time]float64, today time.Time, settings UserSettings) error {
+	locale, err := lookupLocale(settings.Locale)
+	if err != nil {
+		return err
+	}
+
+	monthly, err := renderMonthlyRunningChart(yearlyRunningLog, today, locale)
+	if err != nil {
+		return err
+	}
+
+	url, err := uploadChart(ctx, store, userID, today, "monthly-running", monthly)
+	if err != nil {
+		return err
+	}
+	report.ImageURLs = append(report.ImageURLs, url)
+
+	return nil
+}
+
+// niceCeil rounds max up to 1, 2 or 5 times a power of 10 so that grid labels are round numbers.
+func niceCeil(max float64) float64 {
+	if max <= 0 {
+		return 1
+	}
+	exponent := math.Pow(10, math.Floor(math.Log10(max)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if max <= m*exponent {
+			return m * exponent
+		}
+	}
+	return 10 * exponent
+}
+
+func formatCompactNumber(value float64) string {
+	if value >= 1000 {
+		return strconv.FormatFloat(roundToDecimal(value/1000), 'f', -1, 64) + "k"
+	}
+	return strconv.FormatFloat(roundToDecimal(value), 'f', -1, 64)
+}
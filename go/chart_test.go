@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderWeeklyStepsChart(t *testing.T) {
+	var weekly []DailyValue
+	for i := 0; i < 7; i++ {
+		weekly = append(weekly, DailyValue{time.Date(2024, time.January, 7+i, 0, 0, 0, 0, time.Local), float64(i * 2000)})
+	}
+
+	window := ReportWindow{Start: weekly[0].Date, End: weekly[6].Date.AddDate(0, 0, 1)}
+	body, err := renderWeeklyStepsChart(weekly, window, DEFAULT_STEPS_GOAL, locales["en"])
+	assert.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(body))
+	assert.NoError(t, err)
+	assert.Equal(t, CHART_WIDTH, img.Bounds().Dx())
+	assert.Equal(t, CHART_HEIGHT, img.Bounds().Dy())
+
+	// the goal line is drawn at the start of the plot area
+	c := newChartCanvas("", make([]string, 7), 12000*1.1)
+	r, g, b, _ := img.At(CHART_MARGIN_LEFT+2, c.y(DEFAULT_STEPS_GOAL)).RGBA()
+	assert.Equal(t, []uint32{uint32(chartGoalColor.R), uint32(chartGoalColor.G), uint32(chartGoalColor.B)}, []uint32{r >> 8, g >> 8, b >> 8})
+}
+
+func TestRenderTrendAndRunningCharts(t *testing.T) {
+	today := time.Date(2024, time.March, 9, 0, 0, 0, 0, time.Local)
+
+	_, err := renderWeeklyTrendChart(map[time.Time]float64{}, today, locales["ja"])
+	assert.NoError(t, err)
+
+	body, err := renderMonthlyRunningChart(map[time.Time]float64{
+		time.Date(2024, time.February, 1, 7, 0, 0, 0, time.Local): 5,
+		time.Date(2023, time.December, 1, 7, 0, 0, 0, time.Local): 5,
+	}, today, locales["en"])
+	assert.NoError(t, err)
+	_, err = png.Decode(bytes.NewReader(body))
+	assert.NoError(t, err)
+}
+
+func TestRenderWeeklyStepsChartWindow(t *testing.T) {
+	// a long custom window
+	var weekly []DailyValue
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local)
+	for i := 0; i < 60; i++ {
+		weekly = append(weekly, DailyValue{start.AddDate(0, 0, i), 8000})
+	}
+	_, err := renderWeeklyStepsChart(weekly, ReportWindow{Start: start, End: start.AddDate(0, 0, 60)}, DEFAULT_STEPS_GOAL, locales["en"])
+	assert.NoError(t, err)
+
+	// a window without days
+	_, err = renderWeeklyStepsChart(nil, ReportWindow{Start: start, End: start}, DEFAULT_STEPS_GOAL, locales["en"])
+	assert.NoError(t, err)
+}
+
+func TestChartTitle(t *testing.T) {
+	window := ReportWindow{Start: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local), End: time.Date(2024, time.January, 8, 0, 0, 0, 0, time.Local)}
+	title := func(locale Locale) string {
+		return fmt.Sprintf(locale.message("chartSteps"), locale.formatDate(window.Start), locale.formatDate(window.End.AddDate(0, 0, -1)))
+	}
+	assert.Equal(t, "Steps (1/1 - 1/7)", chartTitle(locales["en"], title))
+	// the font has no Japanese glyphs
+	assert.Equal(t, "Steps (1/1 - 1/7)", chartTitle(locales["ja"], title))
+	assert.Equal(t, "Pasos (1/1 - 1/7)", chartTitle(Locale{YearlyDateFormat: YEARLY_REPORT_DATE_FORMAT, Messages: map[string]string{"chartSteps": "Pasos (%s - %s)"}}, title))
+}
+
+func TestNiceCeil(t *testing.T) {
+	tests := []struct {
+		input    float64
+		expected float64
+	}{
+		{0, 1},
+		{0.7, 1},
+		{11000, 20000},
+		{14000, 20000},
+		{42000, 50000},
+		{70000, 100000},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, niceCeil(test.input))
+	}
+}
+
+func TestAttachRunningCharts(t *testing.T) {
+	store := &LocalBlobStore{Dir: t.TempDir(), BaseURL: "https://example.com/blobs"}
+	today := time.Date(2024, time.March, 9, 0, 0, 0, 0, time.Local)
+
+	report := Report{Text: "report"}
+	err := attachRunningCharts(context.Background(), store, "U1", &report, map[time.Time]float64{}, today, UserSettings{})
+	assert.NoError(t, err)
+	assert.Len(t, report.ImageURLs, 1)
+	assert.Regexp(t, `^https://example.com/blobs/charts/[0-9a-f]{16}/2024-03-09/monthly-running.png$`, report.ImageURLs[0])
+
+	messages := reportMessages(report)
+	assert.Len(t, messages, 2)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+const (
+	ALERTS_TEMPLATE_NAME = "alerts.tmpl"
+
+	ALERT_NUDGE           = "nudge"
+	ALERT_YEARLY_RECORD   = "yearlyRecord"
+	ALERT_LIFETIME_RECORD = "lifetimeRecord"
+
+	DEFAULT_MAX_ALERTS_PER_DAY = 3
+	// records of alerts are kept for this many days to suppress duplicates
+	ALERT_HISTORY_DAYS = 7
+)
+
+// Alert is a message pushed on the day something happened, as opposed to the weekly reports.
+type Alert struct {
+	Kind string
+	Date time.Time
+	// rank of the day in the ranking, for record alerts
+	Rank int
+	Text string
+}
+
+// key identifies an alert within the alert history of a user.
+func (alert Alert) key() string {
+	return alert.Date.Format(DATE_FORMAT) + "/" + alert.Kind
+}
+
+// AlertData is the data model the sections of alerts.tmpl are rendered from.
+type AlertData struct {
+	Date      time.Time
+	Steps     int
+	Goal      int
+	Remaining int
+	// message key of the ranking, "yearlyTop" or "lifetimeTop"
+	Ranking string
+	Rank    int
+}
+
+// findRank returns the 1-based rank of date in ranking, or 0 when it is not ranked.
+func findRank(ranking []DailyValue, date time.Time) int {
+	for i, dailyValue := range ranking {
+		if dailyValue.Date.Equal(date) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// buildRecordAlert returns an alert when the steps of date entered the yearly or lifetime top 5.
+// A lifetime record is a yearly record as well, so only the lifetime one is returned then.
+func buildRecordAlert(lifetimeData map[time.Time]float64, date time.Time, settings UserSettings) (*Alert, error) {
+	data := buildTimeSeriesReportData(stepsResource, lifetimeData, date, lastSevenDays(date))
+
+	alert := Alert{Date: date}
+	alertData := AlertData{Date: date, Steps: int(lifetimeData[date])}
+	if rank := findRank(data.LifetimeTop, date); rank > 0 {
+		alert.Kind, alert.Rank = ALERT_LIFETIME_RECORD, rank
+		alertData.Ranking, alertData.Rank = "lifetimeTop", rank
+	} else if rank := findRank(data.YearlyTop, date); rank > 0 {
+		alert.Kind, alert.Rank = ALERT_YEARLY_RECORD, rank
+		alertData.Ranking, alertData.Rank = "yearlyTop", rank
+	} else {
+		return nil, nil
+	}
+
+	text, err := renderAlert(alertData, settings, "record")
+	if err != nil {
+		return nil, err
+	}
+	alert.Text = text
+	return &alert, nil
+}
+
+// buildDailyAlerts returns the alerts of today: a nudge when the steps are below the goal
+// and a record alert when the steps are in the top 5.
+func buildDailyAlerts(lifetimeData map[time.Time]float64, todaySteps float64, today time.Time, settings UserSettings) ([]Alert, error) {
+	date := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+
+	withToday := map[time.Time]float64{}
+	for k, v := range lifetimeData {
+		withToday[k] = v
+	}
+	withToday[date] = todaySteps
+
+	var alerts []Alert
+	if todayData := buildTodayReportData(todaySteps, date, settings.stepsGoal()); todayData.Remaining > 0 {
+		text, err := renderAlert(AlertData{
+			Date:      date,
+			Steps:     todayData.Steps,
+			Goal:      todayData.Goal,
+			Remaining: todayData.Remaining,
+		}, settings, "nudge")
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, Alert{Kind: ALERT_NUDGE, Date: date, Text: text})
+	}
+
+	recordAlert, err := buildRecordAlert(withToday, date, settings)
+	if err != nil {
+		return nil, err
+	}
+	if recordAlert != nil {
+		alerts = append(alerts, *recordAlert)
+	}
+
+	return alerts, nil
+}
+
+func renderAlert(data AlertData, settings UserSettings, section string) (string, error) {
+	tmpl, err := loadReportTemplate(settings, nil, ALERTS_TEMPLATE_NAME)
+	if err != nil {
+		return "", err
+	}
+
+	return executeReportSection(tmpl, section, data)
+}
+
+// alertHistory is the state of the alerts sent to a user.
+type alertHistory struct {
+	SentAt []time.Time `json:"sentAt"`
+	// rank of the record alerts sent, or 0 for the other alerts, by Alert.key
+	Sent map[string]int `json:"sent"`
+}
+
+// AlertLimiter suppresses duplicated alerts and limits the number of alerts per user.
+type AlertLimiter struct {
+	Store     StateStore
+	MaxPerDay int
+}
+
+func alertHistoryKey(userID string) string {
+	return "alerts/" + userID
+}
+
+func (limiter *AlertLimiter) history(ctx context.Context, userID string) (alertHistory, error) {
+	history := alertHistory{Sent: map[string]int{}}
+	value, err := limiter.Store.Get(ctx, alertHistoryKey(userID))
+	if err != nil || value == nil {
+		return history, err
+	}
+
+	if err := json.Unmarshal(value, &history); err != nil {
+		return history, fmt.Errorf("failed to decode alert history: %v", err)
+	}
+	if history.Sent == nil {
+		history.Sent = map[string]int{}
+	}
+	return history, nil
+}
+
+// filter returns the alerts which may be sent now and the history updated as if they were sent.
+// An alert is sent once a day, except a record alert whose rank went up.
+func (limiter *AlertLimiter) filter(history alertHistory, alerts []Alert, now time.Time) ([]Alert, alertHistory) {
+	var sentAt []time.Time
+	for _, t := range history.SentAt {
+		if now.Sub(t) < 24*time.Hour {
+			sentAt = append(sentAt, t)
+		}
+	}
+	oldest := now.AddDate(0, 0, -ALERT_HISTORY_DAYS).Format(DATE_FORMAT)
+	sent := map[string]int{}
+	for key, rank := range history.Sent {
+		if date, _, _ := strings.Cut(key, "/"); date >= oldest {
+			sent[key] = rank
+		}
+	}
+
+	var allowed []Alert
+	for _, alert := range alerts {
+		if len(sentAt) >= limiter.MaxPerDay {
+			break
+		}
+		if rank, ok := sent[alert.key()]; ok && (alert.Rank == 0 || rank <= alert.Rank) {
+			continue
+		}
+		allowed = append(allowed, alert)
+		sentAt = append(sentAt, now)
+		sent[alert.key()] = alert.Rank
+	}
+
+	return allowed, alertHistory{SentAt: sentAt, Sent: sent}
+}
+
+// send pushes the alerts allowed now and records them.
+func (limiter *AlertLimiter) send(ctx context.Context, pusher messagePusher, userID string, alerts []Alert, now time.Time) error {
+	history, err := limiter.history(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	allowed, history := limiter.filter(history, alerts, now)
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	var messages []messaging_api.MessageInterface
+	for _, alert := range allowed {
+		messages = append(messages, messaging_api.TextMessage{
+			Text: alert.Text,
+		})
+	}
+	if err := pushMessages(pusher, userID, messages); err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	return limiter.Store.Put(ctx, alertHistoryKey(userID), value)
+}
+
+// dailyHandler is the evening check: it nudges the user when today's steps are below the
+// goal and alerts a new record.
+func dailyHandler(ctx context.Context, instances *Instances, userID string, now time.Time, getAccessToken func(ctx context.Context) (string, error)) error {
+	lineChannelToken, err := instances.getParameter(os.Getenv("LINE_CHANNEL_TOKEN_PARAMETER_NAME"))
+	if err != nil {
+		return err
+	}
+
+	settings, err := loadUserSettings(userID)
+	if err != nil {
+		return err
+	}
+
+	quiet, err := settings.inQuietHours(now)
+	if err != nil || quiet {
+		return err
+	}
+
+	newAccessToken, err := getAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	lifetimeData, err := getLifetimeTimeSeriesHistory(ctx, newAccessToken, stepsResource, now, getTimeSeriesByDateRange)
+	if err != nil {
+		return err
+	}
+
+	todaySteps, err := getDailyValue(ctx, newAccessToken, stepsResource, now, getTimeSeriesByDateRange)
+	if err != nil {
+		return err
+	}
+
+	alerts, err := buildDailyAlerts(lifetimeData, todaySteps, now, settings)
+	if err != nil {
+		return err
+	}
+
+	stateStore, err := instances.newStateStore()
+	if err != nil {
+		return err
+	}
+	// without a state store alerts are only limited within this invocation
+	if stateStore == nil {
+		stateStore = &MemoryStateStore{}
+	}
+
+	bot, err := messaging_api.NewMessagingApiAPI(*lineChannelToken)
+	if err != nil {
+		return err
+	}
+
+	limiter := &AlertLimiter{Store: stateStore, MaxPerDay: settings.maxAlertsPerDay()}
+	return limiter.send(ctx, bot, userID, alerts, now)
+}
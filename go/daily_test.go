@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/stretchr/testify/assert"
+)
+
+func dailyLifetimeData(today time.Time) map[time.Time]float64 {
+	lifetimeData := map[time.Time]float64{}
+	for i := 1; i <= 30; i++ {
+		lifetimeData[today.AddDate(0, 0, -i)] = float64(5000 + i*100)
+	}
+	// lifetime records of last year
+	for i := 0; i < 5; i++ {
+		lifetimeData[today.AddDate(-1, 0, i)] = float64(20000 + i)
+	}
+	return lifetimeData
+}
+
+func TestBuildDailyAlerts(t *testing.T) {
+	today := time.Date(2024, time.March, 3, 0, 0, 0, 0, time.Local)
+	lifetimeData := dailyLifetimeData(today)
+
+	// below the goal and not ranked
+	alerts, err := buildDailyAlerts(lifetimeData, 6543, today.Add(20*time.Hour), UserSettings{})
+	assert.NoError(t, err)
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, ALERT_NUDGE, alerts[0].Kind)
+	assert.Equal(t, "You are below your steps goal today. Let's take a walk!\n\n6,543 / 10,000\nRemaining: 3,457", alerts[0].Text)
+
+	// second in this year, but not in the lifetime top 5
+	alerts, err = buildDailyAlerts(lifetimeData, 7950, today, UserSettings{StepsGoal: 7000})
+	assert.NoError(t, err)
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, ALERT_YEARLY_RECORD, alerts[0].Kind)
+	assert.Equal(t, 2, alerts[0].Rank)
+	assert.Equal(t, "New record!\n\nTop Records in This Year #2\n7,950(3/3)", alerts[0].Text)
+
+	// lifetime best
+	alerts, err = buildDailyAlerts(lifetimeData, 25000, today, UserSettings{Locale: "ja"})
+	assert.NoError(t, err)
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, ALERT_LIFETIME_RECORD, alerts[0].Kind)
+	assert.Equal(t, 1, alerts[0].Rank)
+	assert.Equal(t, "新記録！\n\n歴代トップ記録 #1\n25,000(3月3日)", alerts[0].Text)
+}
+
+func TestAlertLimiter(t *testing.T) {
+	now := time.Date(2024, time.March, 3, 20, 0, 0, 0, time.Local)
+	today := time.Date(2024, time.March, 3, 0, 0, 0, 0, time.Local)
+	limiter := &AlertLimiter{Store: &MemoryStateStore{}, MaxPerDay: 3}
+	pusher := &mockPusher{failAt: -1}
+
+	nudge := Alert{Kind: ALERT_NUDGE, Date: today, Text: "nudge"}
+	record := Alert{Kind: ALERT_YEARLY_RECORD, Date: today, Rank: 3, Text: "record"}
+
+	err := limiter.send(context.Background(), pusher, "U1", []Alert{nudge, record}, now)
+	assert.NoError(t, err)
+	assert.Len(t, pusher.requests, 1)
+	assert.Len(t, pusher.requests[0].Messages, 2)
+
+	// the same alerts are not sent again
+	err = limiter.send(context.Background(), pusher, "U1", []Alert{nudge, record}, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, pusher.requests, 1)
+
+	// a higher rank is alerted
+	record.Rank, record.Text = 1, "better record"
+	err = limiter.send(context.Background(), pusher, "U1", []Alert{record}, now.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, pusher.requests, 2)
+	assert.Equal(t, "better record", pusher.requests[1].Messages[0].(messaging_api.TextMessage).Text)
+
+	// the limit of 3 alerts in 24 hours has been reached
+	tomorrow := Alert{Kind: ALERT_NUDGE, Date: today.AddDate(0, 0, 1), Text: "nudge"}
+	err = limiter.send(context.Background(), pusher, "U1", []Alert{tomorrow}, now.Add(23*time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, pusher.requests, 2)
+
+	err = limiter.send(context.Background(), pusher, "U1", []Alert{tomorrow}, now.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, pusher.requests, 3)
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed dashboard
+var dashboardAssets embed.FS
+
+const (
+	DASHBOARD_PATH           = "/dashboard/"
+	DASHBOARD_COOKIE_NAME    = "dashboard_session"
+	DASHBOARD_COOKIE_MAX_AGE = 30 * 24 * time.Hour
+	// a link of the webhook signs in until then
+	DASHBOARD_LINK_TTL = 24 * time.Hour
+	// a token of a link is not a valid session and vice versa
+	DASHBOARD_LINK_PURPOSE    = "link"
+	DASHBOARD_SESSION_PURPOSE = "session"
+
+	DASHBOARD_HEATMAP_WEEKS = 53
+	DASHBOARD_TREND_WEEKS   = 12
+	DASHBOARD_TREND_MONTHS  = 12
+)
+
+// HeatmapDay is a cell of the calendar heatmap. Level is 0 without data, then 1 to 4 by the
+// ratio of the steps to the goal.
+type HeatmapDay struct {
+	Date  time.Time
+	Steps float64
+	Level int
+	// false for the days after today which fill the last week
+	InRange bool
+}
+
+// TrendPoint is a bar of a trend chart. Percent is relative to the largest bar.
+type TrendPoint struct {
+	Start   time.Time
+	Value   float64
+	Percent float64
+}
+
+// DashboardRun is a run in the running log of the dashboard.
+type DashboardRun struct {
+	StartTime time.Time
+	Distance  float64
+	Duration  time.Duration
+	// min/km, e.g. "5'30\""
+	Pace string
+}
+
+// DashboardData is the data model of the dashboard page.
+type DashboardData struct {
+	UserID         string
+	Today          time.Time
+	Goal           int
+	Heatmap        [][]HeatmapDay
+	WeeklyTrend    []TrendPoint
+	MonthlyTrend   []TrendPoint
+	Steps          TimeSeriesReportData
+	Runs           []DashboardRun
+	YearlyDistance float64
+}
+
+func heatmapLevel(steps float64, goal int) int {
+	switch ratio := steps / float64(goal); {
+	case steps <= 0:
+		return 0
+	case ratio < 0.5:
+		return 1
+	case ratio < 1:
+		return 2
+	case ratio < 1.5:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func formatPace(distance float64, duration time.Duration) string {
+	if distance <= 0 {
+		return ""
+	}
+	seconds := int(duration.Seconds()/distance + 0.5)
+	return fmt.Sprintf("%d'%02d\"", seconds/60, seconds%60)
+}
+
+// formatDuration formats a duration as h:mm:ss, or m:ss under an hour.
+func formatDuration(duration time.Duration) string {
+	seconds := int(duration.Round(time.Second).Seconds())
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// withPercent sets the percent of each point relative to the largest value.
+func withPercent(points []TrendPoint) []TrendPoint {
+	max := 0.0
+	for _, point := range points {
+		if point.Value > max {
+			max = point.Value
+		}
+	}
+	if max == 0 {
+		return points
+	}
+	for i := range points {
+		points[i].Percent = points[i].Value / max * 100
+	}
+	return points
+}
+
+// buildDashboardData builds the dashboard from the stored steps and this year's activities.
+func buildDashboardData(steps map[time.Time]float64, activities []ActivityRecord, today time.Time, goal int) DashboardData {
+	todayDate := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+	data := DashboardData{
+		Today: today,
+		Goal:  goal,
+		Steps: buildTimeSeriesReportData(stepsResource, steps, today, lastSevenDays(today)),
+	}
+
+	// weeks start on Sunday, the last one contains today
+	start := todayDate.AddDate(0, 0, -int(todayDate.Weekday())-7*(DASHBOARD_HEATMAP_WEEKS-1))
+	for week := 0; week < DASHBOARD_HEATMAP_WEEKS; week++ {
+		var days []HeatmapDay
+		for day := 0; day < 7; day++ {
+			date := start.AddDate(0, 0, week*7+day)
+			days = append(days, HeatmapDay{
+				Date:    date,
+				Steps:   steps[date],
+				Level:   heatmapLevel(steps[date], goal),
+				InRange: !date.After(todayDate),
+			})
+		}
+		data.Heatmap = append(data.Heatmap, days)
+	}
+
+	// the weeks of the weekly report, oldest first
+	for week := DASHBOARD_TREND_WEEKS; week > 0; week-- {
+		weekStart := todayDate.AddDate(0, 0, -7*week)
+		point := TrendPoint{Start: weekStart}
+		for day := 0; day < 7; day++ {
+			point.Value += steps[weekStart.AddDate(0, 0, day)]
+		}
+		data.WeeklyTrend = append(data.WeeklyTrend, point)
+	}
+	data.WeeklyTrend = withPercent(data.WeeklyTrend)
+
+	// this month so far is the last bar
+	thisMonth := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+	for month := DASHBOARD_TREND_MONTHS - 1; month >= 0; month-- {
+		monthStart := thisMonth.AddDate(0, -month, 0)
+		point := TrendPoint{Start: monthStart}
+		for date := monthStart; date.Before(monthStart.AddDate(0, 1, 0)); date = date.AddDate(0, 0, 1) {
+			point.Value += steps[date]
+		}
+		data.MonthlyTrend = append(data.MonthlyTrend, point)
+	}
+	data.MonthlyTrend = withPercent(data.MonthlyTrend)
+
+	yearStart := time.Date(today.Year(), time.January, 1, 0, 0, 0, 0, today.Location())
+	for _, record := range activities {
+		if record.Name != "Run" || record.StartTime.Before(yearStart) {
+			continue
+		}
+		data.Runs = append(data.Runs, DashboardRun{
+			StartTime: record.StartTime,
+			Distance:  record.Distance,
+			Duration:  record.Duration,
+			Pace:      formatPace(record.Distance, record.Duration),
+		})
+		data.YearlyDistance += record.Distance
+	}
+	// newest first
+	sort.SliceStable(data.Runs, func(i, j int) bool {
+		return data.Runs[i].StartTime.After(data.Runs[j].StartTime)
+	})
+
+	return data
+}
+
+// dashboardToken signs the user and the expiry of a link or a session.
+func dashboardToken(secret string, purpose string, userID string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("dashboard:" + purpose + ":" + userID + ":" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyDashboardToken reports whether the token signs the user and the expiry, and the expiry
+// has not passed.
+func verifyDashboardToken(secret string, purpose string, userID string, expires string, token string, now time.Time) bool {
+	if secret == "" || userID == "" {
+		return false
+	}
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !now.Before(time.Unix(expiresUnix, 0)) {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(dashboardToken(secret, purpose, userID, expiresUnix)))
+}
+
+// dashboardLink returns the URL which signs the user in to the dashboard until DASHBOARD_LINK_TTL
+// has passed.
+func dashboardLink(baseURL string, secret string, userID string, now time.Time) string {
+	expires := now.Add(DASHBOARD_LINK_TTL).Unix()
+	query := url.Values{}
+	query.Set("user", userID)
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("token", dashboardToken(secret, DASHBOARD_LINK_PURPOSE, userID, expires))
+	return strings.TrimRight(baseURL, "/") + DASHBOARD_PATH + "?" + query.Encode()
+}
+
+// DashboardServer serves the dashboard of the history. Users sign in with the link of
+// dashboardLink, after which a cookie keeps them signed in.
+type DashboardServer struct {
+	History HistoryStore
+	Secret  string
+	// only these LINE users can sign in, as the data is of a single Fitbit account
+	AllowedUserIDs []string
+
+	loadSettings func(userID string) (UserSettings, error)
+	now          func() time.Time
+}
+
+func newDashboardServer(history HistoryStore, secret string, allowedUserIDs []string) *DashboardServer {
+	return &DashboardServer{
+		History:        history,
+		Secret:         secret,
+		AllowedUserIDs: allowedUserIDs,
+		loadSettings:   loadUserSettings,
+		now:            time.Now,
+	}
+}
+
+// authenticate returns the signed in user. A valid link sets the session cookie, which has its
+// own token and expiry.
+func (server *DashboardServer) authenticate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	now := server.now()
+	query := r.URL.Query()
+	userID, expires, token := query.Get("user"), query.Get("expires"), query.Get("token")
+	purpose := DASHBOARD_LINK_PURPOSE
+	fromLink := token != ""
+	if !fromLink {
+		cookie, err := r.Cookie(DASHBOARD_COOKIE_NAME)
+		if err != nil {
+			return "", false
+		}
+		parts := strings.Split(cookie.Value, ":")
+		if len(parts) != 3 {
+			return "", false
+		}
+		userID, expires, token = parts[0], parts[1], parts[2]
+		purpose = DASHBOARD_SESSION_PURPOSE
+	}
+
+	if !verifyDashboardToken(server.Secret, purpose, userID, expires, token, now) {
+		return "", false
+	}
+	allowed := false
+	for _, allowedUserID := range server.AllowedUserIDs {
+		if userID == allowedUserID {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return "", false
+	}
+
+	if fromLink {
+		sessionExpires := now.Add(DASHBOARD_COOKIE_MAX_AGE).Unix()
+		http.SetCookie(w, &http.Cookie{
+			Name:     DASHBOARD_COOKIE_NAME,
+			Value:    userID + ":" + strconv.FormatInt(sessionExpires, 10) + ":" + dashboardToken(server.Secret, DASHBOARD_SESSION_PURPOSE, userID, sessionExpires),
+			Path:     DASHBOARD_PATH,
+			MaxAge:   int(DASHBOARD_COOKIE_MAX_AGE.Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return userID, true
+}
+
+func (server *DashboardServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, DASHBOARD_PATH+"static/") {
+		assets, err := fs.Sub(dashboardAssets, "dashboard")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.StripPrefix(DASHBOARD_PATH, http.FileServer(http.FS(assets))).ServeHTTP(w, r)
+		return
+	}
+
+	userID, ok := server.authenticate(w, r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	// the cookie is set, so the token of the link leaves the history and the Referer of the page
+	if r.URL.Query().Get("token") != "" {
+		http.Redirect(w, r, DASHBOARD_PATH, http.StatusSeeOther)
+		return
+	}
+
+	page, err := server.render(r.Context(), userID)
+	if err != nil {
+		log.Printf("failed to render the dashboard: %v", err)
+		http.Error(w, "failed to render the dashboard", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
+
+func (server *DashboardServer) render(ctx context.Context, userID string) ([]byte, error) {
+	settings, err := server.loadSettings(userID)
+	if err != nil {
+		return nil, err
+	}
+	locale, err := lookupLocale(settings.Locale)
+	if err != nil {
+		return nil, err
+	}
+
+	steps, err := server.History.GetDailyValues(ctx, stepsResource.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	today := server.now().Local()
+	yearStart := time.Date(today.Year(), time.January, 1, 0, 0, 0, 0, today.Location())
+	activities, err := server.History.GetActivities(ctx, yearStart, today.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+
+	data := buildDashboardData(steps, activities, today, settings.stepsGoal())
+	data.UserID = userID
+
+	funcs := htmltemplate.FuncMap(reportFuncMap(locale))
+	funcs["lang"] = func() string { return locale.Name }
+	funcs["duration"] = formatDuration
+	tmpl, err := htmltemplate.New("index.html").Funcs(funcs).ParseFS(dashboardAssets, "dashboard/index.html")
+	if err != nil {
+		return nil, err
+	}
+
+	var page bytes.Buffer
+	if err := tmpl.Execute(&page, data); err != nil {
+		return nil, err
+	}
+	return page.Bytes(), nil
+}
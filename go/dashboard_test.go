@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dashboardFixture stores 400 days of steps and a few runs.
+func dashboardFixture(t *testing.T, today time.Time) HistoryStore {
+	ctx := context.Background()
+	history := &FileHistoryStore{Path: filepath.Join(t.TempDir(), "history.json")}
+
+	var values []DailyValue
+	date := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.Local)
+	for i := 1; i <= 400; i++ {
+		values = append(values, DailyValue{date.AddDate(0, 0, -i), float64(1000 * (i % 20))})
+	}
+	assert.NoError(t, history.PutDailyValues(ctx, stepsResource.Name, values))
+
+	assert.NoError(t, history.PutActivities(ctx, []ActivityRecord{
+		{LogID: 1, Name: "Run", StartTime: date.AddDate(0, 0, -10).Add(7 * time.Hour), Duration: 30 * time.Minute, Distance: 6},
+		{LogID: 2, Name: "Walk", StartTime: date.AddDate(0, 0, -9).Add(7 * time.Hour), Duration: time.Hour, Distance: 4},
+		{LogID: 3, Name: "Run", StartTime: date.AddDate(0, 0, -3).Add(7 * time.Hour), Duration: 65 * time.Minute, Distance: 10},
+	}))
+	return history
+}
+
+func TestBuildDashboardData(t *testing.T) {
+	// a Wednesday
+	today := time.Date(2024, time.May, 15, 12, 0, 0, 0, time.Local)
+	date := time.Date(2024, time.May, 15, 0, 0, 0, 0, time.Local)
+	steps := map[time.Time]float64{
+		date.AddDate(0, 0, -1): 12000,
+		date.AddDate(0, 0, -2): 4000,
+		date.AddDate(0, 0, -8): 7000,
+		time.Date(2024, time.April, 30, 0, 0, 0, 0, time.Local): 20000,
+	}
+	activities := []ActivityRecord{
+		{Name: "Run", StartTime: date.AddDate(0, 0, -10), Duration: 30 * time.Minute, Distance: 6},
+		{Name: "Run", StartTime: date.AddDate(0, 0, -3), Duration: 65 * time.Minute, Distance: 10},
+		{Name: "Run", StartTime: time.Date(2023, time.December, 31, 0, 0, 0, 0, time.Local), Distance: 5},
+	}
+
+	data := buildDashboardData(steps, activities, today, 10000)
+
+	assert.Len(t, data.Heatmap, DASHBOARD_HEATMAP_WEEKS)
+	lastWeek := data.Heatmap[DASHBOARD_HEATMAP_WEEKS-1]
+	assert.Equal(t, time.Sunday, lastWeek[0].Date.Weekday())
+	assert.Equal(t, 3, lastWeek[2].Level)
+	assert.Equal(t, 1, lastWeek[1].Level)
+	assert.Equal(t, 0, lastWeek[3].Level)
+	assert.True(t, lastWeek[3].InRange)
+	assert.False(t, lastWeek[4].InRange)
+
+	assert.Len(t, data.WeeklyTrend, DASHBOARD_TREND_WEEKS)
+	assert.Equal(t, float64(16000), data.WeeklyTrend[DASHBOARD_TREND_WEEKS-1].Value)
+	assert.Equal(t, float64(100), data.WeeklyTrend[DASHBOARD_TREND_WEEKS-3].Percent)
+
+	assert.Len(t, data.MonthlyTrend, DASHBOARD_TREND_MONTHS)
+	assert.Equal(t, time.Date(2024, time.May, 1, 0, 0, 0, 0, time.Local), data.MonthlyTrend[DASHBOARD_TREND_MONTHS-1].Start)
+	assert.Equal(t, float64(23000), data.MonthlyTrend[DASHBOARD_TREND_MONTHS-1].Value)
+	assert.Equal(t, float64(20000), data.MonthlyTrend[DASHBOARD_TREND_MONTHS-2].Value)
+
+	assert.Equal(t, float64(20000), data.Steps.LifetimeTop[0].Value)
+
+	assert.Len(t, data.Runs, 2)
+	assert.Equal(t, float64(10), data.Runs[0].Distance)
+	assert.Equal(t, "6'30\"", data.Runs[0].Pace)
+	assert.Equal(t, float64(16), data.YearlyDistance)
+}
+
+func TestFormatDuration(t *testing.T) {
+	assert.Equal(t, "30:00", formatDuration(30*time.Minute))
+	assert.Equal(t, "1:05:09", formatDuration(65*time.Minute+9*time.Second))
+}
+
+func TestDashboardServer(t *testing.T) {
+	today := time.Date(2024, time.May, 15, 12, 0, 0, 0, time.Local)
+	server := newDashboardServer(dashboardFixture(t, today), "secret", []string{"U1", "U2"})
+	server.now = func() time.Time { return today }
+	server.loadSettings = func(userID string) (UserSettings, error) {
+		return parseUserSettings(`{"users": {"U2": {"locale": "ja"}}}`, userID)
+	}
+
+	get := func(target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	expires := today.Add(time.Hour).Unix()
+	signIn := func(secret string, userID string, expires int64) string {
+		return fmt.Sprintf("%s?user=%s&expires=%d&token=%s", DASHBOARD_PATH, userID, expires, dashboardToken(secret, DASHBOARD_LINK_PURPOSE, userID, expires))
+	}
+	assert.Equal(t, http.StatusUnauthorized, get(DASHBOARD_PATH).Code)
+	assert.Equal(t, http.StatusUnauthorized, get(signIn("other", "U1", expires)).Code)
+	// a valid token of a user who is not allowed
+	assert.Equal(t, http.StatusUnauthorized, get(signIn("secret", "U3", expires)).Code)
+	// an expired link
+	assert.Equal(t, http.StatusUnauthorized, get(dashboardLink("http://localhost:8080/", "secret", "U1", today.Add(-DASHBOARD_LINK_TTL))).Code)
+	// the expiry is signed
+	assert.Equal(t, http.StatusUnauthorized, get(strings.Replace(signIn("secret", "U1", expires), fmt.Sprint(expires), fmt.Sprint(expires+3600), 1)).Code)
+	assert.Equal(t, http.StatusSeeOther, get(signIn("secret", "U1", expires)).Code)
+
+	// the link redirects to the page without the token
+	rec := get(dashboardLink("http://localhost:8080/", "secret", "U1", today))
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, DASHBOARD_PATH, rec.Header().Get("Location"))
+	assert.NotContains(t, rec.Body.String(), "Top Records in Lifetime")
+
+	// the cookie keeps the user signed in
+	cookies := rec.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.True(t, cookies[0].HttpOnly)
+	assert.NotContains(t, cookies[0].Value, dashboardToken("secret", DASHBOARD_LINK_PURPOSE, "U1", today.Add(DASHBOARD_LINK_TTL).Unix()))
+	rec = get(DASHBOARD_PATH, cookies...)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Top Records in Lifetime")
+	assert.Contains(t, rec.Body.String(), "19,000")
+	assert.Contains(t, rec.Body.String(), "1:05:00")
+
+	// the token of a link is not a session
+	linkCookie := &http.Cookie{Name: DASHBOARD_COOKIE_NAME, Value: fmt.Sprintf("U1:%d:%s", expires, dashboardToken("secret", DASHBOARD_LINK_PURPOSE, "U1", expires))}
+	assert.Equal(t, http.StatusUnauthorized, get(DASHBOARD_PATH, linkCookie).Code)
+
+	// the session expires
+	server.now = func() time.Time { return today.Add(DASHBOARD_COOKIE_MAX_AGE) }
+	assert.Equal(t, http.StatusUnauthorized, get(DASHBOARD_PATH, cookies...).Code)
+	server.now = func() time.Time { return today }
+
+	// in the locale of the user
+	rec = get(dashboardLink("http://localhost:8080", "secret", "U2", today))
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	rec = get(DASHBOARD_PATH, rec.Result().Cookies()...)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "歴代トップ記録")
+
+	rec = get(DASHBOARD_PATH + "static/style.css")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), ".heatmap")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+const (
+	DEVICES_TEMPLATE_NAME = "devices.tmpl"
+
+	DEVICE_TYPE_TRACKER = "TRACKER"
+	// battery levels Fitbit reports besides High and Medium
+	DEVICE_BATTERY_LOW   = "Low"
+	DEVICE_BATTERY_EMPTY = "Empty"
+
+	DEFAULT_SYNC_WARNING_HOURS = 24
+	DEFAULT_LOW_BATTERY_LEVEL  = 20
+)
+
+type fitbitDevice struct {
+	ID            string `json:"id"`
+	DeviceVersion string `json:"deviceVersion"`
+	Type          string `json:"type"`
+	Battery       string `json:"battery"`
+	BatteryLevel  int    `json:"batteryLevel"`
+	LastSyncTime  string `json:"lastSyncTime"`
+}
+
+// Device is a tracker or a scale paired with the Fitbit account.
+type Device struct {
+	ID      string
+	Version string
+	Type    string
+	Battery string
+	// percent
+	BatteryLevel int
+	LastSyncTime time.Time
+}
+
+func getDevices(ctx context.Context, access_token string) ([]Device, error) {
+	var response []fitbitDevice
+	if err := getFitbitJSON(ctx, access_token, FITBIT_API_URL+"/1/user/-/devices.json", &response); err != nil {
+		return nil, err
+	}
+
+	var devices []Device
+	for _, device := range response {
+		d := Device{
+			ID:           device.ID,
+			Version:      device.DeviceVersion,
+			Type:         device.Type,
+			Battery:      device.Battery,
+			BatteryLevel: device.BatteryLevel,
+		}
+		if device.LastSyncTime != "" {
+			// Fitbit returns the local time of the account
+			lastSyncTime, err := time.ParseInLocation(FITBIT_TIME_FORMAT, device.LastSyncTime, time.Local)
+			if err != nil {
+				return nil, err
+			}
+			d.LastSyncTime = lastSyncTime
+		}
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+// DeviceReportData is the data model of devices.tmpl.
+type DeviceReportData struct {
+	// trackers which have not synced within the threshold
+	Stale      []Device
+	LowBattery []Device
+}
+
+func (settings UserSettings) syncWarningHours() int {
+	if settings.SyncWarningHours <= 0 {
+		return DEFAULT_SYNC_WARNING_HOURS
+	}
+	return settings.SyncWarningHours
+}
+
+func (settings UserSettings) lowBatteryLevel() int {
+	if settings.LowBatteryLevel <= 0 {
+		return DEFAULT_LOW_BATTERY_LEVEL
+	}
+	return settings.LowBatteryLevel
+}
+
+func buildDeviceReportData(devices []Device, now time.Time, settings UserSettings) DeviceReportData {
+	data := DeviceReportData{}
+	threshold := time.Duration(settings.syncWarningHours()) * time.Hour
+	for _, device := range devices {
+		// scales are synced only when used, so only trackers get stale
+		if strings.EqualFold(device.Type, DEVICE_TYPE_TRACKER) && !device.LastSyncTime.IsZero() && now.Sub(device.LastSyncTime) > threshold {
+			data.Stale = append(data.Stale, device)
+		}
+
+		lowBattery := device.Battery == DEVICE_BATTERY_LOW || device.Battery == DEVICE_BATTERY_EMPTY
+		if device.BatteryLevel > 0 && device.BatteryLevel <= settings.lowBatteryLevel() {
+			lowBattery = true
+		}
+		if lowBattery {
+			data.LowBattery = append(data.LowBattery, device)
+		}
+	}
+	return data
+}
+
+// lastTrackerSync returns the latest sync of the trackers, zero when there is none.
+func lastTrackerSync(devices []Device) time.Time {
+	var lastSync time.Time
+	for _, device := range devices {
+		if strings.EqualFold(device.Type, DEVICE_TYPE_TRACKER) && device.LastSyncTime.After(lastSync) {
+			lastSync = device.LastSyncTime
+		}
+	}
+	return lastSync
+}
+
+func renderDeviceReport(data DeviceReportData, settings UserSettings) (string, error) {
+	tmpl, err := loadReportTemplate(settings, nil, DEVICES_TEMPLATE_NAME)
+	if err != nil {
+		return "", err
+	}
+
+	return executeReportTemplate(tmpl, data)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDevices(t *testing.T) {
+	original := fitbitHTTPClient
+	defer func() { fitbitHTTPClient = original }()
+	fitbitHTTPClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		assert.Equal(t, "/1/user/-/devices.json", req.URL.Path)
+		assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+		body := `[
+			{"battery": "Low", "batteryLevel": 15, "deviceVersion": "Charge 6", "id": "1", "lastSyncTime": "2024-01-10T08:30:00.000", "type": "TRACKER"},
+			{"battery": "High", "deviceVersion": "Aria Air", "id": "2", "type": "SCALE"}
+		]`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+	})}
+
+	devices, err := getDevices(context.Background(), "token")
+	assert.NoError(t, err)
+	assert.Equal(t, []Device{
+		{ID: "1", Version: "Charge 6", Type: "TRACKER", Battery: "Low", BatteryLevel: 15, LastSyncTime: time.Date(2024, time.January, 10, 8, 30, 0, 0, time.Local)},
+		{ID: "2", Version: "Aria Air", Type: "SCALE", Battery: "High"},
+	}, devices)
+}
+
+func TestBuildDeviceReportData(t *testing.T) {
+	now := time.Date(2024, time.January, 14, 9, 0, 0, 0, time.Local)
+	stale := Device{Version: "Charge 6", Type: "TRACKER", Battery: "High", BatteryLevel: 80, LastSyncTime: now.Add(-72 * time.Hour)}
+	lowBattery := Device{Version: "Inspire 3", Type: "TRACKER", Battery: "Medium", BatteryLevel: 18, LastSyncTime: now.Add(-time.Hour)}
+	empty := Device{Version: "Aria Air", Type: "SCALE", Battery: "Empty", LastSyncTime: now.AddDate(0, -1, 0)}
+	healthy := Device{Version: "Sense 2", Type: "TRACKER", Battery: "High", BatteryLevel: 90, LastSyncTime: now.Add(-23 * time.Hour)}
+
+	data := buildDeviceReportData([]Device{stale, lowBattery, empty, healthy}, now, UserSettings{})
+	assert.Equal(t, []Device{stale}, data.Stale)
+	assert.Equal(t, []Device{lowBattery, empty}, data.LowBattery)
+
+	data = buildDeviceReportData([]Device{stale, lowBattery, healthy}, now, UserSettings{SyncWarningHours: 100, LowBatteryLevel: 10})
+	assert.Empty(t, data.Stale)
+	assert.Empty(t, data.LowBattery)
+}
+
+func TestRenderDeviceReport(t *testing.T) {
+	data := DeviceReportData{
+		Stale:      []Device{{Version: "Charge 6", LastSyncTime: time.Date(2024, time.January, 10, 8, 30, 0, 0, time.Local)}},
+		LowBattery: []Device{{Version: "Charge 6", BatteryLevel: 15}, {Version: "Aria Air", Battery: "Empty"}},
+	}
+
+	actual, err := renderDeviceReport(data, UserSettings{})
+	assert.NoError(t, err)
+	assert.Equal(t, `
+======================
+⚠️ Charge 6 has not synced since 1/10 08:30. The days after it are not counted yet.
+🔋 The battery of Charge 6 is low (15%).
+🔋 The battery of Aria Air is low.
+`, actual)
+}
+
+func TestRenderNotSyncedDays(t *testing.T) {
+	today := time.Date(2024, time.January, 14, 9, 0, 0, 0, time.Local)
+	lifetimeData := map[time.Time]float64{}
+	for _, date := range lastSevenDays(today).Days() {
+		lifetimeData[date] = 8000
+	}
+	// Fitbit returns 0 for the days the tracker has not synced
+	lifetimeData[time.Date(2024, time.January, 12, 0, 0, 0, 0, time.Local)] = 0
+	lifetimeData[time.Date(2024, time.January, 13, 0, 0, 0, 0, time.Local)] = 0
+
+	devices := []Device{
+		{Type: "TRACKER", LastSyncTime: time.Date(2024, time.January, 11, 21, 0, 0, 0, time.Local)},
+		{Type: "SCALE", LastSyncTime: time.Date(2024, time.January, 13, 7, 0, 0, 0, time.Local)},
+	}
+	data := buildTimeSeriesReportData(stepsResource, lifetimeData, today, lastSevenDays(today))
+	data.LastSync = lastTrackerSync(devices)
+
+	assert.False(t, data.NotSynced(time.Date(2024, time.January, 11, 0, 0, 0, 0, time.Local)))
+	assert.True(t, data.NotSynced(time.Date(2024, time.January, 12, 0, 0, 0, 0, time.Local)))
+
+	actual, err := renderTimeSeriesSection(data, UserSettings{}, "weekly")
+	assert.NoError(t, err)
+	assert.Contains(t, actual, "1/11 Thu 8,000 (P64, #1 of 2024)\n1/12 Fri not synced\n1/13 Sat not synced\n")
+}
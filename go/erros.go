@@ -4,4 +4,32 @@ const (
 	UnableToExtractAcitivityName = "unable to extract activityName"
 	UnableToExtractStartTime     = "unable to extract startTime"
 	UnableToExtractDistance      = "unable to extract distance"
+	UnknownTimeSeriesResource    = "unknown time series resource"
+	InvalidLimitDays             = "limit days must be positive"
+	UnsupportedNumberType        = "unsupported number type"
+	UnsupportedLocale            = "unsupported locale"
+	UnknownBlobStore             = "unknown blob store"
+	InvalidBlobKey               = "invalid blob key"
+	InvalidBlobBaseURL           = "BLOB_BASE_URL of the local blob store must have a path, e.g. https://example.com/blobs"
+	UnknownStateStore            = "unknown state store"
+	UnknownHistoryStore          = "unknown history store"
+	MissingHistoryStore          = "HISTORY_STORE must be set"
+	UnsupportedExportFormat      = "unsupported export format"
+	MissingTakeoutPath           = "path of the Fitbit data export must be given"
+	InvalidTakeoutFile           = "invalid file in the Fitbit data export"
+	MissingToken                 = "token is not stored"
+	UnknownTokenStore            = "unknown token store"
+	InvalidQuietHours            = "quiet hours must be formatted as HH:MM-HH:MM"
+	UnknownRecordPeriod          = "unknown record period"
+	InvalidReportWindow          = "report window must be last7Days, isoWeek, sundayWeek or YYYY-MM-DD/YYYY-MM-DD"
+	UnknownActivityType          = "unknown activity type"
+	InvalidActivityStartTime     = "start time of the activity must be formatted as YYYY-MM-DDTHH:MM and not in the future"
+	InvalidActivityDuration      = "duration of the activity must be positive and within 24h"
+	InvalidActivityDistance      = "distance of the activity must be positive"
+	DuplicateActivity            = "the activity has already been logged, use -force to log it anyway"
+	MissingTrackFile             = "paths of the GPX or TCX files must be given"
+	UnsupportedTrackFile         = "unsupported track file, must be .gpx or .tcx"
+	InvalidTrackFile             = "invalid track file"
+	EmptyTrack                   = "track has no points"
+	UnknownTrackSport            = "unknown sport of track"
 )
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	EXPORT_FORMAT_CSV     = "csv"
+	EXPORT_FORMAT_JSONL   = "jsonl"
+	EXPORT_FORMAT_PARQUET = "parquet"
+
+	DEFAULT_EXPORT_DIR  = "export"
+	DEFAULT_EXPORT_USER = "default"
+)
+
+// The rows below are the schema of the exported files. Columns must only be appended so that
+// existing notebooks keep working.
+
+// exportRow is a row of an exported dataset.
+type exportRow interface {
+	csvRecord() []string
+}
+
+type DailyMetricRow struct {
+	UserID   string  `json:"user_id" parquet:"user_id"`
+	Date     string  `json:"date" parquet:"date"`
+	Resource string  `json:"resource" parquet:"resource"`
+	Value    float64 `json:"value" parquet:"value"`
+}
+
+var dailyMetricHeader = []string{"user_id", "date", "resource", "value"}
+
+func (row DailyMetricRow) csvRecord() []string {
+	return []string{row.UserID, row.Date, row.Resource, formatExportFloat(row.Value)}
+}
+
+type ActivityRow struct {
+	UserID          string  `json:"user_id" parquet:"user_id"`
+	LogID           int64   `json:"log_id" parquet:"log_id"`
+	Name            string  `json:"name" parquet:"name"`
+	StartTime       string  `json:"start_time" parquet:"start_time"`
+	DurationSeconds float64 `json:"duration_seconds" parquet:"duration_seconds"`
+	DistanceKm      float64 `json:"distance_km" parquet:"distance_km"`
+	Calories        float64 `json:"calories" parquet:"calories"`
+	Steps           int64   `json:"steps" parquet:"steps"`
+	Source          string  `json:"source" parquet:"source"`
+}
+
+var activityHeader = []string{"user_id", "log_id", "name", "start_time", "duration_seconds", "distance_km", "calories", "steps", "source"}
+
+func (row ActivityRow) csvRecord() []string {
+	return []string{
+		row.UserID,
+		strconv.FormatInt(row.LogID, 10),
+		row.Name,
+		row.StartTime,
+		formatExportFloat(row.DurationSeconds),
+		formatExportFloat(row.DistanceKm),
+		formatExportFloat(row.Calories),
+		strconv.FormatInt(row.Steps, 10),
+		row.Source,
+	}
+}
+
+type SleepRow struct {
+	UserID        string `json:"user_id" parquet:"user_id"`
+	LogID         int64  `json:"log_id" parquet:"log_id"`
+	DateOfSleep   string `json:"date_of_sleep" parquet:"date_of_sleep"`
+	StartTime     string `json:"start_time" parquet:"start_time"`
+	EndTime       string `json:"end_time" parquet:"end_time"`
+	MinutesAsleep int64  `json:"minutes_asleep" parquet:"minutes_asleep"`
+	Efficiency    int64  `json:"efficiency" parquet:"efficiency"`
+}
+
+var sleepHeader = []string{"user_id", "log_id", "date_of_sleep", "start_time", "end_time", "minutes_asleep", "efficiency"}
+
+func (row SleepRow) csvRecord() []string {
+	return []string{
+		row.UserID,
+		strconv.FormatInt(row.LogID, 10),
+		row.DateOfSleep,
+		row.StartTime,
+		row.EndTime,
+		strconv.FormatInt(row.MinutesAsleep, 10),
+		strconv.FormatInt(row.Efficiency, 10),
+	}
+}
+
+type BodyRow struct {
+	UserID   string  `json:"user_id" parquet:"user_id"`
+	LogID    int64   `json:"log_id" parquet:"log_id"`
+	Time     string  `json:"time" parquet:"time"`
+	WeightKg float64 `json:"weight_kg" parquet:"weight_kg"`
+	BMI      float64 `json:"bmi" parquet:"bmi"`
+	Fat      float64 `json:"fat" parquet:"fat"`
+}
+
+var bodyHeader = []string{"user_id", "log_id", "time", "weight_kg", "bmi", "fat"}
+
+func (row BodyRow) csvRecord() []string {
+	return []string{
+		row.UserID,
+		strconv.FormatInt(row.LogID, 10),
+		row.Time,
+		formatExportFloat(row.WeightKg),
+		formatExportFloat(row.BMI),
+		formatExportFloat(row.Fat),
+	}
+}
+
+func formatExportFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// exportData is the history of a user in [From, To].
+type exportData struct {
+	DailyMetrics []DailyMetricRow
+	Activities   []ActivityRow
+	Sleep        []SleepRow
+	Body         []BodyRow
+}
+
+// exportedResources returns the names of the daily series which may be in the history.
+func exportedResources() []string {
+	names := []string{RESTING_HEART_RATE_RESOURCE}
+	for name := range timeSeriesResources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// collectExportData reads the history between the dates from and to, both inclusive.
+func collectExportData(ctx context.Context, history HistoryStore, userID string, from time.Time, to time.Time) (exportData, error) {
+	data := exportData{}
+	end := to.AddDate(0, 0, 1)
+
+	for _, resource := range exportedResources() {
+		values, err := history.GetDailyValues(ctx, resource)
+		if err != nil {
+			return data, err
+		}
+
+		var dates []time.Time
+		for date := range values {
+			if inRange(date, from, end) {
+				dates = append(dates, date)
+			}
+		}
+		sort.Slice(dates, func(i, j int) bool {
+			return dates[i].Before(dates[j])
+		})
+
+		for _, date := range dates {
+			data.DailyMetrics = append(data.DailyMetrics, DailyMetricRow{
+				UserID:   userID,
+				Date:     date.Format(DATE_FORMAT),
+				Resource: resource,
+				Value:    values[date],
+			})
+		}
+	}
+
+	activities, err := history.GetActivities(ctx, from, end)
+	if err != nil {
+		return data, err
+	}
+	for _, record := range activities {
+		data.Activities = append(data.Activities, ActivityRow{
+			UserID:          userID,
+			LogID:           record.LogID,
+			Name:            record.Name,
+			StartTime:       record.StartTime.Format(time.RFC3339),
+			DurationSeconds: record.Duration.Seconds(),
+			DistanceKm:      record.Distance,
+			Calories:        record.Calories,
+			Steps:           int64(record.Steps),
+			Source:          record.Source,
+		})
+	}
+
+	sleep, err := history.GetSleep(ctx, from, end)
+	if err != nil {
+		return data, err
+	}
+	for _, record := range sleep {
+		data.Sleep = append(data.Sleep, SleepRow{
+			UserID:        userID,
+			LogID:         record.LogID,
+			DateOfSleep:   record.DateOfSleep.Format(DATE_FORMAT),
+			StartTime:     record.StartTime.Format(time.RFC3339),
+			EndTime:       record.EndTime.Format(time.RFC3339),
+			MinutesAsleep: int64(record.MinutesAsleep),
+			Efficiency:    int64(record.Efficiency),
+		})
+	}
+
+	body, err := history.GetBody(ctx, from, end)
+	if err != nil {
+		return data, err
+	}
+	for _, record := range body {
+		data.Body = append(data.Body, BodyRow{
+			UserID:   userID,
+			LogID:    record.LogID,
+			Time:     record.Time.Format(time.RFC3339),
+			WeightKg: record.Weight,
+			BMI:      record.BMI,
+			Fat:      record.Fat,
+		})
+	}
+
+	return data, nil
+}
+
+func writeExportRows[T exportRow](w io.Writer, format string, header []string, rows []T) error {
+	switch format {
+	case EXPORT_FORMAT_CSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(header); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := writer.Write(row.csvRecord()); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+
+	case EXPORT_FORMAT_JSONL:
+		encoder := json.NewEncoder(w)
+		for _, row := range rows {
+			if err := encoder.Encode(row); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case EXPORT_FORMAT_PARQUET:
+		writer := parquet.NewGenericWriter[T](w)
+		if _, err := writer.Write(rows); err != nil {
+			return err
+		}
+		return writer.Close()
+
+	default:
+		return errors.New(UnsupportedExportFormat)
+	}
+}
+
+func writeExportFile[T exportRow](dir string, name string, format string, header []string, rows []T) error {
+	file, err := os.Create(filepath.Join(dir, name+"."+format))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := writeExportRows(file, format, header, rows); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// writeExport writes a file per dataset into dir.
+func writeExport(dir string, format string, data exportData) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if err := writeExportFile(dir, "daily_metrics", format, dailyMetricHeader, data.DailyMetrics); err != nil {
+		return err
+	}
+	if err := writeExportFile(dir, "activities", format, activityHeader, data.Activities); err != nil {
+		return err
+	}
+	if err := writeExportFile(dir, "sleep", format, sleepHeader, data.Sleep); err != nil {
+		return err
+	}
+	return writeExportFile(dir, "body", format, bodyHeader, data.Body)
+}
+
+// runExport writes the history in the history store to <out>/<user>/<dataset>.<format>.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", EXPORT_FORMAT_CSV, "csv, jsonl or parquet")
+	fromFlag := flags.String("from", "", "first date to export (default all)")
+	toFlag := flags.String("to", "", "last date to export (default today)")
+	out := flags.String("out", DEFAULT_EXPORT_DIR, "output directory")
+	userID := flags.String("user", DEFAULT_EXPORT_USER, "user the history belongs to, written to the user_id column and the directory name")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	switch *format {
+	case EXPORT_FORMAT_CSV, EXPORT_FORMAT_JSONL, EXPORT_FORMAT_PARQUET:
+	default:
+		return fmt.Errorf("%s: %s", UnsupportedExportFormat, *format)
+	}
+
+	from := time.Time{}
+	to := time.Now().Local()
+	var err error
+	if *fromFlag != "" {
+		if from, err = time.ParseInLocation(DATE_FORMAT, *fromFlag, time.Local); err != nil {
+			return err
+		}
+	}
+	if *toFlag != "" {
+		if to, err = time.ParseInLocation(DATE_FORMAT, *toFlag, time.Local); err != nil {
+			return err
+		}
+	}
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.Local)
+
+	ctx := context.TODO()
+	history, err := newHistoryStore(ctx)
+	if err != nil {
+		return err
+	}
+	if history == nil {
+		return errors.New(MissingHistoryStore)
+	}
+
+	data, err := collectExportData(ctx, history, *userID, from, to)
+	if err != nil {
+		return err
+	}
+
+	return writeExport(filepath.Join(*out, *userID), *format, data)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func exportTestHistory(t *testing.T) HistoryStore {
+	ctx := context.Background()
+	history := &FileHistoryStore{Path: filepath.Join(t.TempDir(), "history.json")}
+	day := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.Local)
+
+	assert.NoError(t, history.PutDailyValues(ctx, "steps", []DailyValue{{day, 1000}, {day.AddDate(0, 0, 1), 2000}, {day.AddDate(0, 0, 2), 3000}}))
+	assert.NoError(t, history.PutDailyValues(ctx, "floors", []DailyValue{{day, 5}}))
+	assert.NoError(t, history.PutActivities(ctx, []ActivityRecord{
+		{LogID: 1, Name: "Run", StartTime: day.Add(7 * time.Hour), Duration: 30 * time.Minute, Distance: 5.2, Source: "tracker"},
+		{LogID: 2, Name: "Walk", StartTime: day.AddDate(0, 0, 2).Add(7 * time.Hour)},
+	}))
+	return history
+}
+
+func TestCollectExportData(t *testing.T) {
+	day := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.Local)
+
+	data, err := collectExportData(context.Background(), exportTestHistory(t), "U1", day, day.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, []DailyMetricRow{
+		{"U1", "2024-03-01", "floors", 5},
+		{"U1", "2024-03-01", "steps", 1000},
+		{"U1", "2024-03-02", "steps", 2000},
+	}, data.DailyMetrics)
+	assert.Len(t, data.Activities, 1)
+	assert.Equal(t, 1800.0, data.Activities[0].DurationSeconds)
+	assert.Empty(t, data.Sleep)
+}
+
+func TestWriteExportRows(t *testing.T) {
+	rows := []ActivityRow{
+		{UserID: "U1", LogID: 1, Name: "Run, easy", StartTime: "2024-03-01T07:00:00+09:00", DurationSeconds: 1800, DistanceKm: 5.2, Source: "tracker"},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, writeExportRows(&buf, EXPORT_FORMAT_CSV, activityHeader, rows))
+	assert.Equal(t, "user_id,log_id,name,start_time,duration_seconds,distance_km,calories,steps,source\n"+
+		"U1,1,\"Run, easy\",2024-03-01T07:00:00+09:00,1800,5.2,0,0,tracker\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, writeExportRows(&buf, EXPORT_FORMAT_JSONL, activityHeader, rows))
+	assert.Equal(t, `{"user_id":"U1","log_id":1,"name":"Run, easy","start_time":"2024-03-01T07:00:00+09:00","duration_seconds":1800,"distance_km":5.2,"calories":0,"steps":0,"source":"tracker"}`+"\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, writeExportRows(&buf, EXPORT_FORMAT_PARQUET, activityHeader, rows))
+	actual, err := parquet.Read[ActivityRow](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+	assert.Equal(t, rows, actual)
+
+	assert.EqualError(t, writeExportRows(&buf, "xml", activityHeader, rows), UnsupportedExportFormat)
+}
+
+func TestWriteExport(t *testing.T) {
+	day := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.Local)
+	data, err := collectExportData(context.Background(), exportTestHistory(t), "U1", day, day.AddDate(0, 0, 2))
+	assert.NoError(t, err)
+
+	dir := filepath.Join(t.TempDir(), "U1")
+	assert.NoError(t, writeExport(dir, EXPORT_FORMAT_CSV, data))
+
+	for _, name := range []string{"daily_metrics", "activities", "sleep", "body"} {
+		_, err := os.Stat(filepath.Join(dir, name+".csv"))
+		assert.NoError(t, err)
+	}
+
+	sleep, err := os.ReadFile(filepath.Join(dir, "sleep.csv"))
+	assert.NoError(t, err)
+	// the header is written even without rows
+	assert.Equal(t, "user_id,log_id,date_of_sleep,start_time,end_time,minutes_asleep,efficiency\n", string(sleep))
+}
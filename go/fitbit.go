@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	FITBIT_API_URL = "https://api.fitbit.com"
+	// format of the local times in Fitbit API responses
+	FITBIT_TIME_FORMAT        = "2006-01-02T15:04:05.000"
+	FITBIT_OFFSET_TIME_FORMAT = "2006-01-02T15:04:05.000-07:00"
+	FITBIT_AFTER_DATE_FORMAT  = "2006-01-02T15:04:05"
+	FITBIT_ACTIVITY_PAGE_SIZE = 100
+
+	FITBIT_RATE_LIMIT_RESET_HEADER = "Fitbit-Rate-Limit-Reset"
+	// used when the reset header is missing
+	DEFAULT_RATE_LIMIT_WAIT = time.Minute
+)
+
+// fitbitHTTPClient is the client of the Fitbit API calls. Long running commands replace its
+// transport with a rateLimitedTransport.
+var fitbitHTTPClient = &http.Client{}
+
+// rateLimitedTransport waits for the hourly rate limit of the Fitbit API to be reset and retries
+// when a request is rejected with 429, instead of failing.
+type rateLimitedTransport struct {
+	Base http.RoundTripper
+	// the response is returned as is when the reset is further than MaxWait
+	MaxWait time.Duration
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+func newRateLimitedTransport(maxWait time.Duration) *rateLimitedTransport {
+	return &rateLimitedTransport{
+		Base:    http.DefaultTransport,
+		MaxWait: maxWait,
+		sleep:   sleepContext,
+	}
+}
+
+func (transport *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for {
+		resp, err := transport.Base.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+			return resp, err
+		}
+
+		wait := DEFAULT_RATE_LIMIT_WAIT
+		if seconds, err := strconv.Atoi(resp.Header.Get(FITBIT_RATE_LIMIT_RESET_HEADER)); err == nil {
+			// a second later so that the limit has surely been reset
+			wait = time.Duration(seconds+1) * time.Second
+		}
+		if wait > transport.MaxWait {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		log.Printf("Fitbit API rate limit reached, pausing for %s", wait)
+		if err := transport.sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// getFitbitJSON calls a Fitbit API and decodes the JSON response into v.
+func getFitbitJSON(ctx context.Context, access_token string, apiUrl string, v interface{}) error {
+	client := fitbitHTTPClient
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiUrl, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create Fitbit API request: %v", err)
+	}
+
+	req.Header.Add("Authorization", "Bearer "+access_token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call Fitbit API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to call Fitbit API: %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode Fitbit API response: %v", err)
+	}
+	return nil
+}
+
+type fitbitActivity struct {
+	LogID        int64   `json:"logId"`
+	ActivityName string  `json:"activityName"`
+	StartTime    string  `json:"startTime"`
+	Duration     int64   `json:"duration"`
+	Distance     float64 `json:"distance"`
+	Calories     float64 `json:"calories"`
+	Steps        int     `json:"steps"`
+	LogType      string  `json:"logType"`
+}
+
+func (activity fitbitActivity) record() (ActivityRecord, error) {
+	startTime, err := time.Parse(FITBIT_OFFSET_TIME_FORMAT, activity.StartTime)
+	if err != nil {
+		return ActivityRecord{}, err
+	}
+
+	return ActivityRecord{
+		LogID:     activity.LogID,
+		Name:      activity.ActivityName,
+		StartTime: startTime,
+		Duration:  time.Duration(activity.Duration) * time.Millisecond,
+		Distance:  activity.Distance,
+		Calories:  activity.Calories,
+		Steps:     activity.Steps,
+		Source:    activity.LogType,
+	}, nil
+}
+
+// activityRecordsFromList converts the activities of getActivityList.
+func activityRecordsFromList(activityList []interface{}) ([]ActivityRecord, error) {
+	encoded, err := json.Marshal(activityList)
+	if err != nil {
+		return nil, err
+	}
+	var activities []fitbitActivity
+	if err := json.Unmarshal(encoded, &activities); err != nil {
+		return nil, err
+	}
+
+	var records []ActivityRecord
+	for _, activity := range activities {
+		record, err := activity.record()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// getActivityRecords gets up to FITBIT_ACTIVITY_PAGE_SIZE activities started after afterDate, oldest first.
+func getActivityRecords(ctx context.Context, access_token string, afterDate time.Time) ([]ActivityRecord, error) {
+	query := url.Values{}
+	query.Set("afterDate", afterDate.Format(FITBIT_AFTER_DATE_FORMAT))
+	query.Set("sort", "asc")
+	query.Set("limit", strconv.Itoa(FITBIT_ACTIVITY_PAGE_SIZE))
+	query.Set("offset", "0")
+
+	var response struct {
+		Activities []fitbitActivity `json:"activities"`
+	}
+	if err := getFitbitJSON(ctx, access_token, FITBIT_API_URL+"/1/user/-/activities/list.json?"+query.Encode(), &response); err != nil {
+		return nil, err
+	}
+
+	var records []ActivityRecord
+	for _, activity := range response.Activities {
+		record, err := activity.record()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// getActivityRecordsByDate gets the activities started on date.
+func getActivityRecordsByDate(ctx context.Context, access_token string, date time.Time) ([]ActivityRecord, error) {
+	records, err := getActivityRecords(ctx, access_token, date.AddDate(0, 0, -1))
+	if err != nil {
+		return nil, err
+	}
+
+	var dateRecords []ActivityRecord
+	for _, record := range records {
+		if record.StartTime.Format(DATE_FORMAT) == date.Format(DATE_FORMAT) {
+			dateRecords = append(dateRecords, record)
+		}
+	}
+	return dateRecords, nil
+}
+
+// getActivityRecordsByDateRange gets the activities started in [start, end), paging through the list.
+func getActivityRecordsByDateRange(ctx context.Context, access_token string, start time.Time, end time.Time) ([]ActivityRecord, error) {
+	// afterDate is exclusive
+	after := start.Add(-time.Second)
+
+	var rangeRecords []ActivityRecord
+	for {
+		records, err := getActivityRecords(ctx, access_token, after)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, record := range records {
+			if !record.StartTime.Before(end) {
+				return rangeRecords, nil
+			}
+			if !record.StartTime.Before(start) {
+				rangeRecords = append(rangeRecords, record)
+			}
+		}
+		if len(records) < FITBIT_ACTIVITY_PAGE_SIZE {
+			return rangeRecords, nil
+		}
+
+		// Fitbit takes the local time of the account
+		last := records[len(records)-1].StartTime
+		next := time.Date(last.Year(), last.Month(), last.Day(), last.Hour(), last.Minute(), last.Second(), 0, time.Local)
+		if !next.After(after) {
+			return rangeRecords, nil
+		}
+		after = next
+	}
+}
+
+type fitbitSleep struct {
+	LogID         int64  `json:"logId"`
+	DateOfSleep   string `json:"dateOfSleep"`
+	StartTime     string `json:"startTime"`
+	EndTime       string `json:"endTime"`
+	MinutesAsleep int    `json:"minutesAsleep"`
+	Efficiency    int    `json:"efficiency"`
+}
+
+func (sleep fitbitSleep) record() (SleepRecord, error) {
+	dateOfSleep, err := time.ParseInLocation(DATE_FORMAT, sleep.DateOfSleep, time.Local)
+	if err != nil {
+		return SleepRecord{}, err
+	}
+	startTime, err := time.ParseInLocation(FITBIT_TIME_FORMAT, sleep.StartTime, time.Local)
+	if err != nil {
+		return SleepRecord{}, err
+	}
+	endTime, err := time.ParseInLocation(FITBIT_TIME_FORMAT, sleep.EndTime, time.Local)
+	if err != nil {
+		return SleepRecord{}, err
+	}
+
+	return SleepRecord{
+		LogID:         sleep.LogID,
+		DateOfSleep:   dateOfSleep,
+		StartTime:     startTime,
+		EndTime:       endTime,
+		MinutesAsleep: sleep.MinutesAsleep,
+		Efficiency:    sleep.Efficiency,
+	}, nil
+}
+
+// getSleepRecordsByDateRange gets the sleep logs of the dates, up to 100 days.
+func getSleepRecordsByDateRange(ctx context.Context, access_token string, startDate time.Time, endDate time.Time) ([]SleepRecord, error) {
+	var response struct {
+		Sleep []fitbitSleep `json:"sleep"`
+	}
+	apiUrl := FITBIT_API_URL + "/1.2/user/-/sleep/date/" + startDate.Format(DATE_FORMAT) + "/" + endDate.Format(DATE_FORMAT) + ".json"
+	if err := getFitbitJSON(ctx, access_token, apiUrl, &response); err != nil {
+		return nil, err
+	}
+
+	var records []SleepRecord
+	for _, sleep := range response.Sleep {
+		record, err := sleep.record()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+type fitbitWeight struct {
+	LogID  int64   `json:"logId"`
+	Date   string  `json:"date"`
+	Time   string  `json:"time"`
+	Weight float64 `json:"weight"`
+	BMI    float64 `json:"bmi"`
+	Fat    float64 `json:"fat"`
+}
+
+func (weight fitbitWeight) record() (BodyRecord, error) {
+	t, err := time.ParseInLocation(DATE_FORMAT+" 15:04:05", weight.Date+" "+weight.Time, time.Local)
+	if err != nil {
+		return BodyRecord{}, err
+	}
+
+	return BodyRecord{
+		LogID:  weight.LogID,
+		Time:   t,
+		Weight: weight.Weight,
+		BMI:    weight.BMI,
+		Fat:    weight.Fat,
+	}, nil
+}
+
+// getBodyRecordsByDateRange gets the weight logs of the dates, up to 31 days.
+func getBodyRecordsByDateRange(ctx context.Context, access_token string, startDate time.Time, endDate time.Time) ([]BodyRecord, error) {
+	var response struct {
+		Weight []fitbitWeight `json:"weight"`
+	}
+	apiUrl := FITBIT_API_URL + "/1/user/-/body/log/weight/date/" + startDate.Format(DATE_FORMAT) + "/" + endDate.Format(DATE_FORMAT) + ".json"
+	if err := getFitbitJSON(ctx, access_token, apiUrl, &response); err != nil {
+		return nil, err
+	}
+
+	var records []BodyRecord
+	for _, weight := range response.Weight {
+		record, err := weight.record()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// getRestingHeartRatesByDateRange gets the resting heart rate of the dates, up to a year.
+// Days without a resting heart rate are omitted.
+func getRestingHeartRatesByDateRange(ctx context.Context, access_token string, startDate time.Time, endDate time.Time) ([]DailyValue, error) {
+	var response struct {
+		ActivitiesHeart []struct {
+			DateTime string `json:"dateTime"`
+			Value    struct {
+				RestingHeartRate float64 `json:"restingHeartRate"`
+			} `json:"value"`
+		} `json:"activities-heart"`
+	}
+	apiUrl := FITBIT_API_URL + "/1/user/-/activities/heart/date/" + startDate.Format(DATE_FORMAT) + "/" + endDate.Format(DATE_FORMAT) + ".json"
+	if err := getFitbitJSON(ctx, access_token, apiUrl, &response); err != nil {
+		return nil, err
+	}
+
+	var values []DailyValue
+	for _, heart := range response.ActivitiesHeart {
+		if heart.Value.RestingHeartRate == 0 {
+			continue
+		}
+		date, err := time.ParseInLocation(DATE_FORMAT, heart.DateTime, time.Local)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, DailyValue{date, heart.Value.RestingHeartRate})
+	}
+	return values, nil
+}
+
+// getMemberSince gets the date the user joined Fitbit.
+func getMemberSince(ctx context.Context, access_token string) (time.Time, error) {
+	var response struct {
+		User struct {
+			MemberSince string `json:"memberSince"`
+		} `json:"user"`
+	}
+	if err := getFitbitJSON(ctx, access_token, FITBIT_API_URL+"/1/user/-/profile.json", &response); err != nil {
+		return time.Time{}, err
+	}
+	return time.ParseInLocation(DATE_FORMAT, response.User.MemberSince, time.Local)
+}
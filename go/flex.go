@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+const (
+	MESSAGE_FORMAT_TEXT = "text"
+	MESSAGE_FORMAT_FLEX = "flex"
+
+	// LINE limits
+	FLEX_BUBBLE_MAX_BYTES   = 30 * 1000
+	FLEX_CAROUSEL_MAX_BYTES = 50 * 1000
+	// in UTF-16 code units, see textLength
+	FLEX_ALT_TEXT_MAX_LENGTH = 1500
+
+	FLEX_HEADER_COLOR   = "#1DB446"
+	FLEX_SUB_TEXT_COLOR = "#888888"
+	FLEX_UP_COLOR       = "#1DB446"
+	FLEX_DOWN_COLOR     = "#E53935"
+)
+
+// Report is a report sent to LINE. Text is always set and is sent as is when Flex is nil.
+// ImageURLs are sent as image messages following the report.
+type Report struct {
+	Text      string
+	Flex      messaging_api.FlexContainerInterface
+	ImageURLs []string
+}
+
+func newTimeSeriesReport(data TimeSeriesReportData, settings UserSettings) (Report, error) {
+	text, err := renderTimeSeriesReport(data, settings)
+	if err != nil {
+		return Report{}, err
+	}
+
+	report := Report{Text: text}
+	if settings.MessageFormat != MESSAGE_FORMAT_FLEX {
+		return report, nil
+	}
+
+	locale, err := lookupLocale(settings.Locale)
+	if err != nil {
+		return Report{}, err
+	}
+
+	report.Flex = fitFlexContainer(buildTimeSeriesFlex(data, locale))
+	return report, nil
+}
+
+func newRunningReport(data RunningReportData, settings UserSettings) (Report, error) {
+	text, err := renderRunningReport(data, settings)
+	if err != nil {
+		return Report{}, err
+	}
+
+	report := Report{Text: text}
+	if settings.MessageFormat != MESSAGE_FORMAT_FLEX {
+		return report, nil
+	}
+
+	locale, err := lookupLocale(settings.Locale)
+	if err != nil {
+		return Report{}, err
+	}
+
+	report.Flex = fitFlexContainer(buildRunningFlex(data, locale))
+	return report, nil
+}
+
+// reportMessage converts a report to a LINE message, using the text as altText of a Flex Message.
+func reportMessage(report Report) messaging_api.MessageInterface {
+	if report.Flex == nil {
+		return messaging_api.TextMessage{
+			Text: report.Text,
+		}
+	}
+
+	altText := report.Text
+	if textLength(altText) > FLEX_ALT_TEXT_MAX_LENGTH {
+		altText = splitByLength(altText, FLEX_ALT_TEXT_MAX_LENGTH)[0]
+	}
+
+	return messaging_api.FlexMessage{
+		AltText:  altText,
+		Contents: report.Flex,
+	}
+}
+
+func reportMessages(report Report) []messaging_api.MessageInterface {
+	var messages []messaging_api.MessageInterface
+	if report.Flex == nil {
+		for _, chunk := range splitReportText(report.Text, LINE_MAX_TEXT_LENGTH) {
+			messages = append(messages, messaging_api.TextMessage{
+				Text: chunk,
+			})
+		}
+	} else {
+		messages = append(messages, reportMessage(report))
+	}
+
+	for _, url := range report.ImageURLs {
+		messages = append(messages, messaging_api.ImageMessage{
+			OriginalContentUrl: url,
+			PreviewImageUrl:    url,
+		})
+	}
+	return messages
+}
+
+// fitFlexContainer returns nil when the container exceeds LINE's size limits so that the text is sent instead.
+func fitFlexContainer(container messaging_api.FlexContainerInterface) messaging_api.FlexContainerInterface {
+	body, err := json.Marshal(container)
+	if err != nil {
+		return nil
+	}
+
+	switch c := container.(type) {
+	case *messaging_api.FlexCarousel:
+		if len(body) > FLEX_CAROUSEL_MAX_BYTES {
+			return nil
+		}
+		for i := range c.Contents {
+			bubble, err := json.Marshal(&c.Contents[i])
+			if err != nil || len(bubble) > FLEX_BUBBLE_MAX_BYTES {
+				return nil
+			}
+		}
+	default:
+		if len(body) > FLEX_BUBBLE_MAX_BYTES {
+			return nil
+		}
+	}
+
+	return container
+}
+
+func buildTimeSeriesFlex(data TimeSeriesReportData, locale Locale) messaging_api.FlexContainerInterface {
+	suffix := data.Resource.headingSuffix(locale)
+	format := func(value float64) string {
+		return data.Resource.formatValue(locale, value)
+	}
+
+	var weeklyRows []messaging_api.FlexComponentInterface
+	for _, dailyValue := range data.Weekly {
+		value := format(dailyValue.Value)
+		if data.NotSynced(dailyValue.Date) {
+			value = locale.message("notSynced")
+		}
+		weeklyRows = append(weeklyRows, flexRow(locale.formatDate(dailyValue.Date)+" "+locale.weekday(dailyValue.Date), value, ""))
+	}
+	weeklyRows = append(weeklyRows,
+		&messaging_api.FlexSeparator{Margin: string(messaging_api.FlexMargin_MD)},
+		flexRow(locale.message("total"), format(data.WeeklyTotal), ""),
+		flexRow(locale.message("average"), format(data.WeeklyAverage), ""),
+		flexDeltaRow(locale.message("vsLastWeek"), data.WeeklyTotal-data.PreviousWeeklyTotal, format),
+	)
+
+	var yearlyRows []messaging_api.FlexComponentInterface
+	for _, record := range data.YearlyTop {
+		yearlyRows = append(yearlyRows, flexRow(locale.formatDate(record.Date), format(record.Value), ""))
+	}
+
+	var lifetimeRows []messaging_api.FlexComponentInterface
+	for _, record := range data.LifetimeTop {
+		lifetimeRows = append(lifetimeRows, flexRow(locale.formatLifetimeDate(record.Date), format(record.Value), ""))
+	}
+
+	return &messaging_api.FlexCarousel{
+		Contents: []messaging_api.FlexBubble{
+			flexBubble(locale.message("weeklyReport")+suffix, weeklyRows),
+			flexBubble(locale.message("yearlyTop")+suffix, yearlyRows),
+			flexBubble(locale.message("lifetimeTop")+suffix, lifetimeRows),
+		},
+	}
+}
+
+func buildRunningFlex(data RunningReportData, locale Locale) messaging_api.FlexContainerInterface {
+	format := func(value float64) string {
+		return locale.formatFloat(value) + "km"
+	}
+
+	var rows []messaging_api.FlexComponentInterface
+	for _, runningLog := range data.Weekly {
+		rows = append(rows, flexRow(locale.formatDate(runningLog.StartTime)+" "+locale.weekday(runningLog.StartTime), format(runningLog.Distance), ""))
+	}
+	if len(rows) > 0 {
+		rows = append(rows, &messaging_api.FlexSeparator{Margin: string(messaging_api.FlexMargin_MD)})
+	}
+	rows = append(rows,
+		flexRow(locale.message("weeklyDistance"), format(data.WeeklyDistance), ""),
+		flexDeltaRow(locale.message("vsLastWeek"), data.WeeklyDistance-data.PreviousWeeklyDistance, format),
+		flexRow(locale.message("yearlyDistance"), format(data.YearlyDistance), ""),
+	)
+
+	bubble := flexBubble(locale.message("runningReport"), rows)
+	return &bubble
+}
+
+func flexBubble(title string, rows []messaging_api.FlexComponentInterface) messaging_api.FlexBubble {
+	if len(rows) == 0 {
+		rows = []messaging_api.FlexComponentInterface{
+			&messaging_api.FlexText{Text: "-", Color: FLEX_SUB_TEXT_COLOR, Size: string(messaging_api.FlexTextFontSize_SM)},
+		}
+	}
+
+	return messaging_api.FlexBubble{
+		Header: &messaging_api.FlexBox{
+			Layout: messaging_api.FlexBoxLAYOUT_VERTICAL,
+			Contents: []messaging_api.FlexComponentInterface{
+				&messaging_api.FlexText{
+					Text:   title,
+					Weight: messaging_api.FlexTextWEIGHT_BOLD,
+					Color:  FLEX_HEADER_COLOR,
+					Size:   string(messaging_api.FlexTextFontSize_MD),
+					Wrap:   true,
+				},
+			},
+		},
+		Body: &messaging_api.FlexBox{
+			Layout:   messaging_api.FlexBoxLAYOUT_VERTICAL,
+			Spacing:  string(messaging_api.FlexBoxSpacing_SM),
+			Contents: rows,
+		},
+	}
+}
+
+// flexRow is a table row with the label on the left and the value on the right.
+func flexRow(label string, value string, color string) *messaging_api.FlexBox {
+	return &messaging_api.FlexBox{
+		Layout: messaging_api.FlexBoxLAYOUT_HORIZONTAL,
+		Contents: []messaging_api.FlexComponentInterface{
+			&messaging_api.FlexText{
+				Text:  label,
+				Size:  string(messaging_api.FlexTextFontSize_SM),
+				Color: FLEX_SUB_TEXT_COLOR,
+				Flex:  0,
+			},
+			&messaging_api.FlexText{
+				Text:  value,
+				Size:  string(messaging_api.FlexTextFontSize_SM),
+				Color: color,
+				Align: messaging_api.FlexTextALIGN_END,
+				Flex:  1,
+			},
+		},
+	}
+}
+
+func flexDeltaRow(label string, delta float64, format func(float64) string) *messaging_api.FlexBox {
+	switch {
+	case roundToDecimal(delta) > 0:
+		return flexRow(label, "+"+format(delta), FLEX_UP_COLOR)
+	case roundToDecimal(delta) < 0:
+		return flexRow(label, format(delta), FLEX_DOWN_COLOR)
+	default:
+		return flexRow(label, "±"+format(0), "")
+	}
+}
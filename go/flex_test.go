@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTimeSeriesReportWithFlex(t *testing.T) {
+	today := time.Date(2024, time.January, 14, 0, 0, 0, 0, time.Local)
+	lifetimeData := map[time.Time]float64{
+		time.Date(2023, time.December, 31, 0, 0, 0, 0, time.Local): 23456,
+		time.Date(2024, time.January, 8, 0, 0, 0, 0, time.Local):   12000,
+	}
+	data := buildTimeSeriesReportData(stepsResource, lifetimeData, today, lastSevenDays(today))
+
+	// text only by default
+	report, err := newTimeSeriesReport(data, UserSettings{})
+	assert.NoError(t, err)
+	assert.Nil(t, report.Flex)
+	assert.IsType(t, messaging_api.TextMessage{}, reportMessage(report))
+
+	report, err = newTimeSeriesReport(data, UserSettings{MessageFormat: MESSAGE_FORMAT_FLEX})
+	assert.NoError(t, err)
+
+	carousel, ok := report.Flex.(*messaging_api.FlexCarousel)
+	assert.True(t, ok)
+	assert.Len(t, carousel.Contents, 3)
+
+	message, ok := reportMessage(report).(messaging_api.FlexMessage)
+	assert.True(t, ok)
+	assert.Equal(t, report.Text, message.AltText)
+
+	body, err := json.Marshal(&message)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), `"type":"carousel"`)
+	assert.Contains(t, string(body), `"text":"Weekly Report"`)
+	// previous week had 23,456 steps on 12/31
+	assert.Contains(t, string(body), `"text":"-11,456","size":"sm","align":"end","color":"`+FLEX_DOWN_COLOR+`"`)
+}
+
+func TestNewRunningReportWithFlex(t *testing.T) {
+	today := time.Date(2024, time.March, 9, 0, 0, 0, 0, time.Local)
+	yearlyRunningLog := map[time.Time]float64{
+		time.Date(2024, time.March, 8, 7, 0, 0, 0, time.Local): 3.08,
+		time.Date(2024, time.March, 1, 7, 0, 0, 0, time.Local): 2,
+	}
+
+	report, err := newRunningReport(buildRunningReportData(yearlyRunningLog, today, lastSevenDays(today)), UserSettings{MessageFormat: MESSAGE_FORMAT_FLEX, Locale: "ja"})
+	assert.NoError(t, err)
+
+	body, err := json.Marshal(report.Flex)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), `"type":"bubble"`)
+	assert.Contains(t, string(body), `"text":"ランニングレポート"`)
+	assert.Contains(t, string(body), `"text":"+1.08km","size":"sm","align":"end","color":"`+FLEX_UP_COLOR+`"`)
+}
+
+func TestFitFlexContainer(t *testing.T) {
+	var rows []messaging_api.FlexComponentInterface
+	for i := 0; i < 500; i++ {
+		rows = append(rows, flexRow("label", "value", ""))
+	}
+	bubble := flexBubble("title", rows)
+	assert.Nil(t, fitFlexContainer(&bubble))
+
+	small := flexBubble("title", rows[:1])
+	assert.NotNil(t, fitFlexContainer(&small))
+
+	// falls back to the text message
+	report := Report{Text: "text", Flex: fitFlexContainer(&bubble)}
+	assert.IsType(t, messaging_api.TextMessage{}, reportMessage(report))
+}
+
+func TestReportMessageAltTextLimit(t *testing.T) {
+	small := flexBubble("title", nil)
+	report := Report{Text: strings.Repeat("歩", FLEX_ALT_TEXT_MAX_LENGTH+10), Flex: &small}
+
+	message := reportMessage(report).(messaging_api.FlexMessage)
+	assert.Equal(t, FLEX_ALT_TEXT_MAX_LENGTH, len([]rune(message.AltText)))
+
+	// an emoji is two characters for LINE
+	report.Text = strings.Repeat("🟩", FLEX_ALT_TEXT_MAX_LENGTH)
+	message = reportMessage(report).(messaging_api.FlexMessage)
+	assert.Equal(t, FLEX_ALT_TEXT_MAX_LENGTH, textLength(message.AltText))
+	assert.Equal(t, FLEX_ALT_TEXT_MAX_LENGTH/2, len([]rune(message.AltText)))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// 14 emoji are about as wide as a LINE text bubble on a phone, and 4 blocks cover a year
+	HEATMAP_WEEKS_PER_BLOCK = 14
+	// the days before January 1 and after today, about as wide as an emoji
+	HEATMAP_BLANK = "　"
+)
+
+// heatmapEmojis are the cells of the levels of heatmapLevel.
+var heatmapEmojis = [5]string{"⬜", "🟥", "🟨", "🟩", "🟦"}
+
+// HeatmapBlock is a block of weeks of the text heatmap. Rows are the weekdays from Sunday,
+// with a cell per week.
+type HeatmapBlock struct {
+	Start time.Time
+	End   time.Time
+	Rows  []string
+}
+
+// buildHeatmap lays out the days of this year until today as blocks of HEATMAP_WEEKS_PER_BLOCK
+// weeks, so that a line fits in a message.
+func buildHeatmap(lifetimeData map[time.Time]float64, today time.Time, goal int) []HeatmapBlock {
+	todayDate := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+	yearStart := time.Date(today.Year(), time.January, 1, 0, 0, 0, 0, today.Location())
+	// weeks start on Sunday
+	weekStart := yearStart.AddDate(0, 0, -int(yearStart.Weekday()))
+
+	var blocks []HeatmapBlock
+	for !weekStart.After(todayDate) {
+		block := HeatmapBlock{Start: weekStart}
+		rows := make([]strings.Builder, 7)
+		for week := 0; week < HEATMAP_WEEKS_PER_BLOCK && !weekStart.After(todayDate); week++ {
+			for day := 0; day < 7; day++ {
+				date := weekStart.AddDate(0, 0, day)
+				if date.Before(yearStart) || date.After(todayDate) {
+					rows[day].WriteString(HEATMAP_BLANK)
+					continue
+				}
+				rows[day].WriteString(heatmapEmojis[heatmapLevel(lifetimeData[date], goal)])
+				block.End = date
+			}
+			weekStart = weekStart.AddDate(0, 0, 7)
+		}
+
+		if block.Start.Before(yearStart) {
+			block.Start = yearStart
+		}
+		for _, row := range rows {
+			block.Rows = append(block.Rows, strings.TrimRight(row.String(), HEATMAP_BLANK))
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
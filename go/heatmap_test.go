@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildHeatmap(t *testing.T) {
+	// January 1 2024 is a Monday
+	today := time.Date(2024, time.January, 17, 9, 0, 0, 0, time.Local)
+	lifetimeData := map[time.Time]float64{
+		time.Date(2023, time.December, 31, 0, 0, 0, 0, time.Local): 20000,
+		time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local):   3000,
+		time.Date(2024, time.January, 2, 0, 0, 0, 0, time.Local):   8000,
+		time.Date(2024, time.January, 3, 0, 0, 0, 0, time.Local):   12000,
+		time.Date(2024, time.January, 4, 0, 0, 0, 0, time.Local):   16000,
+	}
+
+	blocks := buildHeatmap(lifetimeData, today, 10000)
+	assert.Len(t, blocks, 1)
+	assert.Equal(t, time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local), blocks[0].Start)
+	assert.Equal(t, time.Date(2024, time.January, 17, 0, 0, 0, 0, time.Local), blocks[0].End)
+	assert.Equal(t, []string{
+		// last year and the days after today are blank
+		HEATMAP_BLANK + "⬜⬜",
+		"🟥⬜⬜",
+		"🟨⬜⬜",
+		"🟩⬜⬜",
+		"🟦⬜",
+		"⬜⬜",
+		"⬜⬜",
+	}, blocks[0].Rows)
+}
+
+func TestBuildHeatmapFitsMessageWidth(t *testing.T) {
+	today := time.Date(2024, time.December, 31, 9, 0, 0, 0, time.Local)
+
+	blocks := buildHeatmap(map[time.Time]float64{}, today, 10000)
+	assert.Len(t, blocks, 4)
+	for _, block := range blocks {
+		for _, row := range block.Rows {
+			assert.LessOrEqual(t, utf8.RuneCountInString(row), HEATMAP_WEEKS_PER_BLOCK)
+		}
+	}
+	assert.Equal(t, today.Format(DATE_FORMAT), blocks[3].End.Format(DATE_FORMAT))
+}
+
+func TestRenderHeatmapSection(t *testing.T) {
+	today := time.Date(2024, time.January, 3, 9, 0, 0, 0, time.Local)
+	lifetimeData := map[time.Time]float64{
+		time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local): 12000,
+	}
+
+	data := buildTimeSeriesReportData(stepsResource, lifetimeData, today, lastSevenDays(today))
+	actual, err := renderTimeSeriesReport(data, UserSettings{})
+	assert.NoError(t, err)
+	assert.NotContains(t, actual, "Steps This Year")
+
+	data.Heatmap = buildHeatmap(lifetimeData, today, 10000)
+	actual, err = renderTimeSeriesSection(data, UserSettings{}, "heatmap")
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"Steps This Year",
+		"",
+		"1/1 - 1/3",
+		"",
+		"🟩",
+		"⬜",
+		"⬜",
+		"",
+		"",
+		"",
+		"",
+		locales[DEFAULT_LOCALE].message("heatmapLegend"),
+	}, "\n"), actual)
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	HISTORY_STORE_FILE = "file"
+)
+
+// ActivityRecord is a logged activity such as a run or a walk.
+type ActivityRecord struct {
+	LogID     int64         `json:"logId"`
+	Name      string        `json:"name"`
+	StartTime time.Time     `json:"startTime"`
+	Duration  time.Duration `json:"duration"`
+	// km
+	Distance float64 `json:"distance,omitempty"`
+	Calories float64 `json:"calories,omitempty"`
+	Steps    int     `json:"steps,omitempty"`
+	// how the activity was logged, e.g. "auto_detected", "manual" or "tracker"
+	Source string `json:"source,omitempty"`
+}
+
+// SleepRecord is a sleep log. DateOfSleep is the date the sleep ended.
+type SleepRecord struct {
+	LogID         int64     `json:"logId"`
+	DateOfSleep   time.Time `json:"dateOfSleep"`
+	StartTime     time.Time `json:"startTime"`
+	EndTime       time.Time `json:"endTime"`
+	MinutesAsleep int       `json:"minutesAsleep"`
+	Efficiency    int       `json:"efficiency"`
+}
+
+// BodyRecord is a weight log.
+type BodyRecord struct {
+	LogID int64     `json:"logId"`
+	Time  time.Time `json:"time"`
+	// kg
+	Weight float64 `json:"weight"`
+	BMI    float64 `json:"bmi,omitempty"`
+	Fat    float64 `json:"fat,omitempty"`
+}
+
+// HistoryStore persists the data fetched from Fitbit so that reports and alerts do not
+// have to fetch the whole history again. Records are replaced by their date or log ID.
+type HistoryStore interface {
+	PutDailyValues(ctx context.Context, resource string, values []DailyValue) error
+	GetDailyValues(ctx context.Context, resource string) (map[time.Time]float64, error)
+	PutActivities(ctx context.Context, records []ActivityRecord) error
+	// GetActivities returns the activities started in [from, to) ordered by the start time
+	GetActivities(ctx context.Context, from time.Time, to time.Time) ([]ActivityRecord, error)
+	PutSleep(ctx context.Context, records []SleepRecord) error
+	GetSleep(ctx context.Context, from time.Time, to time.Time) ([]SleepRecord, error)
+	PutBody(ctx context.Context, records []BodyRecord) error
+	GetBody(ctx context.Context, from time.Time, to time.Time) ([]BodyRecord, error)
+}
+
+// historyDocument is the JSON layout of FileHistoryStore.
+type historyDocument struct {
+	// date by resource name
+	DailyValues map[string]map[string]float64 `json:"dailyValues"`
+	// records by log ID
+	Activities map[string]ActivityRecord `json:"activities"`
+	Sleep      map[string]SleepRecord    `json:"sleep"`
+	Body       map[string]BodyRecord     `json:"body"`
+}
+
+// FileHistoryStore keeps the history in a single JSON file.
+type FileHistoryStore struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (store *FileHistoryStore) load() (historyDocument, error) {
+	doc := historyDocument{}
+	value, err := os.ReadFile(store.Path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return doc, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(value, &doc); err != nil {
+			return doc, fmt.Errorf("failed to decode history: %v", err)
+		}
+	}
+
+	if doc.DailyValues == nil {
+		doc.DailyValues = map[string]map[string]float64{}
+	}
+	if doc.Activities == nil {
+		doc.Activities = map[string]ActivityRecord{}
+	}
+	if doc.Sleep == nil {
+		doc.Sleep = map[string]SleepRecord{}
+	}
+	if doc.Body == nil {
+		doc.Body = map[string]BodyRecord{}
+	}
+	return doc, nil
+}
+
+func (store *FileHistoryStore) save(doc historyDocument) error {
+	value, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(store.Path), 0755); err != nil {
+		return err
+	}
+
+	// write to a temporary file first so that a crash never leaves a truncated document
+	tempFile := store.Path + ".tmp"
+	if err := os.WriteFile(tempFile, value, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempFile, store.Path)
+}
+
+// update applies f to the document and saves it.
+func (store *FileHistoryStore) update(f func(doc *historyDocument)) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	doc, err := store.load()
+	if err != nil {
+		return err
+	}
+	f(&doc)
+	return store.save(doc)
+}
+
+func (store *FileHistoryStore) read() (historyDocument, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.load()
+}
+
+func (store *FileHistoryStore) PutDailyValues(ctx context.Context, resource string, values []DailyValue) error {
+	return store.update(func(doc *historyDocument) {
+		if doc.DailyValues[resource] == nil {
+			doc.DailyValues[resource] = map[string]float64{}
+		}
+		for _, dailyValue := range values {
+			doc.DailyValues[resource][dailyValue.Date.Format(DATE_FORMAT)] = dailyValue.Value
+		}
+	})
+}
+
+func (store *FileHistoryStore) GetDailyValues(ctx context.Context, resource string) (map[time.Time]float64, error) {
+	doc, err := store.read()
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[time.Time]float64{}
+	for date, value := range doc.DailyValues[resource] {
+		dateTime, err := time.ParseInLocation(DATE_FORMAT, date, time.Local)
+		if err != nil {
+			return nil, err
+		}
+		values[dateTime] = value
+	}
+	return values, nil
+}
+
+func (store *FileHistoryStore) PutActivities(ctx context.Context, records []ActivityRecord) error {
+	return store.update(func(doc *historyDocument) {
+		for _, record := range records {
+			doc.Activities[strconv.FormatInt(record.LogID, 10)] = record
+		}
+	})
+}
+
+func (store *FileHistoryStore) GetActivities(ctx context.Context, from time.Time, to time.Time) ([]ActivityRecord, error) {
+	doc, err := store.read()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []ActivityRecord
+	for _, record := range doc.Activities {
+		if inRange(record.StartTime, from, to) {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].StartTime.Before(records[j].StartTime)
+	})
+	return records, nil
+}
+
+func (store *FileHistoryStore) PutSleep(ctx context.Context, records []SleepRecord) error {
+	return store.update(func(doc *historyDocument) {
+		for _, record := range records {
+			doc.Sleep[strconv.FormatInt(record.LogID, 10)] = record
+		}
+	})
+}
+
+func (store *FileHistoryStore) GetSleep(ctx context.Context, from time.Time, to time.Time) ([]SleepRecord, error) {
+	doc, err := store.read()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []SleepRecord
+	for _, record := range doc.Sleep {
+		if inRange(record.StartTime, from, to) {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].StartTime.Before(records[j].StartTime)
+	})
+	return records, nil
+}
+
+func (store *FileHistoryStore) PutBody(ctx context.Context, records []BodyRecord) error {
+	return store.update(func(doc *historyDocument) {
+		for _, record := range records {
+			doc.Body[strconv.FormatInt(record.LogID, 10)] = record
+		}
+	})
+}
+
+func (store *FileHistoryStore) GetBody(ctx context.Context, from time.Time, to time.Time) ([]BodyRecord, error) {
+	doc, err := store.read()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []BodyRecord
+	for _, record := range doc.Body {
+		if inRange(record.Time, from, to) {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+	return records, nil
+}
+
+func inRange(t time.Time, from time.Time, to time.Time) bool {
+	return !t.Before(from) && t.Before(to)
+}
+
+// newHistoryStore creates the store selected by HISTORY_STORE ("file" or "sqlite").
+// It returns nil when HISTORY_STORE is not set. ctx bounds the opening and the migration of the SQLite database.
+func newHistoryStore(ctx context.Context) (HistoryStore, error) {
+	switch os.Getenv("HISTORY_STORE") {
+	case "":
+		return nil, nil
+	case HISTORY_STORE_FILE:
+		return &FileHistoryStore{
+			Path: os.Getenv("HISTORY_PATH"),
+		}, nil
+	case HISTORY_STORE_SQLITE:
+		return sharedSQLiteHistoryStore(ctx, os.Getenv("HISTORY_PATH"))
+	default:
+		return nil, errors.New(UnknownHistoryStore)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileHistoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := &FileHistoryStore{Path: filepath.Join(t.TempDir(), "history", "history.json")}
+
+	values, err := store.GetDailyValues(ctx, "steps")
+	assert.NoError(t, err)
+	assert.Empty(t, values)
+
+	day := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.Local)
+	assert.NoError(t, store.PutDailyValues(ctx, "steps", []DailyValue{{day, 1000}, {day.AddDate(0, 0, 1), 2000}}))
+	// replaced by date
+	assert.NoError(t, store.PutDailyValues(ctx, "steps", []DailyValue{{day, 1500}}))
+
+	values, err = store.GetDailyValues(ctx, "steps")
+	assert.NoError(t, err)
+	assert.Equal(t, map[time.Time]float64{day: 1500, day.AddDate(0, 0, 1): 2000}, values)
+
+	run := ActivityRecord{LogID: 2, Name: "Run", StartTime: day.Add(7 * time.Hour), Duration: 30 * time.Minute, Distance: 5}
+	walk := ActivityRecord{LogID: 1, Name: "Walk", StartTime: day.Add(18 * time.Hour)}
+	assert.NoError(t, store.PutActivities(ctx, []ActivityRecord{walk, run}))
+	walk.Steps = 3000
+	assert.NoError(t, store.PutActivities(ctx, []ActivityRecord{walk}))
+
+	activities, err := store.GetActivities(ctx, day, day.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.Len(t, activities, 2)
+	assert.Equal(t, "Run", activities[0].Name)
+	assert.True(t, run.StartTime.Equal(activities[0].StartTime))
+	assert.Equal(t, 30*time.Minute, activities[0].Duration)
+	assert.Equal(t, 3000, activities[1].Steps)
+
+	activities, err = store.GetActivities(ctx, day.AddDate(0, 0, 1), day.AddDate(0, 0, 2))
+	assert.NoError(t, err)
+	assert.Empty(t, activities)
+
+	assert.NoError(t, store.PutSleep(ctx, []SleepRecord{{LogID: 1, StartTime: day.Add(-2 * time.Hour), MinutesAsleep: 420}}))
+	sleep, err := store.GetSleep(ctx, day.AddDate(0, 0, -1), day)
+	assert.NoError(t, err)
+	assert.Len(t, sleep, 1)
+	assert.Equal(t, 420, sleep[0].MinutesAsleep)
+
+	assert.NoError(t, store.PutBody(ctx, []BodyRecord{{LogID: 1, Time: day.Add(7 * time.Hour), Weight: 60.5}}))
+	body, err := store.GetBody(ctx, day, day.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.Len(t, body, 1)
+	assert.Equal(t, 60.5, body[0].Weight)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	INSIGHTS_TEMPLATE_NAME = "insights.tmpl"
+
+	INSIGHT_WEEKDAY_WEEKS  = 12
+	INSIGHT_BASELINE_WEEKS = 26
+	// a week is unusual when its z-score is at least this far from 0
+	INSIGHT_ANOMALY_Z_SCORE = 2.0
+	INSIGHT_TREND_DAYS      = 90
+	// the trend is flat when the change over INSIGHT_TREND_DAYS is within this ratio of the average
+	INSIGHT_FLAT_TREND_RATIO = 0.05
+
+	INSIGHT_HIGH = "high"
+	INSIGHT_LOW  = "low"
+	INSIGHT_UP   = "up"
+	INSIGHT_DOWN = "down"
+	INSIGHT_FLAT = "flat"
+)
+
+// WeekdayAverage is the average of a weekday. Date is the last day of the weekday in the period.
+type WeekdayAverage struct {
+	Date    time.Time
+	Average float64
+}
+
+// InsightsData is the data model of insights.tmpl. The periods end with the report window, as
+// the other sections of the weekly report.
+type InsightsData struct {
+	// from Sunday, over the last INSIGHT_WEEKDAY_WEEKS weeks
+	Weekdays    []WeekdayAverage
+	MostActive  WeekdayAverage
+	LeastActive WeekdayAverage
+
+	// of the report window
+	WeeklyTotal float64
+	// of the totals of the INSIGHT_BASELINE_WEEKS periods as long as the window before it
+	BaselineMean   float64
+	BaselineStdDev float64
+	ZScore         float64
+	// INSIGHT_HIGH, INSIGHT_LOW or empty
+	Anomaly string
+
+	// change of the daily steps over INSIGHT_TREND_DAYS by linear regression
+	TrendChange float64
+	// INSIGHT_UP, INSIGHT_DOWN or INSIGHT_FLAT
+	Trend string
+}
+
+func meanAndStdDev(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	mean := sum / float64(len(values))
+
+	variance := 0.0
+	for _, value := range values {
+		variance += (value - mean) * (value - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+// linearRegressionSlope returns the slope of the least squares line through the points.
+func linearRegressionSlope(xs []float64, ys []float64) float64 {
+	if len(xs) < 2 {
+		return 0
+	}
+
+	meanX, _ := meanAndStdDev(xs)
+	meanY, _ := meanAndStdDev(ys)
+	numerator, denominator := 0.0, 0.0
+	for i := range xs {
+		numerator += (xs[i] - meanX) * (ys[i] - meanY)
+		denominator += (xs[i] - meanX) * (xs[i] - meanX)
+	}
+	if denominator == 0 {
+		return 0
+	}
+	return numerator / denominator
+}
+
+// buildInsightsData analyzes the history. Days without data are left out of the averages and
+// the trend, and count as 0 in the weekly totals as in the weekly report. Weeks without data,
+// e.g. the ones before the history starts, are left out of the baseline.
+func buildInsightsData(lifetimeData map[time.Time]float64, window ReportWindow) InsightsData {
+	end := window.End
+	data := InsightsData{}
+
+	// weekday averages
+	sums, counts := [7]float64{}, [7]int{}
+	lastDates := [7]time.Time{}
+	for date := end.AddDate(0, 0, -7*INSIGHT_WEEKDAY_WEEKS); date.Before(end); date = date.AddDate(0, 0, 1) {
+		lastDates[date.Weekday()] = date
+		if value, ok := lifetimeData[date]; ok {
+			sums[date.Weekday()] += value
+			counts[date.Weekday()] += 1
+		}
+	}
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		average := WeekdayAverage{Date: lastDates[weekday]}
+		if counts[weekday] > 0 {
+			average.Average = sums[weekday] / float64(counts[weekday])
+		}
+		data.Weekdays = append(data.Weekdays, average)
+
+		if weekday == time.Sunday || average.Average > data.MostActive.Average {
+			data.MostActive = average
+		}
+		if weekday == time.Sunday || average.Average < data.LeastActive.Average {
+			data.LeastActive = average
+		}
+	}
+
+	// the window against the periods before
+	windowTotal := func(window ReportWindow) (float64, bool) {
+		total, found := 0.0, false
+		for _, date := range window.Days() {
+			if value, ok := lifetimeData[date]; ok {
+				total += value
+				found = true
+			}
+		}
+		return total, found
+	}
+	data.WeeklyTotal, _ = windowTotal(window)
+	var baseline []float64
+	previous := window
+	for week := 0; week < INSIGHT_BASELINE_WEEKS; week++ {
+		previous = previous.Previous()
+		if total, ok := windowTotal(previous); ok {
+			baseline = append(baseline, total)
+		}
+	}
+	data.BaselineMean, data.BaselineStdDev = meanAndStdDev(baseline)
+	if data.BaselineStdDev > 0 {
+		data.ZScore = (data.WeeklyTotal - data.BaselineMean) / data.BaselineStdDev
+		switch {
+		case data.ZScore >= INSIGHT_ANOMALY_Z_SCORE:
+			data.Anomaly = INSIGHT_HIGH
+		case data.ZScore <= -INSIGHT_ANOMALY_Z_SCORE:
+			data.Anomaly = INSIGHT_LOW
+		}
+	}
+
+	// long-term trend
+	var xs, ys []float64
+	for day := 0; day < INSIGHT_TREND_DAYS; day++ {
+		date := end.AddDate(0, 0, day-INSIGHT_TREND_DAYS)
+		if value, ok := lifetimeData[date]; ok {
+			xs = append(xs, float64(day))
+			ys = append(ys, value)
+		}
+	}
+	data.TrendChange = linearRegressionSlope(xs, ys) * float64(INSIGHT_TREND_DAYS-1)
+	mean, _ := meanAndStdDev(ys)
+	switch {
+	case data.TrendChange > mean*INSIGHT_FLAT_TREND_RATIO:
+		data.Trend = INSIGHT_UP
+	case data.TrendChange < -mean*INSIGHT_FLAT_TREND_RATIO:
+		data.Trend = INSIGHT_DOWN
+	default:
+		data.Trend = INSIGHT_FLAT
+	}
+
+	return data
+}
+
+func renderInsightsReport(data InsightsData, settings UserSettings) (string, error) {
+	tmpl, err := loadReportTemplate(settings, nil, INSIGHTS_TEMPLATE_NAME)
+	if err != nil {
+		return "", err
+	}
+
+	return executeReportTemplate(tmpl, data)
+}
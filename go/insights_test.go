@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// weeklyPatternFixture has fewer steps on Sundays and more on Saturdays, with alternating weeks.
+func weeklyPatternFixture(today time.Time, days int) map[time.Time]float64 {
+	lifetimeData := map[time.Time]float64{}
+	date := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.Local)
+	for i := 1; i <= days; i++ {
+		day := date.AddDate(0, 0, -i)
+		value := 8000.0
+		switch day.Weekday() {
+		case time.Sunday:
+			value = 4000
+		case time.Saturday:
+			value = 12000
+		}
+		if (i-1)/7%2 == 1 {
+			value += 500
+		}
+		lifetimeData[day] = value
+	}
+	return lifetimeData
+}
+
+func TestBuildInsightsData(t *testing.T) {
+	today := time.Date(2024, time.May, 15, 9, 0, 0, 0, time.Local)
+
+	data := buildInsightsData(weeklyPatternFixture(today, 400), lastSevenDays(today))
+
+	assert.Len(t, data.Weekdays, 7)
+	assert.Equal(t, time.Sunday, data.Weekdays[0].Date.Weekday())
+	assert.Equal(t, 4250.0, data.Weekdays[0].Average)
+	assert.Equal(t, time.Saturday, data.MostActive.Date.Weekday())
+	assert.Equal(t, time.Sunday, data.LeastActive.Date.Weekday())
+
+	assert.Equal(t, 56000.0, data.WeeklyTotal)
+	assert.Equal(t, 57750.0, data.BaselineMean)
+	assert.Equal(t, 1750.0, data.BaselineStdDev)
+	assert.Equal(t, -1.0, data.ZScore)
+	assert.Equal(t, "", data.Anomaly)
+	assert.Equal(t, INSIGHT_FLAT, data.Trend)
+}
+
+func TestBuildInsightsDataAnomalyAndTrend(t *testing.T) {
+	today := time.Date(2024, time.May, 15, 9, 0, 0, 0, time.Local)
+	date := time.Date(2024, time.May, 15, 0, 0, 0, 0, time.Local)
+
+	lifetimeData := weeklyPatternFixture(today, 400)
+	for i := 1; i <= 7; i++ {
+		lifetimeData[date.AddDate(0, 0, -i)] = 1000
+	}
+	data := buildInsightsData(lifetimeData, lastSevenDays(today))
+	assert.Equal(t, INSIGHT_LOW, data.Anomaly)
+
+	// 50 steps more every day
+	lifetimeData = map[time.Time]float64{}
+	for i := 1; i <= 200; i++ {
+		lifetimeData[date.AddDate(0, 0, -i)] = 10000 - 50*float64(i)
+	}
+	data = buildInsightsData(lifetimeData, lastSevenDays(today))
+	assert.Equal(t, INSIGHT_UP, data.Trend)
+	assert.InDelta(t, 50*89, data.TrendChange, 0.001)
+
+	// the weeks before the history starts are not in the baseline
+	data = buildInsightsData(weeklyPatternFixture(today, 35), lastSevenDays(today))
+	assert.Equal(t, 57750.0, data.BaselineMean)
+	assert.Equal(t, 1750.0, data.BaselineStdDev)
+	assert.Equal(t, "", data.Anomaly)
+
+	// a custom window of two weeks against the two weeks periods before it
+	window := ReportWindow{Start: time.Date(2024, time.April, 17, 0, 0, 0, 0, time.Local), End: time.Date(2024, time.May, 1, 0, 0, 0, 0, time.Local)}
+	data = buildInsightsData(weeklyPatternFixture(today, 400), window)
+	assert.Equal(t, 115500.0, data.WeeklyTotal)
+	assert.Equal(t, 115500.0, data.BaselineMean)
+	assert.Equal(t, 0.0, data.BaselineStdDev)
+	assert.Equal(t, "", data.Anomaly)
+
+	// without data
+	data = buildInsightsData(map[time.Time]float64{}, lastSevenDays(today))
+	assert.Equal(t, "", data.Anomaly)
+	assert.Equal(t, INSIGHT_FLAT, data.Trend)
+}
+
+func TestRenderInsightsReport(t *testing.T) {
+	today := time.Date(2024, time.May, 15, 9, 0, 0, 0, time.Local)
+	data := buildInsightsData(weeklyPatternFixture(today, 400), lastSevenDays(today))
+	data.Anomaly = INSIGHT_HIGH
+	data.ZScore = 2.345
+
+	actual, err := renderInsightsReport(data, UserSettings{})
+	assert.NoError(t, err)
+	assert.Equal(t, "\n"+SEPARATOR+`Insights
+
+Sun 4,250
+Mon 8,250
+Tue 8,250
+Wed 8,250
+Thu 8,250
+Fri 8,250
+Sat 12,250
+
+You walk the most on Sat.
+You walk the least on Sun.
+Last week was unusually active (z-score 2.35 against the last 26 weeks).
+Your daily steps have been steady for 90 days.`, actual)
+
+	actual, err = renderInsightsReport(data, UserSettings{Locale: "ja"})
+	assert.NoError(t, err)
+	assert.Contains(t, actual, "最もよく歩くのは土曜日です。")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+const (
+	NOTIFIER_WEEKLY  = "weekly"
+	NOTIFIER_MONTHLY = "monthly"
+)
+
+// DeliveryKey identifies a delivery: the reports of a notifier for a period sent to a user.
+type DeliveryKey struct {
+	UserID   string
+	Period   string
+	Notifier string
+}
+
+func (key DeliveryKey) String() string {
+	return "deliveries/" + key.Notifier + "/" + key.Period + "/" + key.UserID
+}
+
+// retryKey returns the X-Line-Retry-Key of a batch. It is derived from the key so that
+// a retried run sends the same UUID and LINE can drop the duplicate.
+func (key DeliveryKey) retryKey(batch int) string {
+	hash := sha256.Sum256([]byte(key.String() + "#" + strconv.Itoa(batch)))
+	// set the version (4) and variant bits to make it a valid UUID
+	hash[6] = (hash[6] & 0x0f) | 0x40
+	hash[8] = (hash[8] & 0x3f) | 0x80
+	h := hex.EncodeToString(hash[:16])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// weeklyPeriod returns the ISO week of t, e.g. "2024-W02".
+func weeklyPeriod(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// monthlyPeriod returns the month of t, e.g. "2024-01".
+func monthlyPeriod(t time.Time) string {
+	return t.Format("2006-01")
+}
+
+// DeliveryRecord is the ledger entry of a delivery.
+type DeliveryRecord struct {
+	// Sent has an entry per batch, true once the batch has been delivered.
+	Sent      []bool    `json:"sent"`
+	Completed bool      `json:"completed"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// DeliveryLedger records which deliveries have been made so that each is made once.
+type DeliveryLedger struct {
+	Store StateStore
+}
+
+func (ledger *DeliveryLedger) get(ctx context.Context, key DeliveryKey) (DeliveryRecord, error) {
+	record := DeliveryRecord{}
+	value, err := ledger.Store.Get(ctx, key.String())
+	if err != nil || value == nil {
+		return record, err
+	}
+
+	if err := json.Unmarshal(value, &record); err != nil {
+		return record, fmt.Errorf("failed to decode delivery record: %v", err)
+	}
+	return record, nil
+}
+
+func (ledger *DeliveryLedger) put(ctx context.Context, key DeliveryKey, record DeliveryRecord) error {
+	record.UpdatedAt = time.Now()
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return ledger.Store.Put(ctx, key.String(), value)
+}
+
+func (ledger *DeliveryLedger) delivered(ctx context.Context, key DeliveryKey) (bool, error) {
+	record, err := ledger.get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return record.Completed, nil
+}
+
+// deliver pushes the batches of messages which have not been delivered yet, recording each batch
+// as it is sent. Batches are pushed with their retry key, so a push which reached LINE but was
+// not recorded is not delivered twice either.
+func (ledger *DeliveryLedger) deliver(ctx context.Context, pusher messagePusher, key DeliveryKey, messages []messaging_api.MessageInterface) error {
+	record, err := ledger.get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if record.Completed {
+		return nil
+	}
+
+	batches := batchMessages(messages)
+	if len(record.Sent) != len(batches) {
+		// the reports which come and go, e.g. the device warnings, are at the end, so the batches
+		// before them are the same as on the failed run
+		sent := make([]bool, len(batches))
+		copy(sent, record.Sent)
+		record.Sent = sent
+	}
+
+	partial := &PartialDeliveryError{
+		TotalBatches:  len(batches),
+		TotalMessages: len(messages),
+	}
+	for i, batch := range batches {
+		if !record.Sent[i] {
+			if err := pushBatch(pusher, key.UserID, batch, key.retryKey(i)); err != nil {
+				partial.Err = err
+				if err := ledger.put(ctx, key, record); err != nil {
+					return err
+				}
+				return partial
+			}
+			record.Sent[i] = true
+			if err := ledger.put(ctx, key, record); err != nil {
+				return err
+			}
+		}
+		partial.SentBatches = append(partial.SentBatches, i)
+		partial.SentMessages += len(batch)
+	}
+
+	record.Completed = true
+	return ledger.put(ctx, key, record)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliveryLedgerDeliver(t *testing.T) {
+	ctx := context.Background()
+	ledger := &DeliveryLedger{Store: &FileStateStore{Dir: t.TempDir()}}
+	key := DeliveryKey{UserID: "U1", Period: "2024-W02", Notifier: NOTIFIER_WEEKLY}
+
+	// the second batch fails
+	pusher := &mockPusher{failAt: 1}
+	err := ledger.deliver(ctx, pusher, key, textMessages(12))
+	var partial *PartialDeliveryError
+	assert.ErrorAs(t, err, &partial)
+	assert.Equal(t, []int{0}, partial.SentBatches)
+	assert.Len(t, pusher.requests, 1)
+
+	delivered, err := ledger.delivered(ctx, key)
+	assert.NoError(t, err)
+	assert.False(t, delivered)
+
+	// the retry only sends the missing batches
+	pusher = &mockPusher{failAt: -1}
+	err = ledger.deliver(ctx, pusher, key, textMessages(12))
+	assert.NoError(t, err)
+	assert.Len(t, pusher.requests, 2)
+	assert.Equal(t, []string{key.retryKey(1), key.retryKey(2)}, pusher.retryKeys)
+
+	delivered, err = ledger.delivered(ctx, key)
+	assert.NoError(t, err)
+	assert.True(t, delivered)
+
+	// nothing is sent once delivered
+	pusher = &mockPusher{failAt: -1}
+	err = ledger.deliver(ctx, pusher, key, textMessages(12))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, pusher.calls)
+
+	// another user of the same period is a separate delivery
+	otherKey := DeliveryKey{UserID: "U2", Period: "2024-W02", Notifier: NOTIFIER_WEEKLY}
+	err = ledger.deliver(ctx, pusher, otherKey, textMessages(1))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pusher.calls)
+}
+
+func TestDeliveryLedgerDeliverChangedTail(t *testing.T) {
+	ctx := context.Background()
+	ledger := &DeliveryLedger{Store: &FileStateStore{Dir: t.TempDir()}}
+	key := DeliveryKey{UserID: "U1", Period: "2024-W02", Notifier: NOTIFIER_WEEKLY}
+
+	// a warning at the end makes a third batch, and the second one fails
+	pusher := &mockPusher{failAt: 1}
+	err := ledger.deliver(ctx, pusher, key, textMessages(11))
+	var partial *PartialDeliveryError
+	assert.ErrorAs(t, err, &partial)
+
+	// the device synced before the retry, so the warning is gone
+	pusher = &mockPusher{failAt: -1}
+	err = ledger.deliver(ctx, pusher, key, textMessages(10))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{key.retryKey(1)}, pusher.retryKeys)
+}
+
+func TestDeliveryLedgerConflict(t *testing.T) {
+	ctx := context.Background()
+	ledger := &DeliveryLedger{Store: &FileStateStore{Dir: t.TempDir()}}
+	key := DeliveryKey{UserID: "U1", Period: "2024-W02", Notifier: NOTIFIER_WEEKLY}
+
+	// LINE has accepted the first batch, but the ledger was not updated
+	pusher := &mockPusher{failAt: -1, conflicts: map[string]bool{key.retryKey(0): true}}
+	err := ledger.deliver(ctx, pusher, key, textMessages(6))
+	assert.NoError(t, err)
+	assert.Len(t, pusher.requests, 1)
+}
+
+func TestDeliveryKeyRetryKey(t *testing.T) {
+	key := DeliveryKey{UserID: "U1", Period: "2024-W02", Notifier: NOTIFIER_WEEKLY}
+
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, key.retryKey(0))
+	assert.Equal(t, key.retryKey(0), key.retryKey(0))
+	assert.NotEqual(t, key.retryKey(0), key.retryKey(1))
+	assert.NotEqual(t, key.retryKey(0), DeliveryKey{UserID: "U1", Period: "2024-W03", Notifier: NOTIFIER_WEEKLY}.retryKey(0))
+}
+
+func TestWeeklyPeriod(t *testing.T) {
+	assert.Equal(t, "2024-W02", weeklyPeriod(time.Date(2024, time.January, 14, 0, 0, 0, 0, time.Local)))
+	assert.Equal(t, "2025-W01", weeklyPeriod(time.Date(2024, time.December, 30, 0, 0, 0, 0, time.Local)))
+}
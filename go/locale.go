@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DEFAULT_LOCALE = "en"
+
+// Locale holds the message catalog and the date and number formats of a language.
+type Locale struct {
+	Name               string
+	YearlyDateFormat   string
+	LifetimeDateFormat string
+	MonthFormat        string
+	Weekdays           [7]string
+	GroupSeparator     string
+	DecimalSeparator   string
+	Messages           map[string]string
+}
+
+var locales = map[string]Locale{
+	"en": {
+		Name:               "en",
+		YearlyDateFormat:   YEARLY_REPORT_DATE_FORMAT,
+		LifetimeDateFormat: LIFETIME_REPORT_DATE_FORMAT,
+		MonthFormat:        "Jan 2006",
+		Weekdays:           [7]string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"},
+		GroupSeparator:     ",",
+		DecimalSeparator:   ".",
+		Messages: map[string]string{
+			"weeklyReport":                  "Weekly Report",
+			"yearlyTop":                     "Top Records in This Year",
+			"lifetimeTop":                   "Top Records in Lifetime",
+			"total":                         "Total",
+			"average":                       "Average",
+			"percentile":                    "P%d",
+			"yearlyRank":                    "#%d of %d",
+			"weeklyRank":                    "#%d of %d weeks",
+			"notSynced":                     "not synced",
+			"dataQuality":                   "Data Quality",
+			"quality.missingDate":           "Missing days",
+			"quality.zeroMidStreak":         "Days without steps",
+			"quality.implausibleValue":      "Implausible days",
+			"quality.duplicateActivity":     "Duplicate activities",
+			"quality.zeroDistanceRun":       "Runs without distance",
+			"latestFlag":                    "latest %s",
+			"qualityExcluded":               "Implausible days and flagged runs are excluded from the reports.",
+			"syncWarning":                   "⚠️ %s has not synced since %s %s. The days after it are not counted yet.",
+			"batteryWarning":                "🔋 The battery of %s is low (%d%%).",
+			"batteryWarningNoLevel":         "🔋 The battery of %s is low.",
+			"topPeriodsThisYear":            "Top %s in This Year",
+			"topPeriodsLifetime":            "Top %s in Lifetime",
+			"topRunningThisYear":            "Top Running %s in This Year",
+			"topRunningLifetime":            "Top Running %s in Lifetime",
+			"period.rollingWeek":            "7 Days",
+			"period.week":                   "Weeks",
+			"period.month":                  "Months",
+			"period.year":                   "Years",
+			"runningReport":                 "Running Report",
+			"weeklyDistance":                "Weekly Distance",
+			"yearlyDistance":                "Yearly Distance",
+			"vsLastWeek":                    "vs Last Week",
+			"vsLastMonth":                   "vs Last Month",
+			"chartSteps":                    "Steps (%s - %s)",
+			"chartWeeklyTrend":              "Weekly steps (last %d weeks)",
+			"chartMonthlyRunning":           "Running distance per month (km)",
+			"monthlyReport":                 "Monthly Report",
+			"bestDay":                       "Best Day",
+			"monthlyDistance":               "Monthly Distance",
+			"runs":                          "Runs",
+			"dashboard":                     "Dashboard",
+			"dashboardLink":                 "Open your dashboard:",
+			"dailySteps":                    "Daily Steps",
+			"goal":                          "Goal",
+			"weeklyTrend":                   "Weekly Steps",
+			"monthlyTrend":                  "Monthly Steps",
+			"runningLog":                    "Running Log",
+			"heatmap":                       "Steps This Year",
+			"heatmapLegend":                 "⬜0 🟥<50% 🟨<100% 🟩≥100% 🟦≥150% of goal",
+			"insights":                      "Insights",
+			"mostActiveWeekday":             "You walk the most on %s.",
+			"leastActiveWeekday":            "You walk the least on %s.",
+			"highWeek":                      "Last week was unusually active (z-score %s against the last 26 weeks).",
+			"lowWeek":                       "Last week was unusually quiet (z-score %s against the last 26 weeks).",
+			"trendUp":                       "Your daily steps are trending up: +%s in 90 days.",
+			"trendDown":                     "Your daily steps are trending down: %s in 90 days.",
+			"trendFlat":                     "Your daily steps have been steady for 90 days.",
+			"todayReport":                   "Today's Steps",
+			"remaining":                     "Remaining",
+			"goalAchieved":                  "Goal achieved!",
+			"nudge":                         "You are below your steps goal today. Let's take a walk!",
+			"newRecord":                     "New record!",
+			"commandFailed":                 "Failed to create the report. Please try again later.",
+			"help":                          "Commands:\ntoday - steps so far today\nweek - weekly report\nyear - yearly records and running distance\nrun - running report\nrecords - lifetime records",
+			"resource.steps":                "Steps",
+			"resource.distance":             "Distance",
+			"resource.floors":               "Floors",
+			"resource.elevation":            "Elevation",
+			"resource.calories":             "Calories",
+			"resource.activityCalories":     "Activity Calories",
+			"resource.minutesSedentary":     "Sedentary Minutes",
+			"resource.minutesLightlyActive": "Lightly Active Minutes",
+			"resource.minutesFairlyActive":  "Fairly Active Minutes",
+			"resource.minutesVeryActive":    "Very Active Minutes",
+		},
+	},
+	"ja": {
+		Name:               "ja",
+		YearlyDateFormat:   "1月2日",
+		LifetimeDateFormat: "2006年1月2日",
+		MonthFormat:        "2006年1月",
+		Weekdays:           [7]string{"日", "月", "火", "水", "木", "金", "土"},
+		GroupSeparator:     ",",
+		DecimalSeparator:   ".",
+		Messages: map[string]string{
+			"weeklyReport":                  "週間レポート",
+			"yearlyTop":                     "今年のトップ記録",
+			"lifetimeTop":                   "歴代トップ記録",
+			"total":                         "合計",
+			"average":                       "平均",
+			"percentile":                    "P%d",
+			"yearlyRank":                    "%[2]d年%[1]d位",
+			"weeklyRank":                    "%[2]d週中%[1]d位",
+			"notSynced":                     "未同期",
+			"dataQuality":                   "データ品質",
+			"quality.missingDate":           "欠損日",
+			"quality.zeroMidStreak":         "歩数ゼロの日",
+			"quality.implausibleValue":      "異常値の日",
+			"quality.duplicateActivity":     "重複したアクティビティ",
+			"quality.zeroDistanceRun":       "距離ゼロのラン",
+			"latestFlag":                    "最新 %s",
+			"qualityExcluded":               "異常値の日と該当するランはレポートから除外されています。",
+			"syncWarning":                   "⚠️ %sは%s %sから同期されていません。それ以降の日はまだ集計されていません。",
+			"batteryWarning":                "🔋 %sのバッテリー残量が少なくなっています (%d%%)。",
+			"batteryWarningNoLevel":         "🔋 %sのバッテリー残量が少なくなっています。",
+			"topPeriodsThisYear":            "今年のトップ記録 (%s)",
+			"topPeriodsLifetime":            "歴代トップ記録 (%s)",
+			"topRunningThisYear":            "今年のランニング距離トップ (%s)",
+			"topRunningLifetime":            "歴代ランニング距離トップ (%s)",
+			"period.rollingWeek":            "7日間",
+			"period.week":                   "週",
+			"period.month":                  "月",
+			"period.year":                   "年",
+			"runningReport":                 "ランニングレポート",
+			"weeklyDistance":                "週間距離",
+			"yearlyDistance":                "年間距離",
+			"vsLastWeek":                    "先週比",
+			"vsLastMonth":                   "先月比",
+			"chartSteps":                    "歩数 (%s - %s)",
+			"chartWeeklyTrend":              "週間歩数 (直近%d週)",
+			"chartMonthlyRunning":           "月間ランニング距離 (km)",
+			"monthlyReport":                 "月間レポート",
+			"bestDay":                       "最高記録",
+			"monthlyDistance":               "月間距離",
+			"runs":                          "ラン回数",
+			"dashboard":                     "ダッシュボード",
+			"dashboardLink":                 "ダッシュボードを開く:",
+			"dailySteps":                    "毎日の歩数",
+			"goal":                          "目標",
+			"weeklyTrend":                   "週ごとの歩数",
+			"monthlyTrend":                  "月ごとの歩数",
+			"runningLog":                    "ランニング記録",
+			"heatmap":                       "今年の歩数",
+			"heatmapLegend":                 "目標に対して ⬜0 🟥<50% 🟨<100% 🟩≥100% 🟦≥150%",
+			"insights":                      "インサイト",
+			"mostActiveWeekday":             "最もよく歩くのは%s曜日です。",
+			"leastActiveWeekday":            "最も歩かないのは%s曜日です。",
+			"highWeek":                      "先週はいつもより活発でした（過去26週に対するzスコア %s）。",
+			"lowWeek":                       "先週はいつもより少なめでした（過去26週に対するzスコア %s）。",
+			"trendUp":                       "1日の歩数は増加傾向です（90日間で+%s歩）。",
+			"trendDown":                     "1日の歩数は減少傾向です（90日間で%s歩）。",
+			"trendFlat":                     "1日の歩数は90日間横ばいです。",
+			"todayReport":                   "今日の歩数",
+			"remaining":                     "残り",
+			"goalAchieved":                  "目標達成！",
+			"nudge":                         "今日はまだ目標歩数に届いていません。少し歩きましょう！",
+			"newRecord":                     "新記録！",
+			"commandFailed":                 "レポートの作成に失敗しました。しばらくしてから再度お試しください。",
+			"help":                          "コマンド:\n今日 - 今日の歩数\n今週 - 週間レポート\n今年 - 今年の記録とランニング距離\nラン - ランニングレポート\n記録 - 歴代記録",
+			"resource.steps":                "歩数",
+			"resource.distance":             "距離",
+			"resource.floors":               "階数",
+			"resource.elevation":            "標高",
+			"resource.calories":             "消費カロリー",
+			"resource.activityCalories":     "活動カロリー",
+			"resource.minutesSedentary":     "座位時間",
+			"resource.minutesLightlyActive": "軽い活動時間",
+			"resource.minutesFairlyActive":  "適度な活動時間",
+			"resource.minutesVeryActive":    "活発な活動時間",
+		},
+	},
+}
+
+func lookupLocale(name string) (Locale, error) {
+	if name == "" {
+		name = DEFAULT_LOCALE
+	}
+
+	locale, ok := locales[name]
+	if !ok {
+		return Locale{}, fmt.Errorf("%s: %s", UnsupportedLocale, name)
+	}
+	return locale, nil
+}
+
+// message returns the localized text for key, falling back to English and then to the key itself.
+func (locale Locale) message(key string) string {
+	if message, ok := locale.Messages[key]; ok {
+		return message
+	}
+	if message, ok := locales[DEFAULT_LOCALE].Messages[key]; ok {
+		return message
+	}
+	return key
+}
+
+func (locale Locale) weekday(t time.Time) string {
+	return locale.Weekdays[t.Weekday()]
+}
+
+func (locale Locale) formatDate(t time.Time) string {
+	return t.Format(locale.YearlyDateFormat)
+}
+
+func (locale Locale) formatMonth(t time.Time) string {
+	return t.Format(locale.MonthFormat)
+}
+
+func (locale Locale) formatLifetimeDate(t time.Time) string {
+	return t.Format(locale.LifetimeDateFormat)
+}
+
+func (locale Locale) formatInt(number int) string {
+	return formatNumberWithSeparator(number, locale.GroupSeparator)
+}
+
+// formatFloat rounds num to DECIMAL_PLACES and groups its integer part.
+func (locale Locale) formatFloat(num float64) string {
+	str := strconv.FormatFloat(math.Abs(roundToDecimal(num)), 'f', -1, 64)
+	integer, fraction, hasFraction := strings.Cut(str, ".")
+
+	n, _ := strconv.Atoi(integer)
+	result := formatNumberWithSeparator(n, locale.GroupSeparator)
+	if hasFraction {
+		result += locale.DecimalSeparator + fraction
+	}
+	if roundToDecimal(num) < 0 {
+		result = "-" + result
+	}
+	return result
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupLocale(t *testing.T) {
+	locale, err := lookupLocale("")
+	assert.NoError(t, err)
+	assert.Equal(t, "en", locale.Name)
+
+	_, err = lookupLocale("xx")
+	assert.ErrorContains(t, err, UnsupportedLocale)
+}
+
+func TestLocaleFormat(t *testing.T) {
+	ja := locales["ja"]
+	date := time.Date(2024, time.January, 8, 0, 0, 0, 0, time.Local)
+
+	assert.Equal(t, "月", ja.weekday(date))
+	assert.Equal(t, "1月8日", ja.formatDate(date))
+	assert.Equal(t, "2024年1月8日", ja.formatLifetimeDate(date))
+	assert.Equal(t, "Mon", locales["en"].weekday(date))
+
+	tests := []struct {
+		input    float64
+		expected string
+	}{
+		{0, "0"},
+		{3.14159, "3.14"},
+		{1234567.891, "1,234,567.89"},
+		{-1234.5, "-1,234.5"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, ja.formatFloat(test.input))
+	}
+
+	comma := Locale{GroupSeparator: ".", DecimalSeparator: ","}
+	assert.Equal(t, "1.234,5", comma.formatFloat(1234.5))
+	assert.Equal(t, "12.345", comma.formatInt(12345))
+}
+
+func TestLocaleMessageFallback(t *testing.T) {
+	partial := Locale{Messages: map[string]string{"total": "合計"}}
+	assert.Equal(t, "合計", partial.message("total"))
+	assert.Equal(t, "Average", partial.message("average"))
+	assert.Equal(t, "unknownKey", partial.message("unknownKey"))
+}
+
+func TestRenderReportsInJapanese(t *testing.T) {
+	today := time.Date(2024, time.January, 14, 0, 0, 0, 0, time.Local)
+	lifetimeData := map[time.Time]float64{
+		time.Date(2023, time.December, 31, 0, 0, 0, 0, time.Local): 23456,
+		time.Date(2024, time.January, 8, 0, 0, 0, 0, time.Local):   12000,
+	}
+
+	expected := `
+======================
+週間レポート (階数)
+
+1月7日 日 0
+1月8日 月 12,000 (P25, 2024年1位)
+1月9日 火 0
+1月10日 水 0
+1月11日 木 0
+1月12日 金 0
+1月13日 土 0
+
+合計: 12,000 (2週中2位)
+平均: 1,714
+======================
+今年のトップ記録 (階数)
+
+12,000(1月8日)
+======================
+歴代トップ記録 (階数)
+
+23,456(2023年12月31日)
+12,000(2024年1月8日)
+`
+
+	actual, err := generateTimeSeriesReport(timeSeriesResources["floors"], lifetimeData, today, UserSettings{Locale: "ja"})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	expected = `
+======================
+ランニングレポート
+1月13日 土 5.5km
+
+週間距離: 5.5km
+年間距離: 5.5km`
+
+	yearlyRunningLog := map[time.Time]float64{
+		time.Date(2024, time.January, 13, 7, 0, 0, 0, time.Local): 5.5,
+	}
+	actual, err = generateRunningReport(yearlyRunningLog, today, UserSettings{Locale: "ja"})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	_, err = generateRunningReport(yearlyRunningLog, today, UserSettings{Locale: "xx"})
+	assert.ErrorContains(t, err, UnsupportedLocale)
+}
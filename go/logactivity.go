@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// format of -start, in the local time
+	LOG_ACTIVITY_TIME_FORMAT = "2006-01-02T15:04"
+	FITBIT_LOG_TIME_FORMAT   = "15:04"
+	MAX_ACTIVITY_DURATION    = 24 * time.Hour
+	// distances of the reports are in km
+	FITBIT_DISTANCE_UNIT = "Kilometer"
+)
+
+// ActivityType is an activity of the Fitbit activity database which can be logged.
+type ActivityType struct {
+	ID int
+	// as in the activity list, e.g. "Run"
+	Name string
+	// the distance is required
+	Distance bool
+}
+
+// activityTypes are the types of log-activity by the name given to -type.
+var activityTypes = map[string]ActivityType{
+	"run":  {ID: 90009, Name: "Run", Distance: true},
+	"walk": {ID: 90013, Name: "Walk", Distance: true},
+	"hike": {ID: 90012, Name: "Hike", Distance: true},
+	"bike": {ID: 90001, Name: "Bike", Distance: true},
+	"swim": {ID: 90024, Name: "Swim"},
+}
+
+func lookupActivityType(name string) (ActivityType, error) {
+	activityType, ok := activityTypes[strings.ToLower(name)]
+	if !ok {
+		var names []string
+		for name := range activityTypes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return ActivityType{}, fmt.Errorf("%s: %s (%s)", UnknownActivityType, name, strings.Join(names, ", "))
+	}
+	return activityType, nil
+}
+
+// ManualActivity is an activity to be logged, e.g. a run recorded on a watch which does not sync
+// to Fitbit.
+type ManualActivity struct {
+	Type      ActivityType
+	StartTime time.Time
+	Duration  time.Duration
+	// km
+	Distance float64
+}
+
+func (activity ManualActivity) validate(now time.Time) error {
+	if activity.StartTime.IsZero() || activity.StartTime.After(now) {
+		return errors.New(InvalidActivityStartTime)
+	}
+	if activity.Duration <= 0 || activity.Duration > MAX_ACTIVITY_DURATION {
+		return errors.New(InvalidActivityDuration)
+	}
+	if activity.Distance < 0 || (activity.Type.Distance && activity.Distance == 0) {
+		return errors.New(InvalidActivityDistance)
+	}
+	return nil
+}
+
+// form is the request body of the Fitbit API which creates the activity log.
+func (activity ManualActivity) form() url.Values {
+	form := url.Values{}
+	form.Set("activityId", strconv.Itoa(activity.Type.ID))
+	form.Set("date", activity.StartTime.Format(DATE_FORMAT))
+	form.Set("startTime", activity.StartTime.Format(FITBIT_LOG_TIME_FORMAT))
+	form.Set("durationMillis", strconv.FormatInt(activity.Duration.Milliseconds(), 10))
+	if activity.Distance > 0 {
+		form.Set("distance", strconv.FormatFloat(activity.Distance, 'f', -1, 64))
+		form.Set("distanceUnit", FITBIT_DISTANCE_UNIT)
+	}
+	return form
+}
+
+// findDuplicateActivities returns the logged activities of the same type which started within
+// DUPLICATE_ACTIVITY_TOLERANCE of the activity.
+func findDuplicateActivities(activity ManualActivity, records []ActivityRecord) []ActivityRecord {
+	var duplicates []ActivityRecord
+	for _, record := range records {
+		diff := record.StartTime.Sub(activity.StartTime)
+		if record.Name == activity.Type.Name && diff <= DUPLICATE_ACTIVITY_TOLERANCE && diff >= -DUPLICATE_ACTIVITY_TOLERANCE {
+			duplicates = append(duplicates, record)
+		}
+	}
+	return duplicates
+}
+
+// createActivityLog logs the activity and returns the ID of the log.
+func createActivityLog(ctx context.Context, access_token string, activity ManualActivity) (int64, error) {
+	apiUrl := FITBIT_API_URL + "/1/user/-/activities.json"
+	client := fitbitHTTPClient
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiUrl, strings.NewReader(activity.form().Encode()))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create Fitbit API request: %v", err)
+	}
+
+	req.Header.Add("Authorization", "Bearer "+access_token)
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to call Fitbit API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to log the activity: %s", resp.Status)
+	}
+
+	var response struct {
+		ActivityLog struct {
+			LogID int64 `json:"logId"`
+		} `json:"activityLog"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, fmt.Errorf("failed to decode Fitbit API response: %v", err)
+	}
+	return response.ActivityLog.LogID, nil
+}
+
+// logActivity logs the activity unless a duplicate has been logged, or only previews it and the
+// duplicates on a dry run.
+func logActivity(ctx context.Context, access_token string, activity ManualActivity, dryRun bool, force bool) error {
+	// the list is read backwards from the day after the activity to the beginning of its year
+	activityList, err := getActivityList(ctx, access_token, activity.StartTime.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	records, err := activityRecordsFromList(activityList)
+	if err != nil {
+		return err
+	}
+
+	duplicates := findDuplicateActivities(activity, records)
+	for _, duplicate := range duplicates {
+		log.Printf("already logged: %s at %s, %.2fkm (log %d, %s)", duplicate.Name,
+			duplicate.StartTime.Format(LOG_ACTIVITY_TIME_FORMAT), duplicate.Distance, duplicate.LogID, duplicate.Source)
+	}
+
+	log.Printf("logging %s at %s for %s, %.2fkm: %s", activity.Type.Name,
+		activity.StartTime.Format(LOG_ACTIVITY_TIME_FORMAT), activity.Duration, activity.Distance, activity.form().Encode())
+	// a dry run only shows the duplicates
+	if dryRun {
+		return nil
+	}
+	if len(duplicates) > 0 && !force {
+		return errors.New(DuplicateActivity)
+	}
+
+	logID, err := createActivityLog(ctx, access_token, activity)
+	if err != nil {
+		return err
+	}
+	log.Printf("logged the activity as %d", logID)
+	return nil
+}
+
+// runLogActivity logs an activity recorded on a device which does not sync to Fitbit, e.g.
+// `log-activity -type run -start 2024-01-06T07:00 -duration 32m10s -distance 5.2`.
+func runLogActivity(args []string) error {
+	flags := flag.NewFlagSet("log-activity", flag.ContinueOnError)
+	typeFlag := flags.String("type", "run", "run, walk, hike, bike or swim")
+	startFlag := flags.String("start", "", "start time in the local time, e.g. 2024-01-06T07:00")
+	duration := flags.Duration("duration", 0, "duration, e.g. 32m10s")
+	distance := flags.Float64("distance", 0, "distance in km")
+	dryRun := flags.Bool("dry-run", false, "show the activity and the duplicates without logging it")
+	force := flags.Bool("force", false, "log the activity even when a duplicate has been logged")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	activityType, err := lookupActivityType(*typeFlag)
+	if err != nil {
+		return err
+	}
+	activity := ManualActivity{Type: activityType, Duration: *duration, Distance: *distance}
+	if *startFlag != "" {
+		if activity.StartTime, err = time.ParseInLocation(LOG_ACTIVITY_TIME_FORMAT, *startFlag, time.Local); err != nil {
+			return errors.New(InvalidActivityStartTime)
+		}
+	}
+	if err := activity.validate(time.Now()); err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	instances, err := newInstances(ctx)
+	if err != nil {
+		return err
+	}
+	accessToken, err := instances.getAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	return logActivity(ctx, *accessToken, activity, *dryRun, *force)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupActivityType(t *testing.T) {
+	activityType, err := lookupActivityType("Run")
+	assert.NoError(t, err)
+	assert.Equal(t, "Run", activityType.Name)
+
+	_, err = lookupActivityType("yoga")
+	assert.ErrorContains(t, err, UnknownActivityType)
+}
+
+func TestManualActivityValidate(t *testing.T) {
+	now := time.Date(2024, time.January, 14, 9, 0, 0, 0, time.Local)
+	run := ManualActivity{Type: activityTypes["run"], StartTime: now.Add(-2 * time.Hour), Duration: 30 * time.Minute, Distance: 5.2}
+	assert.NoError(t, run.validate(now))
+
+	future := run
+	future.StartTime = now.Add(time.Hour)
+	assert.EqualError(t, future.validate(now), InvalidActivityStartTime)
+
+	noStart := run
+	noStart.StartTime = time.Time{}
+	assert.EqualError(t, noStart.validate(now), InvalidActivityStartTime)
+
+	noDuration := run
+	noDuration.Duration = 0
+	assert.EqualError(t, noDuration.validate(now), InvalidActivityDuration)
+
+	tooLong := run
+	tooLong.Duration = 25 * time.Hour
+	assert.EqualError(t, tooLong.validate(now), InvalidActivityDuration)
+
+	noDistance := run
+	noDistance.Distance = 0
+	assert.EqualError(t, noDistance.validate(now), InvalidActivityDistance)
+
+	swim := ManualActivity{Type: activityTypes["swim"], StartTime: now.Add(-2 * time.Hour), Duration: 30 * time.Minute}
+	assert.NoError(t, swim.validate(now))
+}
+
+func TestManualActivityForm(t *testing.T) {
+	run := ManualActivity{
+		Type:      activityTypes["run"],
+		StartTime: time.Date(2024, time.January, 6, 7, 5, 0, 0, time.Local),
+		Duration:  32*time.Minute + 10*time.Second,
+		Distance:  5.2,
+	}
+	assert.Equal(t, "activityId=90009&date=2024-01-06&distance=5.2&distanceUnit=Kilometer&durationMillis=1930000&startTime=07%3A05", run.form().Encode())
+
+	swim := ManualActivity{Type: activityTypes["swim"], StartTime: run.StartTime, Duration: time.Hour}
+	assert.Equal(t, "activityId=90024&date=2024-01-06&durationMillis=3600000&startTime=07%3A05", swim.form().Encode())
+}
+
+func TestFindDuplicateActivities(t *testing.T) {
+	start := time.Date(2024, time.January, 6, 7, 0, 0, 0, time.Local)
+	run := ManualActivity{Type: activityTypes["run"], StartTime: start, Duration: 30 * time.Minute, Distance: 5}
+
+	duplicate := ActivityRecord{LogID: 1, Name: "Run", StartTime: start.Add(5 * time.Minute)}
+	later := ActivityRecord{LogID: 2, Name: "Run", StartTime: start.Add(time.Hour)}
+	walk := ActivityRecord{LogID: 3, Name: "Walk", StartTime: start}
+
+	assert.Equal(t, []ActivityRecord{duplicate}, findDuplicateActivities(run, []ActivityRecord{duplicate, later, walk}))
+	assert.Empty(t, findDuplicateActivities(run, []ActivityRecord{later, walk}))
+}
+
+func TestLogActivity(t *testing.T) {
+	start := time.Date(2024, time.January, 6, 7, 0, 0, 0, time.Local)
+	run := ManualActivity{Type: activityTypes["run"], StartTime: start, Duration: 30 * time.Minute, Distance: 5}
+
+	var posted []string
+	original := fitbitHTTPClient
+	defer func() { fitbitHTTPClient = original }()
+	mock := func(listBody string) *http.Client {
+		return &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+			if req.Method == http.MethodPost {
+				assert.Equal(t, "/1/user/-/activities.json", req.URL.Path)
+				body, _ := io.ReadAll(req.Body)
+				posted = append(posted, string(body))
+				return &http.Response{StatusCode: http.StatusCreated, Body: io.NopCloser(strings.NewReader(`{"activityLog": {"logId": 42}}`))}, nil
+			}
+			// the list is paged back from the day after the activity
+			body := `{"activities": []}`
+			if req.URL.Query().Get("beforeDate") == "2024-01-07" {
+				body = listBody
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+		})}
+	}
+
+	// nothing has been logged
+	fitbitHTTPClient = mock(`{"activities": []}`)
+	assert.NoError(t, logActivity(context.Background(), "token", run, true, false))
+	assert.Empty(t, posted)
+	assert.NoError(t, logActivity(context.Background(), "token", run, false, false))
+	assert.Equal(t, []string{run.form().Encode()}, posted)
+
+	// the run has been synced from another device
+	posted = nil
+	startTime := start.Add(3 * time.Minute).Format(FITBIT_OFFSET_TIME_FORMAT)
+	fitbitHTTPClient = mock(`{"activities": [{"logId": 1, "activityName": "Run", "startTime": "` + startTime + `", "duration": 1800000, "distance": 5}]}`)
+	assert.NoError(t, logActivity(context.Background(), "token", run, true, false))
+	assert.Empty(t, posted)
+	assert.EqualError(t, logActivity(context.Background(), "token", run, false, false), DuplicateActivity)
+	assert.Empty(t, posted)
+	assert.NoError(t, logActivity(context.Background(), "token", run, false, true))
+	assert.Len(t, posted, 1)
+}
+
+func TestCreateActivityLogFailure(t *testing.T) {
+	original := fitbitHTTPClient
+	defer func() { fitbitHTTPClient = original }()
+	fitbitHTTPClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusBadRequest, Status: "400 Bad Request", Body: io.NopCloser(strings.NewReader(`{}`))}, nil
+	})}
+
+	run := ManualActivity{Type: activityTypes["run"], StartTime: time.Now().Add(-time.Hour), Duration: 30 * time.Minute, Distance: 5}
+	_, err := createActivityLog(context.Background(), "token", run)
+	assert.ErrorContains(t, err, "400 Bad Request")
+}
@@ -2,14 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/ssm"
 )
 
 const (
@@ -27,65 +28,220 @@ var (
 	startDateParse, _   = time.Parse(DATE_FORMAT, startDate)
 	refreshCbBucketName = aws.String(os.Getenv("REFRESH_CB_BUCKET_NAME"))
 	refreshCbFileName   = aws.String(os.Getenv("REFRESH_CB_FILE_NAME_GO"))
+	// comma separated resource names reported in addition to steps, e.g. "floors,minutesVeryActive"
+	extraTimeSeriesResources = os.Getenv("EXTRA_TIME_SERIES_RESOURCES")
 )
 
-type Steps struct {
-	Date  time.Time
-	Value int
+const (
+	COMMAND_WEBHOOK    = "webhook"
+	COMMAND_DAILY      = "daily"
+	COMMAND_SUBSCRIBER = "subscriber"
+	COMMAND_BACKFILL   = "backfill"
+	COMMAND_EXPORT     = "export"
+	COMMAND_IMPORT     = "import"
+	COMMAND_SERVE      = "serve"
+	COMMAND_LOG        = "log-activity"
+	COMMAND_TRACKS     = "import-tracks"
+
+	MODE_WEEKLY  = "weekly"
+	MODE_DAILY   = "daily"
+	MODE_MONTHLY = "monthly"
+)
+
+// NotifierEvent is the input of the Lambda function, e.g. {"mode": "daily"} set on the schedule.
+type NotifierEvent struct {
+	Mode string `json:"mode"`
 }
 
 func main() {
-	lambda.Start(handler)
+	// the command is given as an argument, or with NOTIFIER_COMMAND on Lambda
+	command := os.Getenv("NOTIFIER_COMMAND")
+	var args []string
+	if len(os.Args) > 1 {
+		command, args = os.Args[1], os.Args[2:]
+	}
+
+	var err error
+	switch command {
+	case COMMAND_WEBHOOK:
+		err = runWebhook(args)
+	case COMMAND_SUBSCRIBER:
+		err = runSubscriber(args)
+	case COMMAND_BACKFILL:
+		err = runBackfill(args)
+	case COMMAND_EXPORT:
+		err = runExport(args)
+	case COMMAND_IMPORT:
+		err = runImport(args)
+	case COMMAND_SERVE:
+		err = runServe(args)
+	case COMMAND_LOG:
+		err = runLogActivity(args)
+	case COMMAND_TRACKS:
+		err = runImportTracks(args)
+	case COMMAND_DAILY:
+		err = handler(context.TODO(), NotifierEvent{Mode: MODE_DAILY})
+	default:
+		// outside Lambda, lambda.Start would wait for invocations forever, e.g. on a mistyped command
+		if command != "" && os.Getenv("AWS_LAMBDA_RUNTIME_API") == "" {
+			log.Fatalf("unknown command %q", command)
+		}
+		// the warm invocations of the function share the database
+		lambda.Start(handler)
+		return
+	}
+
+	if closeErr := closeSQLiteHistoryStores(); closeErr != nil {
+		log.Printf("failed to close the database: %v", closeErr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
-func handler() error {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+func handler(ctx context.Context, event NotifierEvent) error {
+	instances, err := newInstances(ctx)
+	if err != nil {
+		return err
+	}
+
+	lineUserId, err := instances.getParameter(os.Getenv("LINE_USER_ID_PARAMETER_NAME"))
 	if err != nil {
 		return err
 	}
 
-	instances := &Instances{
-		SSMClient: ssm.NewFromConfig(cfg),
-		S3Client:  s3.NewFromConfig(cfg),
+	// the users share the Fitbit account, whose refresh token can be used only once
+	tokens := &accessTokenCache{fetch: instances.getAccessToken}
+	var errs []error
+	for _, userID := range parseUserIDs(*lineUserId) {
+		if err := runMode(ctx, instances, event.Mode, userID, time.Now().Local(), tokens.get); err != nil {
+			errs = append(errs, fmt.Errorf("failed to run the %s notifier for %s: %v", event.Mode, userID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// parseUserIDs splits the comma separated LINE user IDs of LINE_USER_ID_PARAMETER_NAME.
+func parseUserIDs(value string) []string {
+	var userIDs []string
+	for _, userID := range strings.Split(value, ",") {
+		if userID = strings.TrimSpace(userID); userID != "" {
+			userIDs = append(userIDs, userID)
+		}
 	}
+	return userIDs
+}
 
-	clientIDParameterName := os.Getenv("CLIENT_ID_PARAMETER_NAME_GO")
-	clientID, err := instances.getParameter(clientIDParameterName)
+// runMode runs the notifier of mode for a user as of now. Lambda and the scheduler of the
+// serve command share it, and the runs of a process share instances and getAccessToken.
+func runMode(ctx context.Context, instances *Instances, mode string, userID string, now time.Time, getAccessToken func(ctx context.Context) (string, error)) error {
+	switch mode {
+	case MODE_DAILY:
+		return dailyHandler(ctx, instances, userID, now, getAccessToken)
+	case MODE_MONTHLY:
+		return monthlyHandler(ctx, instances, userID, now, getAccessToken)
+	default:
+		return weeklyHandler(ctx, instances, userID, now, getAccessToken)
+	}
+}
+
+func weeklyHandler(ctx context.Context, instances *Instances, userID string, today time.Time, getAccessToken func(ctx context.Context) (string, error)) error {
+	newAccessToken, err := getAccessToken(ctx)
 	if err != nil {
 		return err
 	}
 
-	clientSecretParameterName := os.Getenv("CLIENT_SECRET_PARAMETER_NAME_GO")
-	clientSecret, err := instances.getParameter(clientSecretParameterName)
+	lineChannelToken, err := instances.getParameter(os.Getenv("LINE_CHANNEL_TOKEN_PARAMETER_NAME"))
 	if err != nil {
 		return err
 	}
 
-	refreshToken, err := instances.getRefreshToken()
+	settings, err := loadUserSettings(userID)
+	if err != nil {
+		return err
+	}
+	window, err := settings.reportWindow(today)
 	if err != nil {
 		return err
 	}
 
-	newAccessToken, err := instances.refreshAccessToken(context.TODO(), *clientID, *clientSecret, *refreshToken)
+	stateStore, err := instances.newStateStore()
 	if err != nil {
 		return err
 	}
 
-	today := time.Now().Local()
+	var ledger *DeliveryLedger
+	deliveryKey := DeliveryKey{UserID: userID, Period: weeklyPeriod(today), Notifier: NOTIFIER_WEEKLY}
+	if stateStore != nil {
+		ledger = &DeliveryLedger{Store: stateStore}
+
+		// the reports of this period have already been sent by an earlier invocation
+		delivered, err := ledger.delivered(ctx, deliveryKey)
+		if err != nil {
+			return err
+		}
+		if delivered {
+			return nil
+		}
+	}
+
+	lifetimeStepsData, err := getLifetimeStepsHistory(ctx, newAccessToken, today, getStepsByDateRange)
+	if err != nil {
+		return err
+	}
 
-	lifetimeStepsData, err := getLifetimeStepsHistory(context.TODO(), *newAccessToken, today, getStepsByDateRange)
+	// imported or ingested data extends what the API returns
+	history, err := newHistoryStore(ctx)
 	if err != nil {
 		return err
 	}
+	if history != nil {
+		if err := mergeHistorySteps(ctx, history, lifetimeStepsData, today); err != nil {
+			return err
+		}
+	}
 
 	// if data is missing
 	if len(lifetimeStepsData) <= 7 {
 		return nil
 	}
 
-	stepsReport := generateStepsReport(lifetimeStepsData, today)
+	qualityFlags := checkDailySteps(stepsToTimeSeries(lifetimeStepsData), today, settings.maxDailySteps())
+	if settings.ExcludeFlaggedData {
+		excludeFlaggedSteps(lifetimeStepsData, qualityFlags)
+	}
+
+	stepsReportData := buildTimeSeriesReportData(stepsResource, stepsToTimeSeries(lifetimeStepsData), today, window)
+	// the stored days are not excluded, so the report is aggregated in memory then
+	if querier, ok := history.(timeSeriesReportQuerier); ok && !settings.ExcludeFlaggedData {
+		// aggregate in the database, which also holds the days before START_DATE
+		if err := history.PutDailyValues(ctx, stepsResource.Name, stepsToDailyValues(lifetimeStepsData)); err != nil {
+			return err
+		}
+		stepsReportData, err = querier.queryTimeSeriesReportData(ctx, stepsResource, today, window)
+		if err != nil {
+			return err
+		}
+	}
 
-	activityList, err := getActivityList(context.TODO(), *newAccessToken, today)
+	devices, err := getDevices(ctx, newAccessToken)
+	if err != nil {
+		// the reports are sent without the warnings, e.g. when the token lacks the settings scope
+		log.Printf("failed to get the devices: %v", err)
+	}
+	stepsReportData.LastSync = lastTrackerSync(devices)
+	if settings.Heatmap {
+		stepsReportData.Heatmap = buildHeatmap(stepsToTimeSeries(lifetimeStepsData), today, settings.stepsGoal())
+	}
+	if err := applyTopRecords(&stepsReportData, stepsToTimeSeries(lifetimeStepsData), settings); err != nil {
+		return err
+	}
+	stepsReport, err := newTimeSeriesReport(stepsReportData, settings)
+	if err != nil {
+		return err
+	}
+
+	activityList, err := getActivityList(ctx, newAccessToken, today)
 	if err != nil {
 		return err
 	}
@@ -95,20 +251,95 @@ func handler() error {
 		return err
 	}
 
-	runningReport := generateRunningReport(yearlyRunningLog, today)
+	if history != nil {
+		if err := mergeHistoryRuns(ctx, history, yearlyRunningLog, today); err != nil {
+			return err
+		}
+	}
 
-	lineChannelToken, err := instances.getParameter(os.Getenv("LINE_CHANNEL_TOKEN_PARAMETER_NAME"))
+	activityRecords, err := activityRecordsFromList(activityList)
 	if err != nil {
+		// the reports are sent without the checks of the activities
+		log.Printf("failed to check the activities: %v", err)
+	} else {
+		activityFlags := checkActivities(activityRecords)
+		if settings.ExcludeFlaggedData {
+			excludeFlaggedRuns(yearlyRunningLog, activityRecords, activityFlags)
+		}
+		qualityFlags = append(qualityFlags, activityFlags...)
+	}
+	// the flags before the window have been reported by the earlier reports
+	qualityFlags = flagsInWindow(qualityFlags, window)
+	logQualityFlags(userID, qualityFlags)
+
+	runningReportData := buildRunningReportData(yearlyRunningLog, today, window)
+	lifetimeRunningLog := yearlyRunningLog
+	if history != nil && len(settings.TopPeriods) > 0 {
+		// the API only returns the runs of this year
+		if lifetimeRunningLog, err = getHistoryRunningLog(ctx, history, yearlyRunningLog, today); err != nil {
+			return err
+		}
+	}
+	if err := applyRunningTopRecords(&runningReportData, lifetimeRunningLog, settings); err != nil {
 		return err
 	}
-	lineUserId, err := instances.getParameter(os.Getenv("LINE_USER_ID_PARAMETER_NAME"))
+
+	runningReport, err := newRunningReport(runningReportData, settings)
+	if err != nil {
+		return err
+	}
+
+	if settings.Charts {
+		blobStore, err := instances.newBlobStore()
+		if err != nil {
+			return err
+		}
+
+		err = attachStepsCharts(ctx, blobStore, userID, &stepsReport, stepsReportData, stepsToTimeSeries(lifetimeStepsData), settings)
+		if err != nil {
+			return err
+		}
+
+		err = attachRunningCharts(ctx, blobStore, userID, &runningReport, yearlyRunningLog, today, settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	extraReports, err := generateExtraTimeSeriesReports(ctx, newAccessToken, today, settings)
 	if err != nil {
 		return err
 	}
 
-	reports := []string{stepsReport, runningReport}
+	reports := append([]Report{stepsReport, runningReport}, extraReports...)
+
+	if settings.Insights {
+		text, err := renderInsightsReport(buildInsightsData(stepsToTimeSeries(lifetimeStepsData), window), settings)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, Report{Text: text})
+	}
+
+	if settings.QualityFooter && len(qualityFlags) > 0 {
+		text, err := renderQualityReport(buildQualityReportData(qualityFlags, settings.ExcludeFlaggedData), settings)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, Report{Text: text})
+	}
+
+	if deviceReportData := buildDeviceReportData(devices, today, settings); len(deviceReportData.Stale) > 0 || len(deviceReportData.LowBattery) > 0 {
+		text, err := renderDeviceReport(deviceReportData, settings)
+		if err != nil {
+			return err
+		}
+		// the warnings come last, as a sync between a failed push and its retry removes them and
+		// the ledger tracks the batches by their position
+		reports = append(reports, Report{Text: text})
+	}
 
-	err = sendReports(*lineChannelToken, *lineUserId, reports)
+	err = sendReports(ctx, *lineChannelToken, deliveryKey, reports, ledger)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// MonthlyReportData is the data model of monthly.tmpl. Month is the first day of the reported month.
+type MonthlyReportData struct {
+	Month   time.Time
+	Steps   float64
+	Average float64
+	Best    DailyValue
+	// steps of the month before Month
+	PreviousSteps   float64
+	RunningDistance float64
+	Runs            int
+}
+
+// StepsChange is the change of the steps from the month before.
+func (data MonthlyReportData) StepsChange() float64 {
+	return data.Steps - data.PreviousSteps
+}
+
+// buildMonthlyReportData reports the month before the month of today.
+func buildMonthlyReportData(lifetimeData map[time.Time]float64, runs []ActivityRecord, today time.Time) MonthlyReportData {
+	monthEnd := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+	monthStart := monthEnd.AddDate(0, -1, 0)
+	previousMonthStart := monthStart.AddDate(0, -1, 0)
+
+	data := MonthlyReportData{Month: monthStart}
+	days := 0
+	for date := monthStart; date.Before(monthEnd); date = date.AddDate(0, 0, 1) {
+		value := lifetimeData[date]
+		data.Steps += value
+		if value > data.Best.Value {
+			data.Best = DailyValue{date, value}
+		}
+		days += 1
+	}
+	data.Average = data.Steps / float64(days)
+
+	for date := previousMonthStart; date.Before(monthStart); date = date.AddDate(0, 0, 1) {
+		data.PreviousSteps += lifetimeData[date]
+	}
+
+	for _, record := range runs {
+		if record.Name == "Run" && !record.StartTime.Before(monthStart) && record.StartTime.Before(monthEnd) {
+			data.RunningDistance += record.Distance
+			data.Runs += 1
+		}
+	}
+
+	return data
+}
+
+func renderMonthlyReport(data MonthlyReportData, settings UserSettings) (string, error) {
+	tmpl, err := loadReportTemplate(settings, nil, MONTHLY_TEMPLATE_NAME)
+	if err != nil {
+		return "", err
+	}
+
+	return executeReportTemplate(tmpl, data)
+}
+
+// monthlyHandler sends the report of the previous month.
+func monthlyHandler(ctx context.Context, instances *Instances, userID string, today time.Time, getAccessToken func(ctx context.Context) (string, error)) error {
+	lineChannelToken, err := instances.getParameter(os.Getenv("LINE_CHANNEL_TOKEN_PARAMETER_NAME"))
+	if err != nil {
+		return err
+	}
+
+	settings, err := loadUserSettings(userID)
+	if err != nil {
+		return err
+	}
+
+	stateStore, err := instances.newStateStore()
+	if err != nil {
+		return err
+	}
+
+	var ledger *DeliveryLedger
+	monthStart := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location()).AddDate(0, -1, 0)
+	deliveryKey := DeliveryKey{UserID: userID, Period: monthlyPeriod(monthStart), Notifier: NOTIFIER_MONTHLY}
+	if stateStore != nil {
+		ledger = &DeliveryLedger{Store: stateStore}
+
+		delivered, err := ledger.delivered(ctx, deliveryKey)
+		if err != nil {
+			return err
+		}
+		if delivered {
+			return nil
+		}
+	}
+
+	newAccessToken, err := getAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	lifetimeData, err := getLifetimeTimeSeriesHistory(ctx, newAccessToken, stepsResource, today, getTimeSeriesByDateRange)
+	if err != nil {
+		return err
+	}
+
+	runs, err := getActivityRecordsByDateRange(ctx, newAccessToken, monthStart, monthStart.AddDate(0, 1, 0))
+	if err != nil {
+		return err
+	}
+
+	text, err := renderMonthlyReport(buildMonthlyReportData(lifetimeData, runs, today), settings)
+	if err != nil {
+		return err
+	}
+
+	return sendReports(ctx, *lineChannelToken, deliveryKey, []Report{{Text: text}}, ledger)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildMonthlyReportData(t *testing.T) {
+	lifetimeData := map[time.Time]float64{}
+	for date := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local); date.Before(time.Date(2024, time.March, 5, 0, 0, 0, 0, time.Local)); date = date.AddDate(0, 0, 1) {
+		lifetimeData[date] = 1000
+	}
+	lifetimeData[time.Date(2024, time.February, 10, 0, 0, 0, 0, time.Local)] = 15000
+
+	runs := []ActivityRecord{
+		{Name: "Run", StartTime: time.Date(2024, time.January, 31, 7, 0, 0, 0, time.Local), Distance: 3},
+		{Name: "Run", StartTime: time.Date(2024, time.February, 1, 7, 0, 0, 0, time.Local), Distance: 5},
+		{Name: "Walk", StartTime: time.Date(2024, time.February, 2, 7, 0, 0, 0, time.Local), Distance: 2},
+		{Name: "Run", StartTime: time.Date(2024, time.February, 29, 7, 0, 0, 0, time.Local), Distance: 5.5},
+	}
+
+	data := buildMonthlyReportData(lifetimeData, runs, time.Date(2024, time.March, 1, 9, 0, 0, 0, time.Local))
+
+	assert.Equal(t, time.Date(2024, time.February, 1, 0, 0, 0, 0, time.Local), data.Month)
+	assert.Equal(t, float64(28*1000+15000), data.Steps)
+	assert.Equal(t, float64(43000)/29, data.Average)
+	assert.Equal(t, DailyValue{time.Date(2024, time.February, 10, 0, 0, 0, 0, time.Local), 15000}, data.Best)
+	assert.Equal(t, float64(31000), data.PreviousSteps)
+	assert.Equal(t, 10.5, data.RunningDistance)
+	assert.Equal(t, 2, data.Runs)
+
+	actual, err := renderMonthlyReport(data, UserSettings{})
+	assert.NoError(t, err)
+	assert.Equal(t, "\n"+SEPARATOR+"Monthly Report (Feb 2024)\n\nTotal: 43,000\nvs Last Month: +12,000\nAverage: 1,482\nBest Day: 15,000(2/10)\n"+SEPARATOR+"Running Report\nMonthly Distance: 10.5km\nRuns: 2", actual)
+}
+
+func TestRenderMonthlyReportChange(t *testing.T) {
+	data := MonthlyReportData{Month: time.Date(2024, time.February, 1, 0, 0, 0, 0, time.Local), Steps: 30000, Average: 1034, PreviousSteps: 31000}
+	actual, err := renderMonthlyReport(data, UserSettings{})
+	assert.NoError(t, err)
+	assert.Contains(t, actual, "Total: 30,000\nvs Last Month: -1,000\nAverage")
+
+	data.PreviousSteps = 30000
+	actual, err = renderMonthlyReport(data, UserSettings{Locale: "ja"})
+	assert.NoError(t, err)
+	assert.Contains(t, actual, "先月比: ±0\n")
+
+	// no data of the month before
+	data.PreviousSteps = 0
+	actual, err = renderMonthlyReport(data, UserSettings{})
+	assert.NoError(t, err)
+	assert.NotContains(t, actual, "vs Last Month")
+}
+
+func TestMonthlyPeriod(t *testing.T) {
+	assert.Equal(t, "2024-02", monthlyPeriod(time.Date(2024, time.February, 1, 0, 0, 0, 0, time.Local)))
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+const (
+	// LINE limits
+	LINE_MAX_MESSAGES_PER_PUSH = 5
+	LINE_MAX_TEXT_LENGTH       = 5000
+)
+
+// messagePusher is implemented by *messaging_api.MessagingApiAPI.
+type messagePusher interface {
+	PushMessageWithHttpInfo(pushMessageRequest *messaging_api.PushMessageRequest, xLineRetryKey string) (*http.Response, *messaging_api.PushMessageResponse, error)
+}
+
+// PartialDeliveryError is returned when some of the pushes failed.
+// SentBatches lists the indices of the batches which have been delivered.
+type PartialDeliveryError struct {
+	SentBatches   []int
+	TotalBatches  int
+	SentMessages  int
+	TotalMessages int
+	Err           error
+}
+
+func (e *PartialDeliveryError) Error() string {
+	return fmt.Sprintf("delivered %d of %d batches (%d of %d messages), sent batches %v: %v", len(e.SentBatches), e.TotalBatches, e.SentMessages, e.TotalMessages, e.SentBatches, e.Err)
+}
+
+func (e *PartialDeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// batchMessages splits messages into batches of at most LINE_MAX_MESSAGES_PER_PUSH, keeping the order.
+func batchMessages(messages []messaging_api.MessageInterface) [][]messaging_api.MessageInterface {
+	var batches [][]messaging_api.MessageInterface
+	for start := 0; start < len(messages); start += LINE_MAX_MESSAGES_PER_PUSH {
+		end := start + LINE_MAX_MESSAGES_PER_PUSH
+		if end > len(messages) {
+			end = len(messages)
+		}
+		batches = append(batches, messages[start:end])
+	}
+	return batches
+}
+
+// pushBatch pushes a batch of messages. LINE answers 409 Conflict when a request with
+// the same retry key has already been accepted, which counts as delivered.
+func pushBatch(pusher messagePusher, userID string, batch []messaging_api.MessageInterface, retryKey string) error {
+	res, _, err := pusher.PushMessageWithHttpInfo(
+		&messaging_api.PushMessageRequest{
+			To:       userID,
+			Messages: batch,
+		},
+		retryKey,
+	)
+	if err != nil && retryKey != "" && res != nil && res.StatusCode == http.StatusConflict {
+		return nil
+	}
+	return err
+}
+
+// pushMessages pushes the messages in order, stopping at the first failed batch.
+func pushMessages(pusher messagePusher, userID string, messages []messaging_api.MessageInterface) error {
+	batches := batchMessages(messages)
+	partial := &PartialDeliveryError{
+		TotalBatches:  len(batches),
+		TotalMessages: len(messages),
+	}
+	for i, batch := range batches {
+		err := pushBatch(pusher, userID, batch, "")
+		if err != nil {
+			partial.Err = err
+			return partial
+		}
+		partial.SentBatches = append(partial.SentBatches, i)
+		partial.SentMessages += len(batch)
+	}
+	return nil
+}
+
+// utf16Length returns the number of UTF-16 code units of r, which is how LINE counts characters.
+func utf16Length(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+func textLength(text string) int {
+	length := 0
+	for _, r := range text {
+		length += utf16Length(r)
+	}
+	return length
+}
+
+// splitReportText splits text into chunks of at most limit characters. It splits on
+// SEPARATOR first, then on lines, and cuts a line only when the line itself is too long.
+// Joining the chunks gives back the original text.
+func splitReportText(text string, limit int) []string {
+	if textLength(text) <= limit {
+		return []string{text}
+	}
+
+	var sections []string
+	for i, section := range strings.Split(text, SEPARATOR) {
+		if i > 0 {
+			section = SEPARATOR + section
+		}
+		if section != "" {
+			sections = append(sections, section)
+		}
+	}
+
+	var pieces []string
+	for _, section := range sections {
+		if textLength(section) <= limit {
+			pieces = append(pieces, section)
+			continue
+		}
+		for _, line := range strings.SplitAfter(section, "\n") {
+			pieces = append(pieces, splitByLength(line, limit)...)
+		}
+	}
+
+	var chunks []string
+	current := ""
+	for _, piece := range pieces {
+		if current != "" && textLength(current)+textLength(piece) > limit {
+			chunks = append(chunks, current)
+			current = ""
+		}
+		current += piece
+	}
+	if current != "" {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+func splitByLength(text string, limit int) []string {
+	var pieces []string
+	current := ""
+	length := 0
+	for _, r := range text {
+		if length+utf16Length(r) > limit {
+			pieces = append(pieces, current)
+			current = ""
+			length = 0
+		}
+		current += string(r)
+		length += utf16Length(r)
+	}
+	if current != "" {
+		pieces = append(pieces, current)
+	}
+	return pieces
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockPusher struct {
+	requests  []*messaging_api.PushMessageRequest
+	retryKeys []string
+	calls     int
+	failAt    int
+	conflicts map[string]bool
+}
+
+func (m *mockPusher) PushMessageWithHttpInfo(pushMessageRequest *messaging_api.PushMessageRequest, xLineRetryKey string) (*http.Response, *messaging_api.PushMessageResponse, error) {
+	m.calls += 1
+	m.retryKeys = append(m.retryKeys, xLineRetryKey)
+	if m.calls-1 == m.failAt {
+		return &http.Response{StatusCode: http.StatusInternalServerError}, nil, errors.New("push failed")
+	}
+	if m.conflicts[xLineRetryKey] {
+		return &http.Response{StatusCode: http.StatusConflict}, nil, errors.New("unexpected status code: 409")
+	}
+	m.requests = append(m.requests, pushMessageRequest)
+	return &http.Response{StatusCode: http.StatusOK}, &messaging_api.PushMessageResponse{}, nil
+}
+
+func textMessages(n int) []messaging_api.MessageInterface {
+	var messages []messaging_api.MessageInterface
+	for i := 0; i < n; i++ {
+		messages = append(messages, messaging_api.TextMessage{Text: strings.Repeat("a", i+1)})
+	}
+	return messages
+}
+
+func TestPushMessages(t *testing.T) {
+	pusher := &mockPusher{failAt: -1}
+	err := pushMessages(pusher, "U1", textMessages(12))
+	assert.NoError(t, err)
+	assert.Len(t, pusher.requests, 3)
+	assert.Len(t, pusher.requests[0].Messages, 5)
+	assert.Len(t, pusher.requests[2].Messages, 2)
+	// order is preserved
+	assert.Equal(t, "aaaaaa", pusher.requests[1].Messages[0].(messaging_api.TextMessage).Text)
+
+	pusher = &mockPusher{failAt: 1}
+	err = pushMessages(pusher, "U1", textMessages(12))
+	var partial *PartialDeliveryError
+	assert.ErrorAs(t, err, &partial)
+	assert.Equal(t, []int{0}, partial.SentBatches)
+	assert.Equal(t, 3, partial.TotalBatches)
+	assert.Equal(t, 5, partial.SentMessages)
+	assert.Equal(t, 12, partial.TotalMessages)
+	assert.EqualError(t, err, "delivered 1 of 3 batches (5 of 12 messages), sent batches [0]: push failed")
+}
+
+func TestSplitReportText(t *testing.T) {
+	short := "\n" + SEPARATOR + "Weekly Report\n"
+	assert.Equal(t, []string{short}, splitReportText(short, LINE_MAX_TEXT_LENGTH))
+
+	section := SEPARATOR + strings.Repeat("1/7 Sun 1,000\n", 5)
+	text := "\n" + section + section + section
+	chunks := splitReportText(text, len(section)+1)
+	assert.Equal(t, []string{"\n" + section, section, section}, chunks)
+	assert.Equal(t, text, strings.Join(chunks, ""))
+
+	// a section longer than the limit is split on lines
+	long := SEPARATOR + strings.Repeat("line\n", 10)
+	chunks = splitReportText(long, 20)
+	assert.Equal(t, long, strings.Join(chunks, ""))
+	for _, chunk := range chunks {
+		assert.LessOrEqual(t, textLength(chunk), 20)
+	}
+
+	// a line longer than the limit is cut, counting surrogate pairs as 2
+	chunks = splitReportText(strings.Repeat("👟", 6), 4)
+	assert.Equal(t, []string{"👟👟", "👟👟", "👟👟"}, chunks)
+}
+
+func TestReportMessagesSplitsLongText(t *testing.T) {
+	section := SEPARATOR + strings.Repeat("あ", LINE_MAX_TEXT_LENGTH-100) + "\n"
+	messages := reportMessages(Report{Text: "\n" + section + section, ImageURLs: []string{"https://example.com/a.png"}})
+
+	assert.Len(t, messages, 3)
+	assert.IsType(t, messaging_api.ImageMessage{}, messages[2])
+}
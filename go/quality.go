@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	QUALITY_TEMPLATE_NAME = "quality.tmpl"
+
+	// a date between the first one and today has no value
+	QUALITY_MISSING_DATE = "missingDate"
+	// a day without steps between two days with steps, e.g. the tracker was not worn
+	QUALITY_ZERO_MID_STREAK = "zeroMidStreak"
+	// more steps than anyone walks in a day, e.g. a glitch of the tracker
+	QUALITY_IMPLAUSIBLE_VALUE = "implausibleValue"
+	// the same activity logged again, e.g. by the tracker and by hand
+	QUALITY_DUPLICATE_ACTIVITY = "duplicateActivity"
+	QUALITY_ZERO_DISTANCE_RUN  = "zeroDistanceRun"
+
+	DEFAULT_MAX_DAILY_STEPS = 100000
+	// activities of the same name started within this are duplicates
+	DUPLICATE_ACTIVITY_TOLERANCE = 10 * time.Minute
+)
+
+// qualityKinds is the order of the kinds in the summary.
+var qualityKinds = []string{
+	QUALITY_MISSING_DATE,
+	QUALITY_ZERO_MID_STREAK,
+	QUALITY_IMPLAUSIBLE_VALUE,
+	QUALITY_DUPLICATE_ACTIVITY,
+	QUALITY_ZERO_DISTANCE_RUN,
+}
+
+// QualityFlag is a suspicious day or activity found in the fetched data.
+type QualityFlag struct {
+	Kind string
+	Date time.Time
+	// set for the activity flags
+	Activity *ActivityRecord
+}
+
+func (flag QualityFlag) String() string {
+	if flag.Activity != nil {
+		return fmt.Sprintf("%s: %s at %s (log %d, %s)", flag.Kind, flag.Activity.Name,
+			flag.Activity.StartTime.Format(FITBIT_OFFSET_TIME_FORMAT), flag.Activity.LogID, flag.Activity.Source)
+	}
+	return fmt.Sprintf("%s: %s", flag.Kind, flag.Date.Format(DATE_FORMAT))
+}
+
+func (settings UserSettings) maxDailySteps() int {
+	if settings.MaxDailySteps <= 0 {
+		return DEFAULT_MAX_DAILY_STEPS
+	}
+	return settings.MaxDailySteps
+}
+
+// checkDailySteps flags the days from the first date in the data to the day before today.
+func checkDailySteps(lifetimeData map[time.Time]float64, today time.Time, maxSteps int) []QualityFlag {
+	var first time.Time
+	for date := range lifetimeData {
+		if first.IsZero() || date.Before(first) {
+			first = date
+		}
+	}
+	if first.IsZero() {
+		return nil
+	}
+
+	var flags []QualityFlag
+	todayDate := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, first.Location())
+	for date := first; date.Before(todayDate); date = date.AddDate(0, 0, 1) {
+		value, ok := lifetimeData[date]
+		switch {
+		case !ok:
+			flags = append(flags, QualityFlag{Kind: QUALITY_MISSING_DATE, Date: date})
+		case value > float64(maxSteps):
+			flags = append(flags, QualityFlag{Kind: QUALITY_IMPLAUSIBLE_VALUE, Date: date})
+		case value == 0 && lifetimeData[date.AddDate(0, 0, -1)] > 0 && lifetimeData[date.AddDate(0, 0, 1)] > 0:
+			flags = append(flags, QualityFlag{Kind: QUALITY_ZERO_MID_STREAK, Date: date})
+		}
+	}
+	return flags
+}
+
+// checkActivities flags the duplicates of activities, all but the first logged of them, and
+// the runs without distance.
+func checkActivities(records []ActivityRecord) []QualityFlag {
+	records = append([]ActivityRecord(nil), records...)
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].StartTime.Before(records[j].StartTime)
+	})
+
+	var flags []QualityFlag
+	for i := range records {
+		record := records[i]
+		for j := i - 1; j >= 0 && record.StartTime.Sub(records[j].StartTime) <= DUPLICATE_ACTIVITY_TOLERANCE; j-- {
+			if records[j].Name == record.Name && records[j].LogID != record.LogID {
+				flags = append(flags, QualityFlag{Kind: QUALITY_DUPLICATE_ACTIVITY, Date: record.StartTime, Activity: &records[i]})
+				break
+			}
+		}
+		if record.Name == "Run" && record.Distance == 0 {
+			flags = append(flags, QualityFlag{Kind: QUALITY_ZERO_DISTANCE_RUN, Date: record.StartTime, Activity: &records[i]})
+		}
+	}
+	return flags
+}
+
+func logQualityFlags(userID string, flags []QualityFlag) {
+	for _, flag := range flags {
+		log.Printf("data quality of %s: %s", userID, flag)
+	}
+}
+
+// excludeFlaggedSteps drops the implausible days, so that they are neither reported nor ranked.
+func excludeFlaggedSteps(lifetimeStepsData map[time.Time]int, flags []QualityFlag) {
+	for _, flag := range flags {
+		if flag.Kind == QUALITY_IMPLAUSIBLE_VALUE {
+			delete(lifetimeStepsData, flag.Date)
+		}
+	}
+}
+
+// excludeFlaggedRuns drops the duplicated runs and the runs without distance. The log keeps one
+// run per start time, so a start time shared with a run which is not flagged, e.g. the first
+// logged of duplicates, keeps the distance of that run.
+func excludeFlaggedRuns(runningLog map[time.Time]float64, records []ActivityRecord, flags []QualityFlag) {
+	flagged := map[int64]bool{}
+	for _, flag := range flags {
+		if flag.Activity != nil && flag.Activity.Name == "Run" {
+			flagged[flag.Activity.LogID] = true
+		}
+	}
+
+	// the zones of the keys differ from the parsed start times
+	deleted := map[int64]time.Time{}
+	for _, record := range records {
+		if !flagged[record.LogID] {
+			continue
+		}
+		for startTime := range runningLog {
+			if startTime.Equal(record.StartTime) {
+				delete(runningLog, startTime)
+				deleted[startTime.UnixNano()] = startTime
+			}
+		}
+	}
+	for _, record := range records {
+		if record.Name != "Run" || flagged[record.LogID] {
+			continue
+		}
+		if startTime, ok := deleted[record.StartTime.UnixNano()]; ok {
+			runningLog[startTime] = record.Distance
+		}
+	}
+}
+
+// flagsInWindow returns the flags of the days and the activities in the window, so that the
+// flags of the weeks before are not reported again.
+func flagsInWindow(flags []QualityFlag, window ReportWindow) []QualityFlag {
+	var inWindow []QualityFlag
+	for _, flag := range flags {
+		if window.Contains(flag.Date) {
+			inWindow = append(inWindow, flag)
+		}
+	}
+	return inWindow
+}
+
+// QualityCount is the number of flags of a kind and the latest of them.
+type QualityCount struct {
+	Kind   string
+	Count  int
+	Latest time.Time
+}
+
+// QualityReportData is the data model of quality.tmpl.
+type QualityReportData struct {
+	Counts []QualityCount
+	// the flagged data is not in the reports
+	Excluded bool
+}
+
+func buildQualityReportData(flags []QualityFlag, excluded bool) QualityReportData {
+	data := QualityReportData{Excluded: excluded}
+	for _, kind := range qualityKinds {
+		count := QualityCount{Kind: kind}
+		for _, flag := range flags {
+			if flag.Kind != kind {
+				continue
+			}
+			count.Count++
+			if flag.Date.After(count.Latest) {
+				count.Latest = flag.Date
+			}
+		}
+		if count.Count > 0 {
+			data.Counts = append(data.Counts, count)
+		}
+	}
+	return data
+}
+
+func renderQualityReport(data QualityReportData, settings UserSettings) (string, error) {
+	tmpl, err := loadReportTemplate(settings, nil, QUALITY_TEMPLATE_NAME)
+	if err != nil {
+		return "", err
+	}
+
+	return executeReportTemplate(tmpl, data)
+}
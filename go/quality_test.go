@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckDailySteps(t *testing.T) {
+	today := time.Date(2024, time.January, 8, 9, 0, 0, 0, time.Local)
+	lifetimeData := map[time.Time]float64{
+		time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local): 8000,
+		time.Date(2024, time.January, 2, 0, 0, 0, 0, time.Local): 0,
+		time.Date(2024, time.January, 3, 0, 0, 0, 0, time.Local): 9000,
+		// Jan 4 is missing
+		time.Date(2024, time.January, 5, 0, 0, 0, 0, time.Local): 250000,
+		time.Date(2024, time.January, 6, 0, 0, 0, 0, time.Local): 7000,
+		// not synced yet, which is not mid-streak
+		time.Date(2024, time.January, 7, 0, 0, 0, 0, time.Local): 0,
+	}
+
+	flags := checkDailySteps(lifetimeData, today, DEFAULT_MAX_DAILY_STEPS)
+
+	assert.Equal(t, []QualityFlag{
+		{Kind: QUALITY_ZERO_MID_STREAK, Date: time.Date(2024, time.January, 2, 0, 0, 0, 0, time.Local)},
+		{Kind: QUALITY_MISSING_DATE, Date: time.Date(2024, time.January, 4, 0, 0, 0, 0, time.Local)},
+		{Kind: QUALITY_IMPLAUSIBLE_VALUE, Date: time.Date(2024, time.January, 5, 0, 0, 0, 0, time.Local)},
+	}, flags)
+
+	assert.Len(t, checkDailySteps(lifetimeData, today, 300000), 2)
+}
+
+func TestCheckActivities(t *testing.T) {
+	start := time.Date(2024, time.January, 6, 7, 0, 0, 0, time.Local)
+	tracked := ActivityRecord{LogID: 1, Name: "Run", StartTime: start, Distance: 5, Source: "tracker"}
+	manual := ActivityRecord{LogID: 2, Name: "Run", StartTime: start.Add(3 * time.Minute), Distance: 5.2, Source: "manual"}
+	walk := ActivityRecord{LogID: 3, Name: "Walk", StartTime: start.Add(5 * time.Minute), Source: "auto_detected"}
+	noDistance := ActivityRecord{LogID: 4, Name: "Run", StartTime: start.Add(2 * time.Hour), Source: "manual"}
+
+	flags := checkActivities([]ActivityRecord{noDistance, walk, manual, tracked})
+
+	assert.Len(t, flags, 2)
+	assert.Equal(t, QUALITY_DUPLICATE_ACTIVITY, flags[0].Kind)
+	assert.Equal(t, manual, *flags[0].Activity)
+	assert.Equal(t, QUALITY_ZERO_DISTANCE_RUN, flags[1].Kind)
+	assert.Equal(t, noDistance, *flags[1].Activity)
+}
+
+func TestExcludeFlaggedData(t *testing.T) {
+	implausible := time.Date(2024, time.January, 5, 0, 0, 0, 0, time.Local)
+	lifetimeStepsData := map[time.Time]int{
+		implausible: 250000,
+		time.Date(2024, time.January, 6, 0, 0, 0, 0, time.Local): 7000,
+	}
+	excludeFlaggedSteps(lifetimeStepsData, []QualityFlag{{Kind: QUALITY_IMPLAUSIBLE_VALUE, Date: implausible}})
+	assert.NotContains(t, lifetimeStepsData, implausible)
+	assert.Len(t, lifetimeStepsData, 1)
+
+	// the running log is keyed in the zone of the API response
+	zone := time.FixedZone("", 9*60*60)
+	first := ActivityRecord{LogID: 1, Name: "Run", StartTime: time.Date(2024, time.January, 6, 7, 0, 0, 0, zone), Distance: 5}
+	duplicate := ActivityRecord{LogID: 2, Name: "Run", StartTime: time.Date(2024, time.January, 6, 7, 3, 0, 0, zone), Distance: 5.2}
+	runningLog := map[time.Time]float64{
+		time.Date(2024, time.January, 6, 7, 0, 0, 0, time.FixedZone("", 9*60*60)): 5,
+		time.Date(2024, time.January, 6, 7, 3, 0, 0, time.FixedZone("", 9*60*60)): 5.2,
+	}
+	excludeFlaggedRuns(runningLog, []ActivityRecord{first, duplicate}, []QualityFlag{{Kind: QUALITY_DUPLICATE_ACTIVITY, Activity: &duplicate}})
+	assert.Len(t, runningLog, 1)
+
+	// the duplicate started at the same time as the first one
+	sameStart := ActivityRecord{LogID: 3, Name: "Run", StartTime: first.StartTime, Distance: 5.1}
+	runningLog = map[time.Time]float64{time.Date(2024, time.January, 6, 7, 0, 0, 0, time.FixedZone("", 9*60*60)): 5.1}
+	excludeFlaggedRuns(runningLog, []ActivityRecord{first, sameStart}, []QualityFlag{{Kind: QUALITY_DUPLICATE_ACTIVITY, Activity: &sameStart}})
+	assert.Equal(t, map[int64]float64{first.StartTime.Unix(): 5}, runningLogByUnix(runningLog))
+
+	// a run without distance at the same time as a run with one
+	zeroRun := ActivityRecord{LogID: 4, Name: "Run", StartTime: first.StartTime}
+	runningLog = map[time.Time]float64{time.Date(2024, time.January, 6, 7, 0, 0, 0, time.FixedZone("", 9*60*60)): 0}
+	excludeFlaggedRuns(runningLog, []ActivityRecord{first, zeroRun}, []QualityFlag{{Kind: QUALITY_DUPLICATE_ACTIVITY, Activity: &zeroRun}, {Kind: QUALITY_ZERO_DISTANCE_RUN, Activity: &zeroRun}})
+	assert.Equal(t, map[int64]float64{first.StartTime.Unix(): 5}, runningLogByUnix(runningLog))
+}
+
+func TestFlagsInWindow(t *testing.T) {
+	window := ReportWindow{Start: time.Date(2024, time.January, 7, 0, 0, 0, 0, time.Local), End: time.Date(2024, time.January, 14, 0, 0, 0, 0, time.Local)}
+	old := QualityFlag{Kind: QUALITY_MISSING_DATE, Date: time.Date(2023, time.March, 4, 0, 0, 0, 0, time.Local)}
+	missing := QualityFlag{Kind: QUALITY_MISSING_DATE, Date: time.Date(2024, time.January, 7, 0, 0, 0, 0, time.Local)}
+	run := ActivityRecord{LogID: 1, Name: "Run", StartTime: time.Date(2024, time.January, 13, 7, 0, 0, 0, time.Local)}
+	zeroRun := QualityFlag{Kind: QUALITY_ZERO_DISTANCE_RUN, Date: run.StartTime, Activity: &run}
+	today := QualityFlag{Kind: QUALITY_MISSING_DATE, Date: window.End}
+
+	assert.Equal(t, []QualityFlag{missing, zeroRun}, flagsInWindow([]QualityFlag{old, missing, zeroRun, today}, window))
+	assert.Empty(t, flagsInWindow([]QualityFlag{old}, window))
+}
+
+func TestRenderQualityReport(t *testing.T) {
+	flags := []QualityFlag{
+		{Kind: QUALITY_IMPLAUSIBLE_VALUE, Date: time.Date(2024, time.January, 5, 0, 0, 0, 0, time.Local)},
+		{Kind: QUALITY_MISSING_DATE, Date: time.Date(2023, time.March, 4, 0, 0, 0, 0, time.Local)},
+		{Kind: QUALITY_MISSING_DATE, Date: time.Date(2023, time.March, 5, 0, 0, 0, 0, time.Local)},
+	}
+
+	actual, err := renderQualityReport(buildQualityReportData(flags, true), UserSettings{})
+	assert.NoError(t, err)
+	assert.Equal(t, `
+======================
+Data Quality
+Missing days: 2 (latest 2023/3/5)
+Implausible days: 1 (latest 2024/1/5)
+Implausible days and flagged runs are excluded from the reports.
+`, actual)
+
+	actual, err = renderQualityReport(buildQualityReportData(flags[:1], false), UserSettings{Locale: "ja"})
+	assert.NoError(t, err)
+	assert.Equal(t, `
+======================
+データ品質
+異常値の日: 1 (最新 2024年1月5日)
+`, actual)
+}
+
+func TestActivityRecordsFromList(t *testing.T) {
+	activityList := []interface{}{
+		map[string]interface{}{
+			"logId":        float64(10),
+			"activityName": "Run",
+			"startTime":    "2024-01-06T07:00:00.000+09:00",
+			"duration":     float64(1800000),
+			"distance":     5.5,
+			"logType":      "tracker",
+		},
+	}
+
+	records, err := activityRecordsFromList(activityList)
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, int64(10), records[0].LogID)
+	assert.Equal(t, 30*time.Minute, records[0].Duration)
+	assert.Equal(t, 5.5, records[0].Distance)
+	assert.Equal(t, "tracker", records[0].Source)
+}
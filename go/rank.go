@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// DailyRank places a day of the weekly report in the history.
+type DailyRank struct {
+	// share of the days in the lifetime below the day, ties counted as half
+	Percentile int
+	// 1 for the best day of Year
+	YearlyRank int
+	Year       int
+}
+
+// rankWeek sets the ranks of the days and of the total of the weekly report. items are the
+// lifetime values sorted by sortDailyValues, so each rank is a binary search.
+func rankWeek(data *TimeSeriesReportData, items []DailyValue, lifetimeData map[time.Time]float64) {
+	windowDays := data.Window.Len()
+
+	yearlyItems := map[int][]DailyValue{}
+	// totals of the windows of the same length before the report's, 0 is the report's
+	weeklyTotals := map[int]float64{}
+	for _, item := range items {
+		yearlyItems[item.Date.Year()] = append(yearlyItems[item.Date.Year()], item)
+
+		daysBefore := int(math.Round(data.Window.End.Sub(item.Date).Hours() / 24))
+		if daysBefore >= 1 && windowDays > 0 {
+			weeklyTotals[(daysBefore-1)/windowDays] += item.Value
+		}
+	}
+
+	data.Ranks = map[time.Time]*DailyRank{}
+	for _, dailyValue := range data.Weekly {
+		if _, ok := lifetimeData[dailyValue.Date]; !ok {
+			continue
+		}
+
+		above := countAbove(items, dailyValue.Value)
+		below := len(items) - sort.Search(len(items), func(i int) bool { return items[i].Value < dailyValue.Value })
+		equal := len(items) - above - below
+
+		year := dailyValue.Date.Year()
+		data.Ranks[dailyValue.Date] = &DailyRank{
+			Percentile: int((float64(below) + float64(equal)/2) / float64(len(items)) * 100),
+			YearlyRank: countAbove(yearlyItems[year], dailyValue.Value) + 1,
+			Year:       year,
+		}
+	}
+
+	if _, ok := weeklyTotals[0]; !ok {
+		return
+	}
+	data.WeekCount = len(weeklyTotals)
+	data.WeeklyTotalRank = 1
+	for _, total := range weeklyTotals {
+		if total > data.WeeklyTotal {
+			data.WeeklyTotalRank++
+		}
+	}
+}
+
+// countAbove counts the items with a value greater than value.
+func countAbove(items []DailyValue, value float64) int {
+	return sort.Search(len(items), func(i int) bool { return items[i].Value <= value })
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRankWeek(t *testing.T) {
+	today := time.Date(2024, time.January, 4, 0, 0, 0, 0, time.Local)
+	lifetimeData := map[time.Time]float64{
+		// the week before last
+		time.Date(2023, time.December, 21, 0, 0, 0, 0, time.Local): 20000,
+		time.Date(2023, time.December, 22, 0, 0, 0, 0, time.Local): 9000,
+		// the week of the report, without Dec 30
+		time.Date(2023, time.December, 28, 0, 0, 0, 0, time.Local): 5000,
+		time.Date(2023, time.December, 29, 0, 0, 0, 0, time.Local): 9000,
+		time.Date(2023, time.December, 31, 0, 0, 0, 0, time.Local): 1000,
+		time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local):   8000,
+		time.Date(2024, time.January, 2, 0, 0, 0, 0, time.Local):   3000,
+		time.Date(2024, time.January, 3, 0, 0, 0, 0, time.Local):   8000,
+	}
+
+	data := buildTimeSeriesReportData(stepsResource, lifetimeData, today, lastSevenDays(today))
+
+	assert.Equal(t, map[time.Time]*DailyRank{
+		time.Date(2023, time.December, 28, 0, 0, 0, 0, time.Local): {Percentile: 31, YearlyRank: 4, Year: 2023},
+		// tied with Dec 22
+		time.Date(2023, time.December, 29, 0, 0, 0, 0, time.Local): {Percentile: 75, YearlyRank: 2, Year: 2023},
+		time.Date(2023, time.December, 31, 0, 0, 0, 0, time.Local): {Percentile: 6, YearlyRank: 5, Year: 2023},
+		time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local):   {Percentile: 50, YearlyRank: 1, Year: 2024},
+		time.Date(2024, time.January, 2, 0, 0, 0, 0, time.Local):   {Percentile: 18, YearlyRank: 3, Year: 2024},
+		time.Date(2024, time.January, 3, 0, 0, 0, 0, time.Local):   {Percentile: 50, YearlyRank: 1, Year: 2024},
+	}, data.Ranks)
+
+	// 34,000 against 29,000 and an empty week in between, which is not counted
+	assert.Equal(t, 1, data.WeeklyTotalRank)
+	assert.Equal(t, 2, data.WeekCount)
+}
+
+func TestRankWeekWithoutData(t *testing.T) {
+	today := time.Date(2024, time.January, 14, 0, 0, 0, 0, time.Local)
+	lifetimeData := map[time.Time]float64{
+		time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local): 8000,
+	}
+
+	data := buildTimeSeriesReportData(stepsResource, lifetimeData, today, lastSevenDays(today))
+
+	assert.Empty(t, data.Ranks)
+	assert.Equal(t, 0, data.WeekCount)
+
+	actual, err := renderTimeSeriesReport(data, UserSettings{})
+	assert.NoError(t, err)
+	assert.Contains(t, actual, "1/7 Sun 0\n")
+	assert.Contains(t, actual, "Total: 0\n")
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	DEFAULT_TOP_N = 5
+
+	// 7 consecutive days, whichever weekday they start on
+	PERIOD_ROLLING_WEEK = "rollingWeek"
+	// calendar weeks from Monday
+	PERIOD_WEEK  = "week"
+	PERIOD_MONTH = "month"
+	PERIOD_YEAR  = "year"
+)
+
+// PeriodTotal is the total of the days from Start to End, both inclusive.
+type PeriodTotal struct {
+	Start time.Time
+	End   time.Time
+	Value float64
+}
+
+// PeriodRecords are the periods with the largest totals. ThisYear is empty for PERIOD_YEAR.
+type PeriodRecords struct {
+	Period   string
+	ThisYear []PeriodTotal
+	Lifetime []PeriodTotal
+}
+
+func (settings UserSettings) topN() int {
+	if settings.TopN <= 0 {
+		return DEFAULT_TOP_N
+	}
+	return settings.TopN
+}
+
+// selectTopDailyValues returns the first n items of this year and of the lifetime. items are
+// sorted by sortDailyValues.
+func selectTopDailyValues(items []DailyValue, n int, today time.Time) ([]DailyValue, []DailyValue) {
+	yearStart := time.Date(today.Year(), time.January, 1, 0, 0, 0, 0, today.Location())
+
+	var yearlyTop, lifetimeTop []DailyValue
+	for count := 0; count < len(items) && (len(yearlyTop) < n || count < n); count++ {
+		if len(yearlyTop) < n && !items[count].Date.Before(yearStart) {
+			yearlyTop = append(yearlyTop, items[count])
+		}
+		if count < n {
+			lifetimeTop = append(lifetimeTop, items[count])
+		}
+	}
+	return yearlyTop, lifetimeTop
+}
+
+// periodStart returns the first day of the calendar period containing date.
+func periodStart(period string, date time.Time) time.Time {
+	date = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	switch period {
+	case PERIOD_WEEK:
+		return date.AddDate(0, 0, -(int(date.Weekday())+6)%7)
+	case PERIOD_MONTH:
+		return date.AddDate(0, 0, 1-date.Day())
+	default:
+		return time.Date(date.Year(), time.January, 1, 0, 0, 0, 0, date.Location())
+	}
+}
+
+// periodEnd returns the last day of the calendar period starting on start.
+func periodEnd(period string, start time.Time) time.Time {
+	switch period {
+	case PERIOD_WEEK:
+		return start.AddDate(0, 0, 6)
+	case PERIOD_MONTH:
+		return start.AddDate(0, 1, -1)
+	default:
+		return start.AddDate(1, 0, -1)
+	}
+}
+
+// sumPeriods totals the days before today by period, the current period so far included.
+func sumPeriods(dailyData map[time.Time]float64, period string, today time.Time) []PeriodTotal {
+	todayDate := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+
+	if period == PERIOD_ROLLING_WEEK {
+		var first time.Time
+		for date := range dailyData {
+			if first.IsZero() || date.Before(first) {
+				first = date
+			}
+		}
+		if first.IsZero() {
+			return nil
+		}
+
+		// slide a window of 7 days over the dates
+		var totals []PeriodTotal
+		sum := 0.0
+		for date := first; date.Before(todayDate); date = date.AddDate(0, 0, 1) {
+			sum += dailyData[date]
+			start := date.AddDate(0, 0, -6)
+			if start.Before(first) {
+				continue
+			}
+			totals = append(totals, PeriodTotal{Start: start, End: date, Value: sum})
+			sum -= dailyData[start]
+		}
+		return totals
+	}
+
+	sums := map[time.Time]float64{}
+	for date, value := range dailyData {
+		if date.Before(todayDate) {
+			sums[periodStart(period, date)] += value
+		}
+	}
+	var totals []PeriodTotal
+	for start, value := range sums {
+		totals = append(totals, PeriodTotal{Start: start, End: periodEnd(period, start), Value: value})
+	}
+	return totals
+}
+
+// topPeriodTotals returns the n largest totals which start on or after since. A period
+// overlapping a larger one is skipped, so that a single active week is not ranked 7 times.
+func topPeriodTotals(totals []PeriodTotal, n int, since time.Time) []PeriodTotal {
+	var top []PeriodTotal
+	for _, total := range totals {
+		if len(top) == n {
+			break
+		}
+		if total.Value <= 0 || total.Start.Before(since) {
+			continue
+		}
+
+		overlapped := false
+		for _, selected := range top {
+			if !total.Start.After(selected.End) && !selected.Start.After(total.End) {
+				overlapped = true
+				break
+			}
+		}
+		if !overlapped {
+			top = append(top, total)
+		}
+	}
+	return top
+}
+
+// buildPeriodRecords ranks the periods of the daily data by total.
+func buildPeriodRecords(dailyData map[time.Time]float64, periods []string, n int, today time.Time) ([]PeriodRecords, error) {
+	var records []PeriodRecords
+	for _, period := range periods {
+		switch period {
+		case PERIOD_ROLLING_WEEK, PERIOD_WEEK, PERIOD_MONTH, PERIOD_YEAR:
+		default:
+			return nil, fmt.Errorf("%s: %s", UnknownRecordPeriod, period)
+		}
+
+		totals := sumPeriods(dailyData, period, today)
+		// older periods first on ties, like sortDailyValues
+		sort.SliceStable(totals, func(i, j int) bool {
+			if totals[i].Value == totals[j].Value {
+				return totals[i].Start.Before(totals[j].Start)
+			}
+			return totals[i].Value > totals[j].Value
+		})
+
+		periodRecords := PeriodRecords{
+			Period:   period,
+			Lifetime: topPeriodTotals(totals, n, time.Time{}),
+		}
+		if period != PERIOD_YEAR {
+			periodRecords.ThisYear = topPeriodTotals(totals, n, periodStart(PERIOD_YEAR, today))
+		}
+		records = append(records, periodRecords)
+	}
+	return records, nil
+}
+
+// applyTopRecords sets the top records of the report as configured in the settings.
+func applyTopRecords(data *TimeSeriesReportData, lifetimeData map[time.Time]float64, settings UserSettings) error {
+	if settings.topN() != DEFAULT_TOP_N {
+		data.YearlyTop, data.LifetimeTop = selectTopDailyValues(sortDailyValues(lifetimeData), settings.topN(), data.Today)
+	}
+
+	topPeriods, err := buildPeriodRecords(lifetimeData, settings.TopPeriods, settings.topN(), data.Today)
+	if err != nil {
+		return err
+	}
+	data.TopPeriods = topPeriods
+	return nil
+}
+
+// applyRunningTopRecords sets the top periods of the running distance. runningLog is keyed by
+// the start time of the runs.
+func applyRunningTopRecords(data *RunningReportData, runningLog map[time.Time]float64, settings UserSettings) error {
+	dailyDistance := map[time.Time]float64{}
+	for startTime, distance := range runningLog {
+		startTime = startTime.In(data.Today.Location())
+		dailyDistance[time.Date(startTime.Year(), startTime.Month(), startTime.Day(), 0, 0, 0, 0, startTime.Location())] += distance
+	}
+
+	topPeriods, err := buildPeriodRecords(dailyDistance, settings.TopPeriods, settings.topN(), data.Today)
+	if err != nil {
+		return err
+	}
+	data.TopPeriods = topPeriods
+	return nil
+}
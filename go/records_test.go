@@ -0,0 +1,142 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectTopDailyValues(t *testing.T) {
+	today := time.Date(2024, time.January, 10, 0, 0, 0, 0, time.Local)
+	items := sortDailyValues(map[time.Time]float64{
+		time.Date(2023, time.December, 30, 0, 0, 0, 0, time.Local): 9000,
+		time.Date(2023, time.December, 31, 0, 0, 0, 0, time.Local): 8000,
+		time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local):   7000,
+		time.Date(2024, time.January, 2, 0, 0, 0, 0, time.Local):   6000,
+		time.Date(2024, time.January, 3, 0, 0, 0, 0, time.Local):   5000,
+	})
+
+	yearlyTop, lifetimeTop := selectTopDailyValues(items, 2, today)
+
+	assert.Equal(t, []DailyValue{
+		{time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local), 7000},
+		{time.Date(2024, time.January, 2, 0, 0, 0, 0, time.Local), 6000},
+	}, yearlyTop)
+	assert.Equal(t, []DailyValue{
+		{time.Date(2023, time.December, 30, 0, 0, 0, 0, time.Local), 9000},
+		{time.Date(2023, time.December, 31, 0, 0, 0, 0, time.Local), 8000},
+	}, lifetimeTop)
+}
+
+func TestBuildPeriodRecords(t *testing.T) {
+	today := time.Date(2024, time.January, 20, 0, 0, 0, 0, time.Local)
+	dailyData := map[time.Time]float64{}
+	// 1,000 a day from Dec 1, with an active Thursday to Wednesday in each year
+	for date := time.Date(2023, time.December, 1, 0, 0, 0, 0, time.Local); date.Before(today); date = date.AddDate(0, 0, 1) {
+		dailyData[date] = 1000
+	}
+	for day := 0; day < 7; day++ {
+		dailyData[time.Date(2023, time.December, 7+day, 0, 0, 0, 0, time.Local)] = 3000
+		dailyData[time.Date(2024, time.January, 4+day, 0, 0, 0, 0, time.Local)] = 2000
+	}
+
+	records, err := buildPeriodRecords(dailyData, []string{PERIOD_ROLLING_WEEK, PERIOD_WEEK, PERIOD_MONTH, PERIOD_YEAR}, 2, today)
+	assert.NoError(t, err)
+	assert.Len(t, records, 4)
+
+	rollingWeek := records[0]
+	assert.Equal(t, []PeriodTotal{
+		{time.Date(2024, time.January, 4, 0, 0, 0, 0, time.Local), time.Date(2024, time.January, 10, 0, 0, 0, 0, time.Local), 14000},
+		// Jan 5 to 11 and the like overlap the first one
+		{time.Date(2024, time.January, 11, 0, 0, 0, 0, time.Local), time.Date(2024, time.January, 17, 0, 0, 0, 0, time.Local), 7000},
+	}, rollingWeek.ThisYear)
+	assert.Equal(t, []PeriodTotal{
+		{time.Date(2023, time.December, 7, 0, 0, 0, 0, time.Local), time.Date(2023, time.December, 13, 0, 0, 0, 0, time.Local), 21000},
+		{time.Date(2024, time.January, 4, 0, 0, 0, 0, time.Local), time.Date(2024, time.January, 10, 0, 0, 0, 0, time.Local), 14000},
+	}, rollingWeek.Lifetime)
+
+	week := records[1]
+	assert.Equal(t, []PeriodTotal{
+		// Monday Dec 4 to Sunday Dec 10 has 4 active days, Dec 11 to 17 has 3
+		{time.Date(2023, time.December, 4, 0, 0, 0, 0, time.Local), time.Date(2023, time.December, 10, 0, 0, 0, 0, time.Local), 15000},
+		{time.Date(2023, time.December, 11, 0, 0, 0, 0, time.Local), time.Date(2023, time.December, 17, 0, 0, 0, 0, time.Local), 13000},
+	}, week.Lifetime)
+
+	month := records[2]
+	assert.Equal(t, []PeriodTotal{
+		// this month so far
+		{time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local), time.Date(2024, time.January, 31, 0, 0, 0, 0, time.Local), 26000},
+	}, month.ThisYear)
+	assert.Equal(t, time.Date(2023, time.December, 1, 0, 0, 0, 0, time.Local), month.Lifetime[0].Start)
+	assert.Equal(t, float64(45000), month.Lifetime[0].Value)
+
+	year := records[3]
+	assert.Empty(t, year.ThisYear)
+	assert.Len(t, year.Lifetime, 2)
+}
+
+func TestBuildPeriodRecordsWithUnknownPeriod(t *testing.T) {
+	_, err := buildPeriodRecords(map[time.Time]float64{}, []string{"decade"}, 5, time.Now())
+	assert.ErrorContains(t, err, UnknownRecordPeriod)
+}
+
+func TestRenderTopPeriods(t *testing.T) {
+	today := time.Date(2024, time.January, 20, 0, 0, 0, 0, time.Local)
+	lifetimeData := map[time.Time]float64{}
+	for date := time.Date(2023, time.December, 25, 0, 0, 0, 0, time.Local); date.Before(today); date = date.AddDate(0, 0, 1) {
+		lifetimeData[date] = 1000
+	}
+	settings := UserSettings{TopN: 1, TopPeriods: []string{PERIOD_MONTH, PERIOD_YEAR}}
+
+	data := buildTimeSeriesReportData(stepsResource, lifetimeData, today, lastSevenDays(today))
+	assert.NoError(t, applyTopRecords(&data, lifetimeData, settings))
+	assert.Len(t, data.LifetimeTop, 1)
+
+	actual, err := renderTimeSeriesReport(data, settings)
+	assert.NoError(t, err)
+	assert.Contains(t, actual, `======================
+Top Records in Lifetime
+
+1,000(2023/12/25)
+======================
+Top Months in This Year
+
+19,000(Jan 2024)
+======================
+Top Months in Lifetime
+
+19,000(Jan 2024)
+======================
+Top Years in Lifetime
+
+19,000(2024)
+`)
+}
+
+func TestRenderRunningTopPeriods(t *testing.T) {
+	today := time.Date(2024, time.January, 20, 0, 0, 0, 0, time.Local)
+	runningLog := map[time.Time]float64{
+		time.Date(2024, time.January, 8, 7, 0, 0, 0, time.Local):  5,
+		time.Date(2024, time.January, 8, 19, 0, 0, 0, time.Local): 3,
+		time.Date(2024, time.January, 16, 7, 0, 0, 0, time.Local): 10,
+		time.Date(2023, time.March, 1, 7, 0, 0, 0, time.Local):    12.5,
+	}
+	settings := UserSettings{TopPeriods: []string{PERIOD_WEEK}}
+
+	data := buildRunningReportData(runningLog, today, lastSevenDays(today))
+	assert.NoError(t, applyRunningTopRecords(&data, runningLog, settings))
+
+	actual, err := renderRunningReport(data, settings)
+	assert.NoError(t, err)
+	assert.Contains(t, actual, `
+======================
+Top Running Weeks in This Year
+10km(1/15-1/21)
+8km(1/8-1/14)
+======================
+Top Running Weeks in Lifetime
+12.5km(2023/2/27-2023/3/5)
+10km(2024/1/15-2024/1/21)
+8km(2024/1/8-2024/1/14)`)
+}
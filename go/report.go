@@ -0,0 +1,187 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+const (
+	TIME_SERIES_TEMPLATE_NAME = "timeseries.tmpl"
+	RUNNING_TEMPLATE_NAME     = "running.tmpl"
+	TODAY_TEMPLATE_NAME       = "today.tmpl"
+	MONTHLY_TEMPLATE_NAME     = "monthly.tmpl"
+)
+
+func reportFuncMap(locale Locale) template.FuncMap {
+	return template.FuncMap{
+		"separator": func() string { return SEPARATOR },
+		"msg":       locale.message,
+		"comma": func(number interface{}) (string, error) {
+			return formatAnyNumber(locale, number)
+		},
+		"round":        locale.formatFloat,
+		"date":         locale.formatDate,
+		"lifetimeDate": locale.formatLifetimeDate,
+		"month":        locale.formatMonth,
+		"weekday":      locale.weekday,
+	}
+}
+
+// loadReportTemplate parses the built-in template and, when present, the user's
+// template on top of it. The user's template may redefine the body as well as
+// the named sections of the built-in one.
+func loadReportTemplate(settings UserSettings, funcs template.FuncMap, names ...string) (*template.Template, error) {
+	locale, err := lookupLocale(settings.Locale)
+	if err != nil {
+		return nil, err
+	}
+
+	defaultName := names[len(names)-1]
+	defaultText, err := defaultTemplates.ReadFile("templates/" + defaultName)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New(defaultName).Funcs(reportFuncMap(locale)).Funcs(funcs).Parse(string(defaultText))
+	if err != nil {
+		return nil, err
+	}
+
+	if settings.TemplateDir == "" {
+		return tmpl, nil
+	}
+
+	for _, name := range names {
+		userText, err := os.ReadFile(filepath.Join(settings.TemplateDir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return tmpl.Parse(string(userText))
+	}
+
+	return tmpl, nil
+}
+
+func loadTimeSeriesTemplate(data *TimeSeriesReportData, settings UserSettings) (*template.Template, error) {
+	locale, err := lookupLocale(settings.Locale)
+	if err != nil {
+		return nil, err
+	}
+
+	data.HeadingSuffix = data.Resource.headingSuffix(locale)
+	funcs := template.FuncMap{
+		"value": func(value float64) string {
+			return data.Resource.formatValue(locale, value)
+		},
+	}
+
+	// a template named after the resource, e.g. floors.tmpl, takes precedence
+	return loadReportTemplate(settings, funcs, data.Resource.Name+".tmpl", TIME_SERIES_TEMPLATE_NAME)
+}
+
+func renderTimeSeriesReport(data TimeSeriesReportData, settings UserSettings) (string, error) {
+	tmpl, err := loadTimeSeriesTemplate(&data, settings)
+	if err != nil {
+		return "", err
+	}
+
+	return executeReportTemplate(tmpl, data)
+}
+
+// renderTimeSeriesSection renders a single section of the report, e.g. "weekly".
+func renderTimeSeriesSection(data TimeSeriesReportData, settings UserSettings, section string) (string, error) {
+	tmpl, err := loadTimeSeriesTemplate(&data, settings)
+	if err != nil {
+		return "", err
+	}
+
+	return executeReportSection(tmpl, section, data)
+}
+
+func renderRunningReport(data RunningReportData, settings UserSettings) (string, error) {
+	tmpl, err := loadReportTemplate(settings, nil, RUNNING_TEMPLATE_NAME)
+	if err != nil {
+		return "", err
+	}
+
+	return executeReportTemplate(tmpl, data)
+}
+
+func renderRunningSection(data RunningReportData, settings UserSettings, section string) (string, error) {
+	tmpl, err := loadReportTemplate(settings, nil, RUNNING_TEMPLATE_NAME)
+	if err != nil {
+		return "", err
+	}
+
+	return executeReportSection(tmpl, section, data)
+}
+
+// TodayReportData is the data model of today.tmpl.
+type TodayReportData struct {
+	Date      time.Time
+	Steps     int
+	Goal      int
+	Remaining int
+}
+
+func buildTodayReportData(steps float64, today time.Time, goal int) TodayReportData {
+	data := TodayReportData{
+		Date:  today,
+		Steps: int(steps),
+		Goal:  goal,
+	}
+	if data.Steps < goal {
+		data.Remaining = goal - data.Steps
+	}
+	return data
+}
+
+func renderTodayReport(data TodayReportData, settings UserSettings) (string, error) {
+	tmpl, err := loadReportTemplate(settings, nil, TODAY_TEMPLATE_NAME)
+	if err != nil {
+		return "", err
+	}
+
+	return executeReportTemplate(tmpl, data)
+}
+
+func executeReportTemplate(tmpl *template.Template, data interface{}) (string, error) {
+	var builder strings.Builder
+	if err := tmpl.Execute(&builder, data); err != nil {
+		return "", err
+	}
+	return builder.String(), nil
+}
+
+// executeReportSection renders a named section without the blank lines around it.
+func executeReportSection(tmpl *template.Template, section string, data interface{}) (string, error) {
+	var builder strings.Builder
+	if err := tmpl.ExecuteTemplate(&builder, section, data); err != nil {
+		return "", err
+	}
+	return strings.Trim(builder.String(), "\n"), nil
+}
+
+func formatAnyNumber(locale Locale, number interface{}) (string, error) {
+	switch n := number.(type) {
+	case int:
+		return locale.formatInt(n), nil
+	case int64:
+		return locale.formatInt(int(n)), nil
+	case float64:
+		return locale.formatInt(int(n)), nil
+	default:
+		return "", errors.New(UnsupportedNumberType)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderTimeSeriesReportWithUserTemplate(t *testing.T) {
+	templateDir := t.TempDir()
+
+	// reorder sections, change wording and drop the yearly section
+	userTemplate := `{{define "weekly"}}This week: {{value .WeeklyTotal}} steps ({{comma 12345}})
+{{end}}{{template "lifetimeTop" .}}{{separator}}{{template "weekly" .}}`
+	err := os.WriteFile(filepath.Join(templateDir, TIME_SERIES_TEMPLATE_NAME), []byte(userTemplate), 0644)
+	assert.NoError(t, err)
+
+	data := TimeSeriesReportData{
+		Resource:    stepsResource,
+		WeeklyTotal: 70000,
+		LifetimeTop: []DailyValue{
+			{time.Date(2023, time.December, 31, 0, 0, 0, 0, time.Local), 23456},
+		},
+	}
+
+	expected := `Top Records in Lifetime
+
+23,456(2023/12/31)
+======================
+This week: 70,000 steps (12,345)
+`
+
+	actual, err := renderTimeSeriesReport(data, UserSettings{TemplateDir: templateDir})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	// a template named after the resource takes precedence
+	err = os.WriteFile(filepath.Join(templateDir, "steps.tmpl"), []byte(`{{round 1.23456}}`), 0644)
+	assert.NoError(t, err)
+
+	actual, err = renderTimeSeriesReport(data, UserSettings{TemplateDir: templateDir})
+	assert.NoError(t, err)
+	assert.Equal(t, "1.23", actual)
+}
+
+func TestRenderRunningReportWithMissingUserTemplate(t *testing.T) {
+	data := RunningReportData{WeeklyDistance: 1.234, YearlyDistance: 5}
+
+	expected := `
+======================
+Running Report
+
+Weekly Distance: 1.23km
+Yearly Distance: 5km`
+
+	// falls back to the built-in template
+	actual, err := renderRunningReport(data, UserSettings{TemplateDir: t.TempDir()})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestFormatAnyNumber(t *testing.T) {
+	actual, err := formatAnyNumber(locales["en"], 1234.9)
+	assert.NoError(t, err)
+	assert.Equal(t, "1,234", actual)
+
+	_, err = formatAnyNumber(locales["en"], "1234")
+	assert.EqualError(t, err, UnsupportedNumberType)
+}
@@ -13,7 +13,7 @@ import (
 
 func getActivityList(ctx context.Context, access_token string, today time.Time) ([]interface{}, error) {
 	apiUrl := "https://api.fitbit.com/1/user/-/activities/list.json"
-	client := &http.Client{}
+	client := fitbitHTTPClient
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiUrl, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Fitbit API request: %v", err)
@@ -51,6 +51,10 @@ func getActivityList(ctx context.Context, access_token string, today time.Time)
 		}
 
 		activityList = append(activityList, activities...)
+		// no activity was logged before the date
+		if len(activities) == 0 {
+			break
+		}
 
 		// get statTime for last element
 		t, ok := activities[len(activities)-1].(map[string]interface{})["startTime"].(string)
@@ -105,7 +109,26 @@ func extractRunningLog(activitiesList []interface{}, today time.Time) (map[time.
 	return yearlyRunningLog, nil
 }
 
-func generateRunningReport(yearlyRunningLog map[time.Time]float64, today time.Time) string {
+// RunningReportData is the data model the running report templates are rendered from.
+type RunningReportData struct {
+	Today time.Time
+	// the runs of Weekly started in the window
+	Window         ReportWindow
+	Weekly         []RunningLog
+	WeeklyDistance float64
+	// distance of the window before Window
+	PreviousWeeklyDistance float64
+	YearlyDistance         float64
+	// set by applyRunningTopRecords
+	TopPeriods []PeriodRecords
+}
+
+type RunningLog struct {
+	StartTime time.Time
+	Distance  float64
+}
+
+func buildRunningReportData(yearlyRunningLog map[time.Time]float64, today time.Time, window ReportWindow) RunningReportData {
 	var keys []time.Time
 	for key := range yearlyRunningLog {
 		keys = append(keys, key)
@@ -114,23 +137,26 @@ func generateRunningReport(yearlyRunningLog map[time.Time]float64, today time.Ti
 		return keys[i].Before(keys[j])
 	})
 
-	yearlyDistance := 0.0
-	weeklyDistance := 0.0
-	weekStartDate := today.AddDate(0, 0, -7).Add(-time.Nanosecond)
-	weekEndDate := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
-	report := "\n" + SEPARATOR + "Running Report\n"
+	data := RunningReportData{Today: today, Window: window}
+	previousWindow := window.Previous()
 
 	for _, k := range keys {
-		if k.After(weekStartDate) && k.Before(weekEndDate) {
-			report += k.Format(YEARLY_REPORT_DATE_FORMAT) + " " + k.Format(DAY_OF_WEEK_FORMAT) + " " + strconv.FormatFloat(roundToDecimal(yearlyRunningLog[k]), 'f', -1, 64) + "km\n"
-			weeklyDistance += yearlyRunningLog[k]
+		if window.Contains(k) {
+			data.Weekly = append(data.Weekly, RunningLog{k, yearlyRunningLog[k]})
+			data.WeeklyDistance += yearlyRunningLog[k]
+		} else if previousWindow.Contains(k) {
+			data.PreviousWeeklyDistance += yearlyRunningLog[k]
 		}
-		yearlyDistance += yearlyRunningLog[k]
+		data.YearlyDistance += yearlyRunningLog[k]
 	}
 
-	report += "\n"
-	report += "Weekly Distance: " + strconv.FormatFloat(roundToDecimal(weeklyDistance), 'f', -1, 64) + "km\n"
-	report += "Yearly Distance: " + strconv.FormatFloat(roundToDecimal(yearlyDistance), 'f', -1, 64) + "km"
+	return data
+}
 
-	return report
+func generateRunningReport(yearlyRunningLog map[time.Time]float64, today time.Time, settings UserSettings) (string, error) {
+	window, err := settings.reportWindow(today)
+	if err != nil {
+		return "", err
+	}
+	return renderRunningReport(buildRunningReportData(yearlyRunningLog, today, window), settings)
 }
@@ -112,7 +112,10 @@ Running Report
 Weekly Distance: 8.16km
 Yearly Distance: 18.24km`
 
-	actual := generateRunningReport(yearlyRunningLog, today)
+	actual, err := generateRunningReport(yearlyRunningLog, today, UserSettings{})
+	if err != nil {
+		t.Errorf("Error in generateRunningReport: %v", err)
+	}
 	if expected != actual {
 		t.Errorf("Expected %v, but got %v", expected, actual)
 	}
@@ -128,7 +131,10 @@ Running Report
 Weekly Distance: 0km
 Yearly Distance: 10.08km`
 
-	actual = generateRunningReport(yearlyRunningLog, today)
+	actual, err = generateRunningReport(yearlyRunningLog, today, UserSettings{})
+	if err != nil {
+		t.Errorf("Error in generateRunningReport: %v", err)
+	}
 	if expected != actual {
 		t.Errorf("Expected %v, but got %v", expected, actual)
 	}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	// the container image has no zoneinfo
+	_ "time/tzdata"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	DEFAULT_SERVE_ADDR = ":8080"
+	// running reports are cancelled when they have not finished by then
+	SERVE_SHUTDOWN_TIMEOUT = 30 * time.Second
+
+	HEALTH_PATH        = "/healthz"
+	READY_PATH         = "/readyz"
+	SERVE_WEBHOOK_PATH = "/webhook"
+)
+
+// defaultSchedules are the cron specs of the modes, in the timezone of the user.
+var defaultSchedules = map[string]string{
+	MODE_WEEKLY:  "0 9 * * 1",
+	MODE_MONTHLY: "0 9 1 * *",
+	MODE_DAILY:   "0 20 * * *",
+}
+
+// ScheduledRun is a notifier run for a user on a cron schedule.
+type ScheduledRun struct {
+	UserID   string
+	Mode     string
+	Spec     string
+	Location *time.Location
+}
+
+// buildSchedule lists the runs of the users: defaultSchedules overridden by their settings.
+func buildSchedule(userIDs []string, loadSettings func(userID string) (UserSettings, error)) ([]ScheduledRun, error) {
+	var runs []ScheduledRun
+	for _, userID := range userIDs {
+		userID = strings.TrimSpace(userID)
+		if userID == "" {
+			continue
+		}
+
+		settings, err := loadSettings(userID)
+		if err != nil {
+			return nil, err
+		}
+		location, err := settings.location()
+		if err != nil {
+			return nil, err
+		}
+
+		specs := map[string]string{}
+		for mode, spec := range defaultSchedules {
+			specs[mode] = spec
+		}
+		for mode, spec := range settings.Schedules {
+			specs[mode] = spec
+		}
+
+		var modes []string
+		for mode := range specs {
+			modes = append(modes, mode)
+		}
+		sort.Strings(modes)
+
+		for _, mode := range modes {
+			if specs[mode] == "" {
+				continue
+			}
+			runs = append(runs, ScheduledRun{
+				UserID:   userID,
+				Mode:     mode,
+				Spec:     specs[mode],
+				Location: location,
+			})
+		}
+	}
+	return runs, nil
+}
+
+// wallClockInLocal returns the wall clock of t in the local zone. The dates of the Fitbit data
+// are keyed in the local zone, so a run in the timezone of a user reports its own date.
+func wallClockInLocal(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+}
+
+// newReportCron schedules the runs. A run is skipped while the previous run of the same
+// user and mode is still going, and errors are logged without stopping the daemon.
+func newReportCron(ctx context.Context, runs []ScheduledRun, run func(ctx context.Context, mode string, userID string, now time.Time) error) (*cron.Cron, error) {
+	logger := cron.PrintfLogger(log.Default())
+	c := cron.New(cron.WithChain(cron.Recover(logger)))
+
+	for _, scheduled := range runs {
+		scheduled := scheduled
+		schedule, err := cron.ParseStandard("CRON_TZ=" + scheduled.Location.String() + " " + scheduled.Spec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the %s schedule of %s: %v", scheduled.Mode, scheduled.UserID, err)
+		}
+
+		job := cron.FuncJob(func() {
+			now := wallClockInLocal(time.Now().In(scheduled.Location))
+			if err := run(ctx, scheduled.Mode, scheduled.UserID, now); err != nil {
+				log.Printf("failed to run the %s notifier for %s: %v", scheduled.Mode, scheduled.UserID, err)
+			}
+		})
+		c.Schedule(schedule, cron.NewChain(cron.SkipIfStillRunning(logger)).Then(job))
+	}
+	return c, nil
+}
+
+// healthHandler answers the liveness and readiness probes. The daemon is ready while the
+// scheduler is running.
+type healthHandler struct {
+	ready atomic.Bool
+}
+
+func (health *healthHandler) register(mux *http.ServeMux) {
+	mux.HandleFunc(HEALTH_PATH, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	mux.HandleFunc(READY_PATH, func(w http.ResponseWriter, r *http.Request) {
+		if !health.ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok")
+	})
+}
+
+// runServe runs the notifiers on their schedules as a long-running process, e.g. in a container
+// on a home server, with the health endpoints and optionally the webhook and the dashboard.
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", DEFAULT_SERVE_ADDR, "address to listen on")
+	webhook := flags.Bool("webhook", false, "serve the LINE webhook at "+SERVE_WEBHOOK_PATH)
+	dashboard := flags.Bool("dashboard", false, "serve the dashboard of the history store at "+DASHBOARD_PATH)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	instances, err := newInstances(ctx)
+	if err != nil {
+		return err
+	}
+	lineUserId, err := instances.getParameter(os.Getenv("LINE_USER_ID_PARAMETER_NAME"))
+	if err != nil {
+		return err
+	}
+
+	runs, err := buildSchedule(parseUserIDs(*lineUserId), loadUserSettings)
+	if err != nil {
+		return err
+	}
+
+	// the runs, e.g. of the users at the same time, and the webhook share the access token, as
+	// the refresh token can be used only once
+	tokens := &accessTokenCache{fetch: instances.getAccessToken}
+
+	// running reports are not cancelled by the signal, but by the shutdown timeout
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+	scheduler, err := newReportCron(jobCtx, runs, func(ctx context.Context, mode string, userID string, now time.Time) error {
+		return runMode(ctx, instances, mode, userID, now, tokens.get)
+	})
+	if err != nil {
+		return err
+	}
+
+	health := &healthHandler{}
+	mux := http.NewServeMux()
+	health.register(mux)
+	if err := mountBlobStore(mux); err != nil {
+		return err
+	}
+	if *webhook {
+		server, err := newWebhookServer(ctx, tokens)
+		if err != nil {
+			return err
+		}
+		mux.Handle(SERVE_WEBHOOK_PATH, server)
+	}
+
+	if *dashboard {
+		history, err := newHistoryStore(ctx)
+		if err != nil {
+			return err
+		}
+		if history == nil {
+			return errors.New(MissingHistoryStore)
+		}
+		dashboardSecret, err := instances.getParameter(os.Getenv("DASHBOARD_SECRET_PARAMETER_NAME"))
+		if err != nil {
+			return err
+		}
+		mux.Handle(DASHBOARD_PATH, newDashboardServer(history, *dashboardSecret, parseUserIDs(*lineUserId)))
+	}
+
+	httpServer := &http.Server{Addr: *addr, Handler: mux}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	scheduler.Start()
+	health.ready.Store(true)
+	log.Printf("serving on %s with %d scheduled runs", *addr, len(runs))
+
+	select {
+	case <-ctx.Done():
+	case err := <-serveErr:
+		scheduler.Stop()
+		return err
+	}
+
+	log.Printf("shutting down")
+	health.ready.Store(false)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SERVE_SHUTDOWN_TIMEOUT)
+	defer cancel()
+
+	select {
+	case <-scheduler.Stop().Done():
+	case <-shutdownCtx.Done():
+		cancelJobs()
+	}
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildSchedule(t *testing.T) {
+	settings := map[string]UserSettings{
+		"U1": {},
+		"U2": {Timezone: "America/New_York", Schedules: map[string]string{MODE_DAILY: "", MODE_WEEKLY: "30 8 * * 0"}},
+	}
+	loadSettings := func(userID string) (UserSettings, error) {
+		return settings[userID], nil
+	}
+
+	runs, err := buildSchedule([]string{"U1", " U2", ""}, loadSettings)
+	assert.NoError(t, err)
+
+	newYork, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+	assert.Equal(t, []ScheduledRun{
+		{UserID: "U1", Mode: MODE_DAILY, Spec: defaultSchedules[MODE_DAILY], Location: time.Local},
+		{UserID: "U1", Mode: MODE_MONTHLY, Spec: defaultSchedules[MODE_MONTHLY], Location: time.Local},
+		{UserID: "U1", Mode: MODE_WEEKLY, Spec: defaultSchedules[MODE_WEEKLY], Location: time.Local},
+		{UserID: "U2", Mode: MODE_MONTHLY, Spec: defaultSchedules[MODE_MONTHLY], Location: newYork},
+		{UserID: "U2", Mode: MODE_WEEKLY, Spec: "30 8 * * 0", Location: newYork},
+	}, runs)
+
+	settings["U3"] = UserSettings{Timezone: "Mars/Olympus"}
+	_, err = buildSchedule([]string{"U3"}, loadSettings)
+	assert.Error(t, err)
+}
+
+func TestNewReportCron(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	assert.NoError(t, err)
+
+	type call struct {
+		mode, userID string
+		now          time.Time
+	}
+	var calls []call
+	runs := []ScheduledRun{{UserID: "U1", Mode: MODE_WEEKLY, Spec: "0 9 * * 1", Location: tokyo}}
+	c, err := newReportCron(context.Background(), runs, func(ctx context.Context, mode string, userID string, now time.Time) error {
+		calls = append(calls, call{mode, userID, now})
+		return nil
+	})
+	assert.NoError(t, err)
+
+	entries := c.Entries()
+	assert.Len(t, entries, 1)
+	// 9:00 on Monday in Tokyo
+	next := entries[0].Schedule.Next(time.Date(2023, time.December, 31, 0, 0, 0, 0, time.UTC))
+	assert.True(t, time.Date(2024, time.January, 1, 9, 0, 0, 0, tokyo).Equal(next))
+
+	entries[0].WrappedJob.Run()
+	assert.Len(t, calls, 1)
+	assert.Equal(t, MODE_WEEKLY, calls[0].mode)
+	assert.Equal(t, "U1", calls[0].userID)
+	assert.Equal(t, time.Local, calls[0].now.Location())
+
+	_, err = newReportCron(context.Background(), []ScheduledRun{{UserID: "U1", Mode: MODE_DAILY, Spec: "every day", Location: tokyo}}, func(ctx context.Context, mode string, userID string, now time.Time) error {
+		return nil
+	})
+	assert.Error(t, err)
+}
+
+func TestScheduledRunsShareAccessToken(t *testing.T) {
+	var mu sync.Mutex
+	refreshes := 0
+	tokens := &accessTokenCache{fetch: func(ctx context.Context) (*string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		refreshes += 1
+		token := "token"
+		return &token, nil
+	}}
+
+	// the weekly and the monthly report of two users at 9:00 on Monday the 1st
+	var runs []ScheduledRun
+	for _, userID := range []string{"U1", "U2"} {
+		for _, mode := range []string{MODE_WEEKLY, MODE_MONTHLY} {
+			runs = append(runs, ScheduledRun{UserID: userID, Mode: mode, Spec: defaultSchedules[mode], Location: time.UTC})
+		}
+	}
+	c, err := newReportCron(context.Background(), runs, func(ctx context.Context, mode string, userID string, now time.Time) error {
+		_, err := tokens.get(ctx)
+		return err
+	})
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for _, entry := range c.Entries() {
+		wg.Add(1)
+		go func(job cron.Job) {
+			defer wg.Done()
+			job.Run()
+		}(entry.WrappedJob)
+	}
+	wg.Wait()
+	assert.Equal(t, 1, refreshes)
+}
+
+func TestParseUserIDs(t *testing.T) {
+	assert.Equal(t, []string{"U1", "U2"}, parseUserIDs("U1, U2,"))
+	assert.Equal(t, []string{"U1"}, parseUserIDs("U1"))
+	assert.Empty(t, parseUserIDs(""))
+}
+
+func TestWallClockInLocal(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	assert.NoError(t, err)
+
+	actual := wallClockInLocal(time.Date(2024, time.January, 1, 9, 30, 0, 0, tokyo))
+	assert.Equal(t, time.Date(2024, time.January, 1, 9, 30, 0, 0, time.Local), actual)
+}
+
+func TestHealthHandler(t *testing.T) {
+	health := &healthHandler{}
+	mux := http.NewServeMux()
+	health.register(mux)
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, get(HEALTH_PATH))
+	assert.Equal(t, http.StatusServiceUnavailable, get(READY_PATH))
+
+	health.ready.Store(true)
+	assert.Equal(t, http.StatusOK, get(READY_PATH))
+}
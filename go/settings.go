@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const QUIET_HOURS_FORMAT = "15:04"
+
+// UserSettings holds per-user preferences for report generation.
+type UserSettings struct {
+	// directory containing templates overriding the built-in ones, e.g. running.tmpl
+	TemplateDir string `json:"templateDir,omitempty"`
+	// language of the reports, e.g. "ja". English is used when empty.
+	Locale string `json:"locale,omitempty"`
+	// "text" (default) or "flex"
+	MessageFormat string `json:"messageFormat,omitempty"`
+	// attach chart images to the reports
+	Charts bool `json:"charts,omitempty"`
+	// add the emoji heatmap of this year to the yearly steps report
+	Heatmap bool `json:"heatmap,omitempty"`
+	// send the insights of the steps history after the weekly reports
+	Insights bool `json:"insights,omitempty"`
+	// number of records in the top records sections, DEFAULT_TOP_N when zero
+	TopN int `json:"topN,omitempty"`
+	// periods ranked by total after the top days: "rollingWeek", "week", "month" or "year"
+	TopPeriods []string `json:"topPeriods,omitempty"`
+	// days of the weekly reports: "last7Days" (default), "isoWeek", "sundayWeek" or "2006-01-02/2006-01-02"
+	ReportWindow string `json:"reportWindow,omitempty"`
+	// a tracker which has not synced for this long is warned about, DEFAULT_SYNC_WARNING_HOURS when zero
+	SyncWarningHours int `json:"syncWarningHours,omitempty"`
+	// battery percent warned about, DEFAULT_LOW_BATTERY_LEVEL when zero
+	LowBatteryLevel int `json:"lowBatteryLevel,omitempty"`
+	// more steps than this in a day are implausible, DEFAULT_MAX_DAILY_STEPS when zero
+	MaxDailySteps int `json:"maxDailySteps,omitempty"`
+	// send a summary of the data quality flags after the weekly reports
+	QualityFooter bool `json:"qualityFooter,omitempty"`
+	// drop the implausible days and the flagged runs before reporting and ranking
+	ExcludeFlaggedData bool `json:"excludeFlaggedData,omitempty"`
+	// daily steps goal, DEFAULT_STEPS_GOAL when zero
+	StepsGoal int `json:"stepsGoal,omitempty"`
+	// alerts are not sent in this range of the local time, e.g. "22:00-07:00"
+	QuietHours string `json:"quietHours,omitempty"`
+	// maximum number of alerts in 24 hours, DEFAULT_MAX_ALERTS_PER_DAY when zero
+	MaxAlertsPerDay int `json:"maxAlertsPerDay,omitempty"`
+	// IANA time zone the schedules of the serve command are in, e.g. "Asia/Tokyo". The local zone when empty.
+	Timezone string `json:"timezone,omitempty"`
+	// cron specs by mode ("weekly", "monthly" or "daily") overriding defaultSchedules, "" to disable a mode
+	Schedules map[string]string `json:"schedules,omitempty"`
+}
+
+func (settings UserSettings) stepsGoal() int {
+	if settings.StepsGoal <= 0 {
+		return DEFAULT_STEPS_GOAL
+	}
+	return settings.StepsGoal
+}
+
+func (settings UserSettings) maxAlertsPerDay() int {
+	if settings.MaxAlertsPerDay <= 0 {
+		return DEFAULT_MAX_ALERTS_PER_DAY
+	}
+	return settings.MaxAlertsPerDay
+}
+
+func (settings UserSettings) reportWindow(today time.Time) (ReportWindow, error) {
+	return newReportWindow(settings.ReportWindow, today)
+}
+
+func (settings UserSettings) location() (*time.Location, error) {
+	if settings.Timezone == "" {
+		return time.Local, nil
+	}
+	location, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load timezone: %v", err)
+	}
+	return location, nil
+}
+
+// inQuietHours reports whether t is in the quiet hours. The range may span midnight.
+func (settings UserSettings) inQuietHours(t time.Time) (bool, error) {
+	if settings.QuietHours == "" {
+		return false, nil
+	}
+
+	start, end, ok := strings.Cut(settings.QuietHours, "-")
+	if !ok {
+		return false, errors.New(InvalidQuietHours)
+	}
+	startTime, err := time.Parse(QUIET_HOURS_FORMAT, strings.TrimSpace(start))
+	if err != nil {
+		return false, errors.New(InvalidQuietHours)
+	}
+	endTime, err := time.Parse(QUIET_HOURS_FORMAT, strings.TrimSpace(end))
+	if err != nil {
+		return false, errors.New(InvalidQuietHours)
+	}
+
+	minutes := t.Hour()*60 + t.Minute()
+	startMinutes := startTime.Hour()*60 + startTime.Minute()
+	endMinutes := endTime.Hour()*60 + endTime.Minute()
+	if startMinutes <= endMinutes {
+		return startMinutes <= minutes && minutes < endMinutes, nil
+	}
+	return minutes >= startMinutes || minutes < endMinutes, nil
+}
+
+// settingsDocument is the JSON layout of USER_SETTINGS.
+// Settings under "users" are applied on top of "default" for the matching LINE user ID.
+type settingsDocument struct {
+	Default json.RawMessage            `json:"default"`
+	Users   map[string]json.RawMessage `json:"users"`
+}
+
+func loadUserSettings(userID string) (UserSettings, error) {
+	return parseUserSettings(os.Getenv("USER_SETTINGS"), userID)
+}
+
+func parseUserSettings(document string, userID string) (UserSettings, error) {
+	settings := UserSettings{}
+	if document == "" {
+		return settings, nil
+	}
+
+	var doc settingsDocument
+	if err := json.Unmarshal([]byte(document), &doc); err != nil {
+		return settings, fmt.Errorf("failed to decode user settings: %v", err)
+	}
+
+	if len(doc.Default) > 0 {
+		if err := json.Unmarshal(doc.Default, &settings); err != nil {
+			return settings, fmt.Errorf("failed to decode default user settings: %v", err)
+		}
+	}
+
+	if userSettings, ok := doc.Users[userID]; ok {
+		if err := json.Unmarshal(userSettings, &settings); err != nil {
+			return settings, fmt.Errorf("failed to decode user settings for %s: %v", userID, err)
+		}
+	}
+
+	return settings, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUserSettings(t *testing.T) {
+	document := `{"default": {"templateDir": "/templates/default"}, "users": {"U1": {"templateDir": "/templates/u1"}}}`
+
+	settings, err := parseUserSettings(document, "U1")
+	assert.NoError(t, err)
+	assert.Equal(t, "/templates/u1", settings.TemplateDir)
+
+	settings, err = parseUserSettings(document, "U2")
+	assert.NoError(t, err)
+	assert.Equal(t, "/templates/default", settings.TemplateDir)
+
+	settings, err = parseUserSettings("", "U1")
+	assert.NoError(t, err)
+	assert.Equal(t, UserSettings{}, settings)
+
+	_, err = parseUserSettings("{", "U1")
+	assert.Error(t, err)
+}
+
+func TestInQuietHours(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, time.March, 3, hour, minute, 0, 0, time.Local)
+	}
+
+	settings := UserSettings{QuietHours: "22:00-07:00"}
+	for _, tc := range []struct {
+		t        time.Time
+		expected bool
+	}{
+		{at(21, 59), false},
+		{at(22, 0), true},
+		{at(3, 0), true},
+		{at(7, 0), false},
+	} {
+		quiet, err := settings.inQuietHours(tc.t)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, quiet, tc.t)
+	}
+
+	quiet, err := UserSettings{QuietHours: "12:00-13:00"}.inQuietHours(at(12, 30))
+	assert.NoError(t, err)
+	assert.True(t, quiet)
+
+	quiet, err = UserSettings{}.inQuietHours(at(3, 0))
+	assert.NoError(t, err)
+	assert.False(t, quiet)
+
+	_, err = UserSettings{QuietHours: "22"}.inQuietHours(at(3, 0))
+	assert.EqualError(t, err, InvalidQuietHours)
+}
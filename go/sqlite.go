@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	HISTORY_STORE_SQLITE = "sqlite"
+	TOKEN_STORE_SQLITE   = "sqlite"
+
+	// activity, sleep and body times are stored in RFC 3339 with the offset they were logged in
+	SQLITE_TIME_FORMAT = time.RFC3339Nano
+)
+
+// sqliteMigrations are applied in order. Append a migration to change the schema, never edit one
+// which has been released.
+var sqliteMigrations = []string{
+	`CREATE TABLE daily_metrics (
+		resource TEXT NOT NULL,
+		date TEXT NOT NULL,
+		value REAL NOT NULL,
+		PRIMARY KEY (resource, date)
+	);
+	CREATE TABLE activities (
+		log_id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		start_time TEXT NOT NULL,
+		start_unix INTEGER NOT NULL,
+		duration_ns INTEGER NOT NULL,
+		distance REAL NOT NULL,
+		calories REAL NOT NULL,
+		steps INTEGER NOT NULL,
+		source TEXT NOT NULL
+	);
+	CREATE INDEX activities_start_unix ON activities (start_unix);
+	CREATE TABLE sleep (
+		log_id INTEGER PRIMARY KEY,
+		date_of_sleep TEXT NOT NULL,
+		start_time TEXT NOT NULL,
+		start_unix INTEGER NOT NULL,
+		end_time TEXT NOT NULL,
+		minutes_asleep INTEGER NOT NULL,
+		efficiency INTEGER NOT NULL
+	);
+	CREATE INDEX sleep_start_unix ON sleep (start_unix);
+	CREATE TABLE body (
+		log_id INTEGER PRIMARY KEY,
+		time TEXT NOT NULL,
+		time_unix INTEGER NOT NULL,
+		weight REAL NOT NULL,
+		bmi REAL NOT NULL,
+		fat REAL NOT NULL
+	);
+	CREATE INDEX body_time_unix ON body (time_unix);`,
+
+	`CREATE TABLE tokens (
+		name TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at TEXT NOT NULL
+	);`,
+}
+
+// timeSeriesReportQuerier is implemented by the history stores which aggregate the reports
+// themselves instead of returning the whole history.
+type timeSeriesReportQuerier interface {
+	queryTimeSeriesReportData(ctx context.Context, resource TimeSeriesResource, today time.Time, window ReportWindow) (TimeSeriesReportData, error)
+}
+
+// SQLiteHistoryStore keeps the history in a SQLite database. It also stores tokens, and
+// aggregates the time series reports with SQL.
+type SQLiteHistoryStore struct {
+	DB *sql.DB
+}
+
+var (
+	sqliteStoresMu sync.Mutex
+	// the stores opened by the process by path, shared by the runs and by the token store
+	sqliteStores = map[string]*SQLiteHistoryStore{}
+)
+
+// sharedSQLiteHistoryStore returns the store of path opened by the process, and opens it on the
+// first call. The handle is kept open for the next runs of a long-running process.
+func sharedSQLiteHistoryStore(ctx context.Context, path string) (*SQLiteHistoryStore, error) {
+	sqliteStoresMu.Lock()
+	defer sqliteStoresMu.Unlock()
+
+	if store, ok := sqliteStores[path]; ok {
+		return store, nil
+	}
+	store, err := openSQLiteHistoryStore(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	sqliteStores[path] = store
+	return store, nil
+}
+
+// closeSQLiteHistoryStores closes the shared stores when the process is done with them.
+func closeSQLiteHistoryStores() error {
+	sqliteStoresMu.Lock()
+	defer sqliteStoresMu.Unlock()
+
+	var errs []error
+	for path, store := range sqliteStores {
+		errs = append(errs, store.Close())
+		delete(sqliteStores, path)
+	}
+	return errors.Join(errs...)
+}
+
+// openSQLiteHistoryStore opens the database at path and migrates it to the latest schema.
+func openSQLiteHistoryStore(ctx context.Context, path string) (*SQLiteHistoryStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	// wait for the other processes, e.g. the subscriber while a backfill is running
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+
+	store := &SQLiteHistoryStore{DB: db}
+	if err := store.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *SQLiteHistoryStore) Close() error {
+	return store.DB.Close()
+}
+
+func (store *SQLiteHistoryStore) migrate(ctx context.Context) error {
+	if _, err := store.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return err
+	}
+
+	var version int
+	if err := store.DB.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := store.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to migrate the history database to version %d: %v", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES (?)`, i+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inTx runs f in a transaction so that a batch of records is stored at once.
+func (store *SQLiteHistoryStore) inTx(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := store.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *SQLiteHistoryStore) PutDailyValues(ctx context.Context, resource string, values []DailyValue) error {
+	return store.inTx(ctx, func(tx *sql.Tx) error {
+		for _, dailyValue := range values {
+			_, err := tx.ExecContext(ctx, `INSERT INTO daily_metrics (resource, date, value) VALUES (?, ?, ?)
+				ON CONFLICT (resource, date) DO UPDATE SET value = excluded.value`,
+				resource, dailyValue.Date.Format(DATE_FORMAT), dailyValue.Value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (store *SQLiteHistoryStore) GetDailyValues(ctx context.Context, resource string) (map[time.Time]float64, error) {
+	return store.queryDailyValues(ctx, `SELECT date, value FROM daily_metrics WHERE resource = ?`, resource)
+}
+
+func (store *SQLiteHistoryStore) queryDailyValues(ctx context.Context, query string, args ...interface{}) (map[time.Time]float64, error) {
+	rows, err := store.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := map[time.Time]float64{}
+	for rows.Next() {
+		var date string
+		var value float64
+		if err := rows.Scan(&date, &value); err != nil {
+			return nil, err
+		}
+		dateTime, err := time.ParseInLocation(DATE_FORMAT, date, time.Local)
+		if err != nil {
+			return nil, err
+		}
+		values[dateTime] = value
+	}
+	return values, rows.Err()
+}
+
+func (store *SQLiteHistoryStore) PutActivities(ctx context.Context, records []ActivityRecord) error {
+	return store.inTx(ctx, func(tx *sql.Tx) error {
+		for _, record := range records {
+			_, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO activities
+				(log_id, name, start_time, start_unix, duration_ns, distance, calories, steps, source)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				record.LogID, record.Name, record.StartTime.Format(SQLITE_TIME_FORMAT), record.StartTime.Unix(),
+				int64(record.Duration), record.Distance, record.Calories, record.Steps, record.Source)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (store *SQLiteHistoryStore) GetActivities(ctx context.Context, from time.Time, to time.Time) ([]ActivityRecord, error) {
+	rows, err := store.DB.QueryContext(ctx, `SELECT log_id, name, start_time, duration_ns, distance, calories, steps, source
+		FROM activities WHERE start_unix >= ? AND start_unix < ? ORDER BY start_unix, log_id`, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []ActivityRecord
+	for rows.Next() {
+		var record ActivityRecord
+		var startTime string
+		var duration int64
+		if err := rows.Scan(&record.LogID, &record.Name, &startTime, &duration, &record.Distance, &record.Calories, &record.Steps, &record.Source); err != nil {
+			return nil, err
+		}
+		if record.StartTime, err = time.Parse(SQLITE_TIME_FORMAT, startTime); err != nil {
+			return nil, err
+		}
+		record.Duration = time.Duration(duration)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (store *SQLiteHistoryStore) PutSleep(ctx context.Context, records []SleepRecord) error {
+	return store.inTx(ctx, func(tx *sql.Tx) error {
+		for _, record := range records {
+			_, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO sleep
+				(log_id, date_of_sleep, start_time, start_unix, end_time, minutes_asleep, efficiency)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				record.LogID, record.DateOfSleep.Format(DATE_FORMAT), record.StartTime.Format(SQLITE_TIME_FORMAT), record.StartTime.Unix(),
+				record.EndTime.Format(SQLITE_TIME_FORMAT), record.MinutesAsleep, record.Efficiency)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (store *SQLiteHistoryStore) GetSleep(ctx context.Context, from time.Time, to time.Time) ([]SleepRecord, error) {
+	rows, err := store.DB.QueryContext(ctx, `SELECT log_id, date_of_sleep, start_time, end_time, minutes_asleep, efficiency
+		FROM sleep WHERE start_unix >= ? AND start_unix < ? ORDER BY start_unix, log_id`, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []SleepRecord
+	for rows.Next() {
+		var record SleepRecord
+		var dateOfSleep, startTime, endTime string
+		if err := rows.Scan(&record.LogID, &dateOfSleep, &startTime, &endTime, &record.MinutesAsleep, &record.Efficiency); err != nil {
+			return nil, err
+		}
+		if record.DateOfSleep, err = time.ParseInLocation(DATE_FORMAT, dateOfSleep, time.Local); err != nil {
+			return nil, err
+		}
+		if record.StartTime, err = time.Parse(SQLITE_TIME_FORMAT, startTime); err != nil {
+			return nil, err
+		}
+		if record.EndTime, err = time.Parse(SQLITE_TIME_FORMAT, endTime); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (store *SQLiteHistoryStore) PutBody(ctx context.Context, records []BodyRecord) error {
+	return store.inTx(ctx, func(tx *sql.Tx) error {
+		for _, record := range records {
+			_, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO body (log_id, time, time_unix, weight, bmi, fat) VALUES (?, ?, ?, ?, ?, ?)`,
+				record.LogID, record.Time.Format(SQLITE_TIME_FORMAT), record.Time.Unix(), record.Weight, record.BMI, record.Fat)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (store *SQLiteHistoryStore) GetBody(ctx context.Context, from time.Time, to time.Time) ([]BodyRecord, error) {
+	rows, err := store.DB.QueryContext(ctx, `SELECT log_id, time, weight, bmi, fat
+		FROM body WHERE time_unix >= ? AND time_unix < ? ORDER BY time_unix, log_id`, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []BodyRecord
+	for rows.Next() {
+		var record BodyRecord
+		var t string
+		if err := rows.Scan(&record.LogID, &t, &record.Weight, &record.BMI, &record.Fat); err != nil {
+			return nil, err
+		}
+		if record.Time, err = time.Parse(SQLITE_TIME_FORMAT, t); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (store *SQLiteHistoryStore) GetToken(ctx context.Context, name string) (string, error) {
+	var value string
+	err := store.DB.QueryRowContext(ctx, `SELECT value FROM tokens WHERE name = ?`, name).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errors.New(MissingToken + ": " + name)
+	}
+	return value, err
+}
+
+func (store *SQLiteHistoryStore) PutToken(ctx context.Context, name string, value string) error {
+	_, err := store.DB.ExecContext(ctx, `INSERT INTO tokens (name, value, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`,
+		name, value, time.Now().Format(SQLITE_TIME_FORMAT))
+	return err
+}
+
+// topDailyValues returns the 5 largest values in [from, to), the older date first on ties
+// as sortDailyValues does.
+func (store *SQLiteHistoryStore) topDailyValues(ctx context.Context, resource string, from string, to string) ([]DailyValue, error) {
+	return store.querySortedDailyValues(ctx, `SELECT date, value FROM daily_metrics
+		WHERE resource = ? AND date >= ? AND date < ? ORDER BY value DESC, date ASC LIMIT 5`, resource, from, to)
+}
+
+// querySortedDailyValues keeps the order of the query, e.g. that of sortDailyValues.
+func (store *SQLiteHistoryStore) querySortedDailyValues(ctx context.Context, query string, args ...interface{}) ([]DailyValue, error) {
+	rows, err := store.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []DailyValue
+	for rows.Next() {
+		var date string
+		var value float64
+		if err := rows.Scan(&date, &value); err != nil {
+			return nil, err
+		}
+		dateTime, err := time.ParseInLocation(DATE_FORMAT, date, time.Local)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, DailyValue{dateTime, value})
+	}
+	return values, rows.Err()
+}
+
+// queryTimeSeriesReportData builds the same data as buildTimeSeriesReportData from the days
+// before today stored in the database.
+func (store *SQLiteHistoryStore) queryTimeSeriesReportData(ctx context.Context, resource TimeSeriesResource, today time.Time, window ReportWindow) (TimeSeriesReportData, error) {
+	data := TimeSeriesReportData{
+		Resource: resource,
+		Today:    today,
+		Window:   window,
+	}
+
+	todayDate := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+
+	values, err := store.queryDailyValues(ctx, `SELECT date, value FROM daily_metrics WHERE resource = ? AND date >= ? AND date < ?`,
+		resource.Name, window.Start.Format(DATE_FORMAT), window.End.Format(DATE_FORMAT))
+	if err != nil {
+		return data, err
+	}
+	for _, date := range window.Days() {
+		value := values[time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.Local)]
+		data.Weekly = append(data.Weekly, DailyValue{date, value})
+		data.WeeklyTotal += value
+	}
+
+	previousWindow := window.Previous()
+	err = store.DB.QueryRowContext(ctx, `SELECT COALESCE(SUM(value), 0) FROM daily_metrics WHERE resource = ? AND date >= ? AND date < ?`,
+		resource.Name, previousWindow.Start.Format(DATE_FORMAT), previousWindow.End.Format(DATE_FORMAT)).Scan(&data.PreviousWeeklyTotal)
+	if err != nil {
+		return data, err
+	}
+
+	data.WeeklyAverage = data.WeeklyTotal / float64(window.Len())
+	if resource.ValueType == IntValue {
+		data.WeeklyAverage = float64(int(math.Round(data.WeeklyAverage*10) / 10))
+	}
+
+	yearStart := time.Date(today.Year(), time.January, 1, 0, 0, 0, 0, today.Location())
+	if data.YearlyTop, err = store.topDailyValues(ctx, resource.Name, yearStart.Format(DATE_FORMAT), todayDate.Format(DATE_FORMAT)); err != nil {
+		return data, err
+	}
+	if data.LifetimeTop, err = store.topDailyValues(ctx, resource.Name, "", todayDate.Format(DATE_FORMAT)); err != nil {
+		return data, err
+	}
+
+	items, err := store.querySortedDailyValues(ctx, `SELECT date, value FROM daily_metrics
+		WHERE resource = ? AND date < ? ORDER BY value DESC, date ASC`, resource.Name, todayDate.Format(DATE_FORMAT))
+	if err != nil {
+		return data, err
+	}
+	lifetimeData := map[time.Time]float64{}
+	for _, item := range items {
+		lifetimeData[item.Date] = item.Value
+	}
+	rankWeek(&data, items, lifetimeData)
+
+	return data, nil
+}
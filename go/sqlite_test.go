@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLiteHistoryStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "history", "history.db")
+	store, err := openSQLiteHistoryStore(ctx, path)
+	assert.NoError(t, err)
+	defer store.DB.Close()
+
+	// migrating again is a no-op
+	reopened, err := openSQLiteHistoryStore(ctx, path)
+	assert.NoError(t, err)
+	var version int
+	assert.NoError(t, reopened.DB.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version))
+	assert.Equal(t, len(sqliteMigrations), version)
+	reopened.DB.Close()
+
+	values, err := store.GetDailyValues(ctx, "steps")
+	assert.NoError(t, err)
+	assert.Empty(t, values)
+
+	day := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.Local)
+	assert.NoError(t, store.PutDailyValues(ctx, "steps", []DailyValue{{day, 1000}, {day.AddDate(0, 0, 1), 2000}}))
+	// replaced by date
+	assert.NoError(t, store.PutDailyValues(ctx, "steps", []DailyValue{{day, 1500}}))
+
+	values, err = store.GetDailyValues(ctx, "steps")
+	assert.NoError(t, err)
+	assert.Equal(t, map[time.Time]float64{day: 1500, day.AddDate(0, 0, 1): 2000}, values)
+
+	run := ActivityRecord{LogID: 2, Name: "Run", StartTime: day.Add(7 * time.Hour), Duration: 30 * time.Minute, Distance: 5}
+	walk := ActivityRecord{LogID: 1, Name: "Walk", StartTime: day.Add(18 * time.Hour)}
+	assert.NoError(t, store.PutActivities(ctx, []ActivityRecord{walk, run}))
+	walk.Steps = 3000
+	assert.NoError(t, store.PutActivities(ctx, []ActivityRecord{walk}))
+
+	activities, err := store.GetActivities(ctx, day, day.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.Len(t, activities, 2)
+	assert.Equal(t, "Run", activities[0].Name)
+	assert.True(t, run.StartTime.Equal(activities[0].StartTime))
+	assert.Equal(t, 30*time.Minute, activities[0].Duration)
+	assert.Equal(t, 3000, activities[1].Steps)
+
+	activities, err = store.GetActivities(ctx, day.AddDate(0, 0, 1), day.AddDate(0, 0, 2))
+	assert.NoError(t, err)
+	assert.Empty(t, activities)
+
+	assert.NoError(t, store.PutSleep(ctx, []SleepRecord{{LogID: 1, DateOfSleep: day, StartTime: day.Add(-2 * time.Hour), EndTime: day.Add(5 * time.Hour), MinutesAsleep: 420}}))
+	sleep, err := store.GetSleep(ctx, day.AddDate(0, 0, -1), day)
+	assert.NoError(t, err)
+	assert.Len(t, sleep, 1)
+	assert.Equal(t, 420, sleep[0].MinutesAsleep)
+	assert.True(t, day.Equal(sleep[0].DateOfSleep))
+
+	assert.NoError(t, store.PutBody(ctx, []BodyRecord{{LogID: 1, Time: day.Add(7 * time.Hour), Weight: 60.5}}))
+	body, err := store.GetBody(ctx, day, day.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.Len(t, body, 1)
+	assert.Equal(t, 60.5, body[0].Weight)
+}
+
+func TestSQLiteTokens(t *testing.T) {
+	ctx := context.Background()
+	store, err := openSQLiteHistoryStore(ctx, filepath.Join(t.TempDir(), "history.db"))
+	assert.NoError(t, err)
+	defer store.DB.Close()
+
+	_, err = store.GetToken(ctx, REFRESH_TOKEN_NAME)
+	assert.Error(t, err)
+
+	assert.NoError(t, store.PutToken(ctx, REFRESH_TOKEN_NAME, "first"))
+	assert.NoError(t, store.PutToken(ctx, REFRESH_TOKEN_NAME, "second"))
+	token, err := store.GetToken(ctx, REFRESH_TOKEN_NAME)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", token)
+}
+
+func TestQueryTimeSeriesReportData(t *testing.T) {
+	ctx := context.Background()
+	store, err := openSQLiteHistoryStore(ctx, filepath.Join(t.TempDir(), "history.db"))
+	assert.NoError(t, err)
+	defer store.DB.Close()
+
+	today := time.Date(2024, time.January, 10, 9, 0, 0, 0, time.Local)
+	lifetimeData := map[time.Time]float64{}
+	date := time.Date(2023, time.November, 1, 0, 0, 0, 0, time.Local)
+	for i := 0; i < 71; i++ {
+		// a day of the week is missing, and ties are ordered by date
+		if i != 66 {
+			lifetimeData[date] = float64((i * 7919) % 15000)
+		}
+		date = date.AddDate(0, 0, 1)
+	}
+	// today is not reported
+	lifetimeData[time.Date(2024, time.January, 10, 0, 0, 0, 0, time.Local)] = 50000
+	lifetimeData[time.Date(2023, time.December, 31, 0, 0, 0, 0, time.Local)] = 14999
+	lifetimeData[time.Date(2024, time.January, 2, 0, 0, 0, 0, time.Local)] = 14999
+
+	var values []DailyValue
+	for date, value := range lifetimeData {
+		values = append(values, DailyValue{date, value})
+	}
+	assert.NoError(t, store.PutDailyValues(ctx, stepsResource.Name, values))
+
+	data, err := store.queryTimeSeriesReportData(ctx, stepsResource, today, lastSevenDays(today))
+	assert.NoError(t, err)
+
+	// the report of the API data does not rank today either
+	delete(lifetimeData, time.Date(2024, time.January, 10, 0, 0, 0, 0, time.Local))
+	expected := buildTimeSeriesReportData(stepsResource, lifetimeData, today, lastSevenDays(today))
+
+	assert.Equal(t, expected.WeeklyTotal, data.WeeklyTotal)
+	assert.Equal(t, expected.WeeklyAverage, data.WeeklyAverage)
+	assert.Equal(t, expected.PreviousWeeklyTotal, data.PreviousWeeklyTotal)
+	assert.Equal(t, expected.Weekly, data.Weekly)
+	assert.Equal(t, expected.YearlyTop, data.YearlyTop)
+	assert.Equal(t, expected.LifetimeTop, data.LifetimeTop)
+	assert.Equal(t, expected.Ranks, data.Ranks)
+	assert.Equal(t, expected.WeeklyTotalRank, data.WeeklyTotalRank)
+	assert.Equal(t, expected.WeekCount, data.WeekCount)
+}
+
+func TestSharedSQLiteHistoryStore(t *testing.T) {
+	t.Setenv("HISTORY_STORE", HISTORY_STORE_SQLITE)
+	t.Setenv("TOKEN_STORE", TOKEN_STORE_SQLITE)
+	t.Setenv("HISTORY_PATH", filepath.Join(t.TempDir(), "history.db"))
+	defer closeSQLiteHistoryStores()
+
+	history, err := newHistoryStore(context.Background())
+	assert.NoError(t, err)
+	tokens, err := newTokenStore(context.Background())
+	assert.NoError(t, err)
+	again, err := newHistoryStore(context.Background())
+	assert.NoError(t, err)
+	// one handle for the process
+	assert.Same(t, history, tokens)
+	assert.Same(t, history, again)
+
+	assert.NoError(t, closeSQLiteHistoryStores())
+	assert.Error(t, history.(*SQLiteHistoryStore).DB.Ping())
+
+	reopened, err := newHistoryStore(context.Background())
+	assert.NoError(t, err)
+	assert.NotSame(t, history, reopened)
+	assert.NoError(t, reopened.(*SQLiteHistoryStore).DB.Ping())
+}
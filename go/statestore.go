@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	STATE_STORE_S3       = "s3"
+	STATE_STORE_DYNAMODB = "dynamodb"
+	STATE_STORE_FILE     = "file"
+
+	DYNAMODB_KEY_ATTRIBUTE   = "key"
+	DYNAMODB_VALUE_ATTRIBUTE = "value"
+)
+
+// StateStore persists small documents such as the delivery ledger between runs.
+// Get returns nil without an error when the key does not exist.
+type StateStore interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, value []byte) error
+}
+
+// MemoryStateStore keeps the documents in memory. The state is lost when the process exits.
+type MemoryStateStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func (store *MemoryStateStore) Get(ctx context.Context, key string) ([]byte, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.values[key], nil
+}
+
+func (store *MemoryStateStore) Put(ctx context.Context, key string, value []byte) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.values == nil {
+		store.values = map[string][]byte{}
+	}
+	store.values[key] = value
+	return nil
+}
+
+// FileStateStore stores each key as a file in Dir.
+type FileStateStore struct {
+	Dir string
+}
+
+func (store *FileStateStore) path(key string) string {
+	return filepath.Join(store.Dir, url.PathEscape(key))
+}
+
+func (store *FileStateStore) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := os.ReadFile(store.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return value, err
+}
+
+func (store *FileStateStore) Put(ctx context.Context, key string, value []byte) error {
+	if err := os.MkdirAll(store.Dir, 0755); err != nil {
+		return err
+	}
+
+	// write to a temporary file first so that a crash never leaves a truncated document
+	tempFile := store.path(key) + ".tmp"
+	if err := os.WriteFile(tempFile, value, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempFile, store.path(key))
+}
+
+// S3StateStore stores each key as an object under Prefix.
+type S3StateStore struct {
+	Client *s3.Client
+	Bucket string
+	Prefix string
+}
+
+func (store *S3StateStore) Get(ctx context.Context, key string) ([]byte, error) {
+	getObjectOutput, err := store.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(store.Prefix + key),
+	})
+	var noSuchKey *s3types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	defer getObjectOutput.Body.Close()
+	return io.ReadAll(getObjectOutput.Body)
+}
+
+func (store *S3StateStore) Put(ctx context.Context, key string, value []byte) error {
+	_, err := store.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(store.Prefix + key),
+		Body:   bytes.NewReader(value),
+	})
+	return err
+}
+
+// DynamoDBStateStore stores each key as an item of a table whose partition key is the string attribute "key".
+type DynamoDBStateStore struct {
+	Client *dynamodb.Client
+	Table  string
+}
+
+func (store *DynamoDBStateStore) Get(ctx context.Context, key string) ([]byte, error) {
+	getItemOutput, err := store.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(store.Table),
+		Key: map[string]types.AttributeValue{
+			DYNAMODB_KEY_ATTRIBUTE: &types.AttributeValueMemberS{Value: key},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := getItemOutput.Item[DYNAMODB_VALUE_ATTRIBUTE].(*types.AttributeValueMemberB)
+	if !ok {
+		return nil, nil
+	}
+	return value.Value, nil
+}
+
+func (store *DynamoDBStateStore) Put(ctx context.Context, key string, value []byte) error {
+	_, err := store.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(store.Table),
+		Item: map[string]types.AttributeValue{
+			DYNAMODB_KEY_ATTRIBUTE:   &types.AttributeValueMemberS{Value: key},
+			DYNAMODB_VALUE_ATTRIBUTE: &types.AttributeValueMemberB{Value: value},
+		},
+	})
+	return err
+}
+
+// newStateStore creates the store selected by STATE_STORE ("s3", "dynamodb" or "file").
+// It returns nil when STATE_STORE is not set.
+func (instances *Instances) newStateStore() (StateStore, error) {
+	switch os.Getenv("STATE_STORE") {
+	case "":
+		return nil, nil
+	case STATE_STORE_S3:
+		return &S3StateStore{
+			Client: instances.S3Client,
+			Bucket: os.Getenv("STATE_BUCKET_NAME"),
+			Prefix: os.Getenv("STATE_KEY_PREFIX"),
+		}, nil
+	case STATE_STORE_DYNAMODB:
+		return &DynamoDBStateStore{
+			Client: instances.DynamoDBClient,
+			Table:  os.Getenv("STATE_TABLE_NAME"),
+		}, nil
+	case STATE_STORE_FILE:
+		return &FileStateStore{
+			Dir: os.Getenv("STATE_DIR"),
+		}, nil
+	default:
+		return nil, errors.New(UnknownStateStore)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStateStore(t *testing.T) {
+	ctx := context.Background()
+	store := &FileStateStore{Dir: t.TempDir()}
+
+	value, err := store.Get(ctx, "deliveries/weekly/2024-W02/U1")
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+
+	err = store.Put(ctx, "deliveries/weekly/2024-W02/U1", []byte(`{"completed":true}`))
+	assert.NoError(t, err)
+
+	value, err = store.Get(ctx, "deliveries/weekly/2024-W02/U1")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"completed":true}`, string(value))
+}
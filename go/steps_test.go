@@ -86,15 +86,15 @@ func TestGenerateStepsReport(t *testing.T) {
 ======================
 Weekly Report
 
-1/7 Sun 1,000
-1/8 Mon 2,000
-1/9 Tue 1,000
-1/10 Wed 18,998
-1/11 Thu 1,000
-1/12 Fri 1,000
-1/13 Sat 19,000
-
-Total: 43,998
+1/7 Sun 1,000 (P10, #8 of 2024)
+1/8 Mon 2,000 (P30, #6 of 2024)
+1/9 Tue 1,000 (P10, #8 of 2024)
+1/10 Wed 18,998 (P88, #3 of 2024)
+1/11 Thu 1,000 (P10, #8 of 2024)
+1/12 Fri 1,000 (P10, #8 of 2024)
+1/13 Sat 19,000 (P95, #1 of 2024)
+
+Total: 43,998 (#3 of 5 weeks)
 Average: 6,285
 ======================
 Top Records in This Year
@@ -115,7 +115,10 @@ Top Records in Lifetime
 `
 
 	today := time.Date(2024, time.January, 14, 0, 0, 0, 0, time.Local)
-	actual := generateStepsReport(lifetimeStepsData, today)
+	actual, err := generateStepsReport(lifetimeStepsData, today, UserSettings{})
+	if err != nil {
+		t.Errorf("Error in generateStepsReport: %v", err)
+	}
 
 	if expected != actual {
 		t.Errorf("Expected %v, but got %v", expected, actual)
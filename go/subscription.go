@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+const (
+	FITBIT_COLLECTION_ACTIVITIES = "activities"
+	FITBIT_COLLECTION_SLEEP      = "sleep"
+	FITBIT_COLLECTION_BODY       = "body"
+
+	FITBIT_SIGNATURE_HEADER = "X-Fitbit-Signature"
+	UPDATE_QUEUE_SIZE       = 100
+	DEFAULT_SUBSCRIBER_ADDR = ":8081"
+)
+
+var fitbitCollections = []string{FITBIT_COLLECTION_ACTIVITIES, FITBIT_COLLECTION_SLEEP, FITBIT_COLLECTION_BODY}
+
+// FitbitNotification is an entry of a notification sent by the Fitbit Subscriptions API.
+type FitbitNotification struct {
+	CollectionType string `json:"collectionType"`
+	Date           string `json:"date"`
+	OwnerID        string `json:"ownerId"`
+	OwnerType      string `json:"ownerType"`
+	SubscriptionID string `json:"subscriptionId"`
+}
+
+func (notification FitbitNotification) key() string {
+	return notification.OwnerID + "/" + notification.CollectionType + "/" + notification.Date
+}
+
+// verifyFitbitSignature checks the X-Fitbit-Signature of a notification: the HMAC-SHA1 of the
+// body signed with the client secret followed by "&".
+func verifyFitbitSignature(clientSecret string, body []byte, signature string) bool {
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha1.New, []byte(clientSecret+"&"))
+	mac.Write(body)
+	return hmac.Equal(decoded, mac.Sum(nil))
+}
+
+// updateQueue holds the notifications waiting to be fetched. A notification already
+// waiting is not queued twice, as Fitbit notifies every sync of the day.
+type updateQueue struct {
+	mu      sync.Mutex
+	pending map[string]bool
+	ch      chan FitbitNotification
+}
+
+func newUpdateQueue(size int) *updateQueue {
+	return &updateQueue{
+		pending: map[string]bool{},
+		ch:      make(chan FitbitNotification, size),
+	}
+}
+
+// enqueue returns false when the queue is full.
+func (queue *updateQueue) enqueue(notification FitbitNotification) bool {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	if queue.pending[notification.key()] {
+		return true
+	}
+
+	select {
+	case queue.ch <- notification:
+		queue.pending[notification.key()] = true
+		return true
+	default:
+		return false
+	}
+}
+
+// dequeue waits for a notification. It returns false when ctx is done.
+func (queue *updateQueue) dequeue(ctx context.Context) (FitbitNotification, bool) {
+	select {
+	case <-ctx.Done():
+		return FitbitNotification{}, false
+	case notification := <-queue.ch:
+		// a notification arriving while this one is fetched is queued again
+		queue.mu.Lock()
+		delete(queue.pending, notification.key())
+		queue.mu.Unlock()
+		return notification, true
+	}
+}
+
+// SubscriptionServer is the subscriber endpoint of the Fitbit Subscriptions API.
+type SubscriptionServer struct {
+	VerificationCode string
+	ClientSecret     string
+	Queue            *updateQueue
+}
+
+func (server *SubscriptionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		// Fitbit verifies the subscriber with the correct code and with a wrong one
+		if r.URL.Query().Get("verify") == server.VerificationCode {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+
+	case http.MethodPost:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Fitbit expects 404 for a notification whose signature is invalid
+		if !verifyFitbitSignature(server.ClientSecret, body, r.Header.Get(FITBIT_SIGNATURE_HEADER)) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var notifications []FitbitNotification
+		if err := json.Unmarshal(body, &notifications); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		for _, notification := range notifications {
+			if !server.Queue.enqueue(notification) {
+				log.Printf("update queue is full, dropped %s", notification.key())
+			}
+		}
+
+		// Fitbit requires a response within 5 seconds, so fetching is left to the worker
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// SubscriptionWorker fetches the data of the queued notifications and persists it.
+type SubscriptionWorker struct {
+	Queue                    *updateQueue
+	History                  HistoryStore
+	Resources                []TimeSeriesResource
+	getAccessToken           func(ctx context.Context) (string, error)
+	fetchTimeSeries          timeSeriesFetchFunc
+	getActivityRecordsByDate func(ctx context.Context, access_token string, date time.Time) ([]ActivityRecord, error)
+	getSleepRecords          func(ctx context.Context, access_token string, startDate time.Time, endDate time.Time) ([]SleepRecord, error)
+	getBodyRecords           func(ctx context.Context, access_token string, startDate time.Time, endDate time.Time) ([]BodyRecord, error)
+	// called after the activities of a date have been persisted, e.g. to alert records
+	onActivities func(ctx context.Context, date time.Time) error
+}
+
+// Run processes notifications until ctx is done.
+func (worker *SubscriptionWorker) Run(ctx context.Context) {
+	for {
+		notification, ok := worker.Queue.dequeue(ctx)
+		if !ok {
+			return
+		}
+		if err := worker.process(ctx, notification); err != nil {
+			log.Printf("failed to process %s: %v", notification.key(), err)
+		}
+	}
+}
+
+func (worker *SubscriptionWorker) process(ctx context.Context, notification FitbitNotification) error {
+	date, err := time.ParseInLocation(DATE_FORMAT, notification.Date, time.Local)
+	if err != nil {
+		return err
+	}
+
+	accessToken, err := worker.getAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch notification.CollectionType {
+	case FITBIT_COLLECTION_ACTIVITIES:
+		for _, resource := range worker.Resources {
+			value, err := getDailyValue(ctx, accessToken, resource, date, worker.fetchTimeSeries)
+			if err != nil {
+				return err
+			}
+			if err := worker.History.PutDailyValues(ctx, resource.Name, []DailyValue{{date, value}}); err != nil {
+				return err
+			}
+		}
+
+		records, err := worker.getActivityRecordsByDate(ctx, accessToken, date)
+		if err != nil {
+			return err
+		}
+		if err := worker.History.PutActivities(ctx, records); err != nil {
+			return err
+		}
+
+		if worker.onActivities != nil {
+			return worker.onActivities(ctx, date)
+		}
+
+	case FITBIT_COLLECTION_SLEEP:
+		records, err := worker.getSleepRecords(ctx, accessToken, date, date)
+		if err != nil {
+			return err
+		}
+		return worker.History.PutSleep(ctx, records)
+
+	case FITBIT_COLLECTION_BODY:
+		records, err := worker.getBodyRecords(ctx, accessToken, date, date)
+		if err != nil {
+			return err
+		}
+		return worker.History.PutBody(ctx, records)
+	}
+
+	return nil
+}
+
+// recordAlerter pushes a record alert when the steps of a recent date entered the top 5.
+type recordAlerter struct {
+	History  HistoryStore
+	Limiter  *AlertLimiter
+	Pusher   messagePusher
+	UserID   string
+	Settings UserSettings
+}
+
+func (alerter *recordAlerter) alert(ctx context.Context, date time.Time) error {
+	now := time.Now().Local()
+	// older dates are notified when a tracker syncs after a long time, which is not news
+	if now.Sub(date) > 48*time.Hour {
+		return nil
+	}
+
+	quiet, err := alerter.Settings.inQuietHours(now)
+	if err != nil || quiet {
+		return err
+	}
+
+	lifetimeData, err := alerter.History.GetDailyValues(ctx, stepsResource.Name)
+	if err != nil {
+		return err
+	}
+
+	alert, err := buildRecordAlert(lifetimeData, date, alerter.Settings)
+	if err != nil || alert == nil {
+		return err
+	}
+
+	return alerter.Limiter.send(ctx, alerter.Pusher, alerter.UserID, []Alert{*alert}, now)
+}
+
+// recordAlerters fans an activity update out to the alerters of the users.
+type recordAlerters []*recordAlerter
+
+func (alerters recordAlerters) alert(ctx context.Context, date time.Time) error {
+	var errs []error
+	for _, alerter := range alerters {
+		if err := alerter.alert(ctx, date); err != nil {
+			errs = append(errs, fmt.Errorf("failed to alert %s: %v", alerter.UserID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newRecordAlerters returns an alerter per user, with the settings and the alert limit of the user.
+func newRecordAlerters(history HistoryStore, stateStore StateStore, pusher messagePusher, userIDs []string, loadSettings func(userID string) (UserSettings, error)) (recordAlerters, error) {
+	var alerters recordAlerters
+	for _, userID := range userIDs {
+		settings, err := loadSettings(userID)
+		if err != nil {
+			return nil, err
+		}
+		alerters = append(alerters, &recordAlerter{
+			History:  history,
+			Limiter:  &AlertLimiter{Store: stateStore, MaxPerDay: settings.maxAlertsPerDay()},
+			Pusher:   pusher,
+			UserID:   userID,
+			Settings: settings,
+		})
+	}
+	return alerters, nil
+}
+
+// createFitbitSubscription subscribes to the notifications of a collection.
+func createFitbitSubscription(ctx context.Context, access_token string, collection string, subscriptionID string, subscriberID string) error {
+	apiUrl := FITBIT_API_URL + "/1/user/-/" + collection + "/apiSubscriptions/" + subscriptionID + ".json"
+	client := fitbitHTTPClient
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiUrl, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create Fitbit API request: %v", err)
+	}
+
+	req.Header.Add("Authorization", "Bearer "+access_token)
+	if subscriberID != "" {
+		req.Header.Add("X-Fitbit-Subscriber-Id", subscriberID)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call Fitbit API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// 200 when the subscription already exists
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("failed to subscribe to %s: %s", collection, resp.Status)
+	}
+	return nil
+}
+
+// runSubscriber serves the subscriber endpoint and fetches the notified updates.
+func runSubscriber(args []string) error {
+	flags := flag.NewFlagSet("subscriber", flag.ContinueOnError)
+	addr := flags.String("addr", DEFAULT_SUBSCRIBER_ADDR, "address to listen on")
+	subscribe := flags.Bool("subscribe", false, "subscribe to the activities, sleep and body collections before serving")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	instances, err := newInstances(ctx)
+	if err != nil {
+		return err
+	}
+
+	history, err := newHistoryStore(ctx)
+	if err != nil {
+		return err
+	}
+	if history == nil {
+		return errors.New(MissingHistoryStore)
+	}
+
+	clientSecret, err := instances.getParameter(os.Getenv("CLIENT_SECRET_PARAMETER_NAME_GO"))
+	if err != nil {
+		return err
+	}
+	verificationCode, err := instances.getParameter(os.Getenv("FITBIT_VERIFICATION_CODE_PARAMETER_NAME"))
+	if err != nil {
+		return err
+	}
+	lineChannelToken, err := instances.getParameter(os.Getenv("LINE_CHANNEL_TOKEN_PARAMETER_NAME"))
+	if err != nil {
+		return err
+	}
+	lineUserId, err := instances.getParameter(os.Getenv("LINE_USER_ID_PARAMETER_NAME"))
+	if err != nil {
+		return err
+	}
+
+	stateStore, err := instances.newStateStore()
+	if err != nil {
+		return err
+	}
+	if stateStore == nil {
+		stateStore = &MemoryStateStore{}
+	}
+
+	bot, err := messaging_api.NewMessagingApiAPI(*lineChannelToken)
+	if err != nil {
+		return err
+	}
+
+	extraResources, err := lookupExtraTimeSeriesResources()
+	if err != nil {
+		return err
+	}
+
+	tokens := &accessTokenCache{fetch: instances.getAccessToken}
+	if *subscribe {
+		accessToken, err := tokens.get(ctx)
+		if err != nil {
+			return err
+		}
+		for _, collection := range fitbitCollections {
+			if err := createFitbitSubscription(ctx, accessToken, collection, collection, os.Getenv("FITBIT_SUBSCRIBER_ID")); err != nil {
+				return err
+			}
+		}
+	}
+
+	alerters, err := newRecordAlerters(history, stateStore, bot, parseUserIDs(*lineUserId), loadUserSettings)
+	if err != nil {
+		return err
+	}
+	queue := newUpdateQueue(UPDATE_QUEUE_SIZE)
+	worker := &SubscriptionWorker{
+		Queue:                    queue,
+		History:                  history,
+		Resources:                append([]TimeSeriesResource{stepsResource}, extraResources...),
+		getAccessToken:           tokens.get,
+		fetchTimeSeries:          getTimeSeriesByDateRange,
+		getActivityRecordsByDate: getActivityRecordsByDate,
+		getSleepRecords:          getSleepRecordsByDateRange,
+		getBodyRecords:           getBodyRecordsByDateRange,
+		onActivities:             alerters.alert,
+	}
+	go worker.Run(ctx)
+
+	return http.ListenAndServe(*addr, &SubscriptionServer{
+		VerificationCode: *verificationCode,
+		ClientSecret:     *clientSecret,
+		Queue:            queue,
+	})
+}
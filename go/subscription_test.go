@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func signFitbit(clientSecret string, body string) string {
+	mac := hmac.New(sha1.New, []byte(clientSecret+"&"))
+	mac.Write([]byte(body))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestSubscriptionServer(t *testing.T) {
+	queue := newUpdateQueue(2)
+	server := &SubscriptionServer{VerificationCode: "code", ClientSecret: "secret", Queue: queue}
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?verify=code", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?verify=wrong", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	body := `[{"collectionType":"activities","date":"2024-03-01","ownerId":"A","ownerType":"user","subscriptionId":"activities"},` +
+		`{"collectionType":"activities","date":"2024-03-01","ownerId":"A","ownerType":"user","subscriptionId":"activities"},` +
+		`{"collectionType":"sleep","date":"2024-03-01","ownerId":"A","ownerType":"user","subscriptionId":"sleep"}]`
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set(FITBIT_SIGNATURE_HEADER, signFitbit("other", body))
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Len(t, queue.ch, 0)
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set(FITBIT_SIGNATURE_HEADER, signFitbit("secret", body))
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	// the duplicated notification is queued once
+	assert.Len(t, queue.ch, 2)
+
+	notification, ok := queue.dequeue(context.Background())
+	assert.True(t, ok)
+	assert.Equal(t, "A/activities/2024-03-01", notification.key())
+
+	// queued again once it has been taken
+	assert.True(t, queue.enqueue(notification))
+	// the queue is full
+	assert.False(t, queue.enqueue(FitbitNotification{CollectionType: "body", Date: "2024-03-01"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, ok = newUpdateQueue(1).dequeue(ctx)
+	assert.False(t, ok)
+}
+
+func TestSubscriptionWorker(t *testing.T) {
+	ctx := context.Background()
+	history := &FileHistoryStore{Path: t.TempDir() + "/history.json"}
+	day := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.Local)
+
+	var alerted []time.Time
+	worker := &SubscriptionWorker{
+		History:   history,
+		Resources: []TimeSeriesResource{stepsResource, timeSeriesResources["floors"]},
+		getAccessToken: func(ctx context.Context) (string, error) {
+			return "token", nil
+		},
+		fetchTimeSeries: func(ctx context.Context, access_token string, resource TimeSeriesResource, startDate string, endDate string) (map[string][]map[string]string, error) {
+			return map[string][]map[string]string{
+				resource.responseKey(): {{"dateTime": startDate, "value": "12"}},
+			}, nil
+		},
+		getActivityRecordsByDate: func(ctx context.Context, access_token string, date time.Time) ([]ActivityRecord, error) {
+			return []ActivityRecord{{LogID: 1, Name: "Run", StartTime: date.Add(7 * time.Hour)}}, nil
+		},
+		getSleepRecords: func(ctx context.Context, access_token string, startDate time.Time, endDate time.Time) ([]SleepRecord, error) {
+			return []SleepRecord{{LogID: 1, StartTime: startDate.Add(-time.Hour)}}, nil
+		},
+		onActivities: func(ctx context.Context, date time.Time) error {
+			alerted = append(alerted, date)
+			return nil
+		},
+	}
+
+	err := worker.process(ctx, FitbitNotification{CollectionType: FITBIT_COLLECTION_ACTIVITIES, Date: "2024-03-01"})
+	assert.NoError(t, err)
+
+	steps, err := history.GetDailyValues(ctx, "steps")
+	assert.NoError(t, err)
+	assert.Equal(t, map[time.Time]float64{day: 12}, steps)
+	floors, err := history.GetDailyValues(ctx, "floors")
+	assert.NoError(t, err)
+	assert.Equal(t, map[time.Time]float64{day: 12}, floors)
+
+	activities, err := history.GetActivities(ctx, day, day.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.Len(t, activities, 1)
+	assert.Equal(t, []time.Time{day}, alerted)
+
+	err = worker.process(ctx, FitbitNotification{CollectionType: FITBIT_COLLECTION_SLEEP, Date: "2024-03-01"})
+	assert.NoError(t, err)
+	sleep, err := history.GetSleep(ctx, day.AddDate(0, 0, -1), day)
+	assert.NoError(t, err)
+	assert.Len(t, sleep, 1)
+}
+
+func TestRecordAlerters(t *testing.T) {
+	ctx := context.Background()
+	history := &FileHistoryStore{Path: filepath.Join(t.TempDir(), "history.json")}
+	now := time.Now().Local()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	var values []DailyValue
+	for i := 1; i <= 30; i++ {
+		values = append(values, DailyValue{today.AddDate(0, 0, -i), 5000})
+	}
+	values = append(values, DailyValue{today, 30000})
+	assert.NoError(t, history.PutDailyValues(ctx, stepsResource.Name, values))
+
+	pusher := &mockPusher{failAt: -1}
+	loadSettings := func(userID string) (UserSettings, error) {
+		return parseUserSettings(`{"users": {"U2": {"locale": "ja", "maxAlertsPerDay": 1}}}`, userID)
+	}
+	alerters, err := newRecordAlerters(history, &MemoryStateStore{}, pusher, []string{"U1", "U2"}, loadSettings)
+	assert.NoError(t, err)
+	assert.Len(t, alerters, 2)
+	assert.Equal(t, DEFAULT_MAX_ALERTS_PER_DAY, alerters[0].Limiter.MaxPerDay)
+	assert.Equal(t, 1, alerters[1].Limiter.MaxPerDay)
+	assert.Equal(t, "ja", alerters[1].Settings.Locale)
+
+	// each user is alerted in their own push
+	assert.NoError(t, alerters.alert(ctx, today))
+	assert.Len(t, pusher.requests, 2)
+	assert.Equal(t, "U1", pusher.requests[0].To)
+	assert.Equal(t, "U2", pusher.requests[1].To)
+}
+
+func TestFitbitRecords(t *testing.T) {
+	activity, err := fitbitActivity{LogID: 1, ActivityName: "Run", StartTime: "2024-03-01T07:00:00.000+09:00", Duration: 1800000, Distance: 5.2, LogType: "tracker"}.record()
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Minute, activity.Duration)
+	assert.Equal(t, "2024-03-01", activity.StartTime.Format(DATE_FORMAT))
+	assert.Equal(t, "tracker", activity.Source)
+
+	sleep, err := fitbitSleep{LogID: 2, DateOfSleep: "2024-03-02", StartTime: "2024-03-01T23:30:00.000", EndTime: "2024-03-02T06:30:00.000", MinutesAsleep: 390}.record()
+	assert.NoError(t, err)
+	assert.Equal(t, 7*time.Hour, sleep.EndTime.Sub(sleep.StartTime))
+
+	body, err := fitbitWeight{LogID: 3, Date: "2024-03-01", Time: "07:15:00", Weight: 60.5}.record()
+	assert.NoError(t, err)
+	assert.Equal(t, 7, body.Time.Hour())
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// formats of the times in the Fitbit data export
+	TAKEOUT_TIME_FORMAT = "01/02/06 15:04:05"
+
+	TAKEOUT_SOURCE = "takeout"
+	KM_PER_MILE    = 1.609344
+)
+
+type takeoutValue struct {
+	DateTime string `json:"dateTime"`
+	Value    string `json:"value"`
+}
+
+type takeoutExercise struct {
+	LogID        int64   `json:"logId"`
+	ActivityName string  `json:"activityName"`
+	StartTime    string  `json:"startTime"`
+	Duration     int64   `json:"duration"`
+	Distance     float64 `json:"distance"`
+	DistanceUnit string  `json:"distanceUnit"`
+	Calories     float64 `json:"calories"`
+	Steps        int     `json:"steps"`
+}
+
+func (exercise takeoutExercise) record() (ActivityRecord, error) {
+	startTime, err := time.ParseInLocation(TAKEOUT_TIME_FORMAT, exercise.StartTime, time.Local)
+	if err != nil {
+		return ActivityRecord{}, err
+	}
+
+	distance := exercise.Distance
+	if exercise.DistanceUnit == "Mile" {
+		distance *= KM_PER_MILE
+	}
+
+	return ActivityRecord{
+		LogID:     exercise.LogID,
+		Name:      exercise.ActivityName,
+		StartTime: startTime,
+		Duration:  time.Duration(exercise.Duration) * time.Millisecond,
+		Distance:  distance,
+		Calories:  exercise.Calories,
+		Steps:     exercise.Steps,
+		Source:    TAKEOUT_SOURCE,
+	}, nil
+}
+
+// takeoutData is the data read from a Fitbit data export.
+type takeoutData struct {
+	Steps      map[time.Time]float64
+	Activities []ActivityRecord
+	Sleep      []SleepRecord
+}
+
+// readTakeout reads steps-*.json, exercise-*.json and sleep-*.json anywhere in the export.
+// The steps are per minute in UTC, and are summed up per local date.
+func readTakeout(fsys fs.FS) (takeoutData, error) {
+	data := takeoutData{Steps: map[time.Time]float64{}}
+
+	err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		base := path.Base(name)
+		if !strings.HasSuffix(base, ".json") {
+			return nil
+		}
+
+		switch {
+		case strings.HasPrefix(base, "steps-"):
+			var values []takeoutValue
+			if err := readTakeoutJSON(fsys, name, &values); err != nil {
+				return err
+			}
+			for _, value := range values {
+				t, err := time.ParseInLocation(TAKEOUT_TIME_FORMAT, value.DateTime, time.UTC)
+				if err != nil {
+					return err
+				}
+				steps, err := strconv.ParseFloat(value.Value, 64)
+				if err != nil {
+					return err
+				}
+				t = t.In(time.Local)
+				data.Steps[time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)] += steps
+			}
+
+		case strings.HasPrefix(base, "exercise-"):
+			var exercises []takeoutExercise
+			if err := readTakeoutJSON(fsys, name, &exercises); err != nil {
+				return err
+			}
+			for _, exercise := range exercises {
+				record, err := exercise.record()
+				if err != nil {
+					return err
+				}
+				data.Activities = append(data.Activities, record)
+			}
+
+		case strings.HasPrefix(base, "sleep-"):
+			var sleeps []fitbitSleep
+			if err := readTakeoutJSON(fsys, name, &sleeps); err != nil {
+				return err
+			}
+			for _, sleep := range sleeps {
+				record, err := sleep.record()
+				if err != nil {
+					return err
+				}
+				data.Sleep = append(data.Sleep, record)
+			}
+		}
+		return nil
+	})
+
+	return data, err
+}
+
+func readTakeoutJSON(fsys fs.FS, name string, v interface{}) error {
+	value, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(value, v); err != nil {
+		return errors.New(InvalidTakeoutFile + ": " + name + ": " + err.Error())
+	}
+	return nil
+}
+
+// importSummary counts the imported records and the ones already in the history.
+type importSummary struct {
+	Days, SkippedDays             int
+	Activities, SkippedActivities int
+	Sleep, SkippedSleep           int
+}
+
+// importTakeout adds the export to the history. Records fetched from the API are kept:
+// days already in the history and logs with a known log ID are skipped.
+func importTakeout(ctx context.Context, history HistoryStore, data takeoutData) (importSummary, error) {
+	summary := importSummary{}
+
+	storedSteps, err := history.GetDailyValues(ctx, stepsResource.Name)
+	if err != nil {
+		return summary, err
+	}
+	var steps []DailyValue
+	for date, value := range data.Steps {
+		if _, ok := storedSteps[date]; ok {
+			summary.SkippedDays += 1
+			continue
+		}
+		steps = append(steps, DailyValue{date, value})
+	}
+	summary.Days = len(steps)
+	if err := history.PutDailyValues(ctx, stepsResource.Name, steps); err != nil {
+		return summary, err
+	}
+
+	storedActivities, err := history.GetActivities(ctx, time.Time{}, time.Now().AddDate(1, 0, 0))
+	if err != nil {
+		return summary, err
+	}
+	knownActivities := map[int64]bool{}
+	for _, record := range storedActivities {
+		knownActivities[record.LogID] = true
+	}
+	var activities []ActivityRecord
+	for _, record := range data.Activities {
+		if knownActivities[record.LogID] {
+			summary.SkippedActivities += 1
+			continue
+		}
+		activities = append(activities, record)
+	}
+	summary.Activities = len(activities)
+	if err := history.PutActivities(ctx, activities); err != nil {
+		return summary, err
+	}
+
+	storedSleep, err := history.GetSleep(ctx, time.Time{}, time.Now().AddDate(1, 0, 0))
+	if err != nil {
+		return summary, err
+	}
+	knownSleep := map[int64]bool{}
+	for _, record := range storedSleep {
+		knownSleep[record.LogID] = true
+	}
+	var sleep []SleepRecord
+	for _, record := range data.Sleep {
+		if knownSleep[record.LogID] {
+			summary.SkippedSleep += 1
+			continue
+		}
+		sleep = append(sleep, record)
+	}
+	summary.Sleep = len(sleep)
+	if err := history.PutSleep(ctx, sleep); err != nil {
+		return summary, err
+	}
+
+	return summary, nil
+}
+
+// mergeHistorySteps adds the stored steps of the dates the API did not return, e.g. imported
+// days before START_DATE.
+func mergeHistorySteps(ctx context.Context, history HistoryStore, lifetimeStepsData map[time.Time]int, today time.Time) error {
+	storedSteps, err := history.GetDailyValues(ctx, stepsResource.Name)
+	if err != nil {
+		return err
+	}
+
+	todayDate := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+	for date, value := range storedSteps {
+		if _, ok := lifetimeStepsData[date]; !ok && date.Before(todayDate) {
+			lifetimeStepsData[date] = int(value)
+		}
+	}
+	return nil
+}
+
+// mergeHistoryRuns adds the stored runs of this year the API did not return. A stored run
+// overlapping a returned one or another stored one, e.g. an imported track of a run synced to
+// Fitbit later, is the same run.
+func mergeHistoryRuns(ctx context.Context, history HistoryStore, yearlyRunningLog map[time.Time]float64, today time.Time) error {
+	yearStart := time.Date(today.Year(), time.January, 1, 0, 0, 0, 0, today.Location())
+	records, err := history.GetActivities(ctx, yearStart, today)
+	if err != nil {
+		return err
+	}
+	addRuns(yearlyRunningLog, records)
+	return nil
+}
+
+// addRuns adds the runs to the running log, except the ones overlapping a run in the log by
+// overlapActivities. The log has no durations, so its runs are taken as
+// DUPLICATE_ACTIVITY_TOLERANCE long.
+func addRuns(runningLog map[time.Time]float64, records []ActivityRecord) {
+	var known []ActivityRecord
+	for startTime := range runningLog {
+		known = append(known, ActivityRecord{Name: "Run", StartTime: startTime})
+	}
+	for _, record := range records {
+		if record.Name != "Run" || overlapsAny(record, known) {
+			continue
+		}
+		runningLog[record.StartTime] = record.Distance
+		known = append(known, record)
+	}
+}
+
+// getHistoryRunningLog returns the runs of this year with the stored runs of the years before.
+func getHistoryRunningLog(ctx context.Context, history HistoryStore, yearlyRunningLog map[time.Time]float64, today time.Time) (map[time.Time]float64, error) {
+	yearStart := time.Date(today.Year(), time.January, 1, 0, 0, 0, 0, today.Location())
+	records, err := history.GetActivities(ctx, time.Time{}, yearStart)
+	if err != nil {
+		return nil, err
+	}
+
+	runningLog := map[time.Time]float64{}
+	for startTime, distance := range yearlyRunningLog {
+		runningLog[startTime] = distance
+	}
+	addRuns(runningLog, records)
+	return runningLog, nil
+}
+
+// runImport imports a Fitbit data export, either the zip file or the extracted directory.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New(MissingTakeoutPath)
+	}
+
+	ctx := context.TODO()
+	history, err := newHistoryStore(ctx)
+	if err != nil {
+		return err
+	}
+	if history == nil {
+		return errors.New(MissingHistoryStore)
+	}
+
+	archivePath := flags.Arg(0)
+	var fsys fs.FS
+	if strings.HasSuffix(archivePath, ".zip") {
+		archive, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+		fsys = archive
+	} else {
+		fsys = os.DirFS(archivePath)
+	}
+
+	data, err := readTakeout(fsys)
+	if err != nil {
+		return err
+	}
+
+	summary, err := importTakeout(ctx, history, data)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("imported %d days (%d skipped), %d activities (%d skipped), %d sleep logs (%d skipped)",
+		summary.Days, summary.SkippedDays, summary.Activities, summary.SkippedActivities, summary.Sleep, summary.SkippedSleep)
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadTakeout(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("JST", 9*60*60)
+	defer func() { time.Local = local }()
+
+	// steps are in UTC: 15:00 UTC is the next day in JST
+	fsys := fstest.MapFS{
+		"Takeout/Fitbit/Physical Activity/steps-2020-01-01.json": {Data: []byte(`[
+			{"dateTime": "01/01/20 00:00:00", "value": "10"},
+			{"dateTime": "01/01/20 14:59:00", "value": "20"},
+			{"dateTime": "01/01/20 15:00:00", "value": "30"}
+		]`)},
+		"Takeout/Fitbit/Physical Activity/exercise-0.json": {Data: []byte(`[
+			{"logId": 1, "activityName": "Run", "startTime": "01/01/20 07:00:00", "duration": 1800000, "distance": 3.1, "distanceUnit": "Mile", "calories": 300, "steps": 4000}
+		]`)},
+		"Takeout/Fitbit/Sleep/sleep-2020-01-01.json": {Data: []byte(`[
+			{"logId": 2, "dateOfSleep": "2020-01-02", "startTime": "2020-01-01T23:00:00.000", "endTime": "2020-01-02T06:00:00.000", "minutesAsleep": 400, "efficiency": 95}
+		]`)},
+		"Takeout/Fitbit/Physical Activity/heart_rate-2020-01-01.json": {Data: []byte(`[]`)},
+	}
+
+	data, err := readTakeout(fsys)
+	assert.NoError(t, err)
+	assert.Equal(t, map[time.Time]float64{
+		time.Date(2020, time.January, 1, 0, 0, 0, 0, time.Local): 30,
+		time.Date(2020, time.January, 2, 0, 0, 0, 0, time.Local): 30,
+	}, data.Steps)
+	assert.Len(t, data.Activities, 1)
+	assert.InDelta(t, 4.989, data.Activities[0].Distance, 0.001)
+	assert.Equal(t, 30*time.Minute, data.Activities[0].Duration)
+	assert.Equal(t, TAKEOUT_SOURCE, data.Activities[0].Source)
+	assert.Len(t, data.Sleep, 1)
+	assert.Equal(t, 400, data.Sleep[0].MinutesAsleep)
+
+	_, err = readTakeout(fstest.MapFS{"steps-2020-01-01.json": {Data: []byte(`{`)}})
+	assert.ErrorContains(t, err, InvalidTakeoutFile)
+}
+
+func TestReadTakeoutZip(t *testing.T) {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	file, err := writer.Create("Fitbit/Physical Activity/steps-2020-01-01.json")
+	assert.NoError(t, err)
+	_, err = file.Write([]byte(`[{"dateTime": "01/01/20 12:00:00", "value": "10"}]`))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+
+	data, err := readTakeout(archive)
+	assert.NoError(t, err)
+	assert.Len(t, data.Steps, 1)
+}
+
+func TestImportTakeout(t *testing.T) {
+	ctx := context.Background()
+	history := &FileHistoryStore{Path: filepath.Join(t.TempDir(), "history.json")}
+	day := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.Local)
+
+	// fetched from the API
+	assert.NoError(t, history.PutDailyValues(ctx, "steps", []DailyValue{{day, 12000}}))
+	assert.NoError(t, history.PutActivities(ctx, []ActivityRecord{{LogID: 1, Name: "Run", StartTime: day.Add(7 * time.Hour), Distance: 5, Source: "tracker"}}))
+
+	summary, err := importTakeout(ctx, history, takeoutData{
+		Steps: map[time.Time]float64{day: 11000, day.AddDate(0, 0, -1): 9000},
+		Activities: []ActivityRecord{
+			{LogID: 1, Name: "Run", StartTime: day.Add(7 * time.Hour), Distance: 4.9, Source: TAKEOUT_SOURCE},
+			{LogID: 3, Name: "Walk", StartTime: day.Add(-17 * time.Hour), Source: TAKEOUT_SOURCE},
+		},
+		Sleep: []SleepRecord{{LogID: 2, StartTime: day}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, importSummary{Days: 1, SkippedDays: 1, Activities: 1, SkippedActivities: 1, Sleep: 1}, summary)
+
+	steps, err := history.GetDailyValues(ctx, "steps")
+	assert.NoError(t, err)
+	assert.Equal(t, map[time.Time]float64{day: 12000, day.AddDate(0, 0, -1): 9000}, steps)
+
+	activities, err := history.GetActivities(ctx, day.AddDate(0, 0, -1), day.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.Len(t, activities, 2)
+	assert.Equal(t, "tracker", activities[1].Source)
+
+	// merged into the API data of the weekly report
+	lifetimeStepsData := map[time.Time]int{day: 12500}
+	assert.NoError(t, mergeHistorySteps(ctx, history, lifetimeStepsData, day.AddDate(0, 0, 10)))
+	assert.Equal(t, map[time.Time]int{day: 12500, day.AddDate(0, 0, -1): 9000}, lifetimeStepsData)
+
+	yearlyRunningLog := map[time.Time]float64{}
+	assert.NoError(t, mergeHistoryRuns(ctx, history, yearlyRunningLog, day.AddDate(0, 0, 10)))
+	assert.Len(t, yearlyRunningLog, 1)
+	assert.Equal(t, 5.0, yearlyRunningLog[activities[1].StartTime])
+
+	// runs returned by the API are not counted twice
+	yearlyRunningLog = map[time.Time]float64{day.Add(7 * time.Hour).UTC(): 5.1}
+	assert.NoError(t, mergeHistoryRuns(ctx, history, yearlyRunningLog, day.AddDate(0, 0, 10)))
+	assert.Len(t, yearlyRunningLog, 1)
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ValueType int
+
+const (
+	IntValue ValueType = iota
+	FloatValue
+)
+
+// TimeSeriesResource describes a Fitbit activity time series resource.
+type TimeSeriesResource struct {
+	Name      string
+	Path      string
+	Unit      string
+	ValueType ValueType
+	LimitDays int
+}
+
+const (
+	// the maximum range of a request by date range; the other activity series allow LIMIT_DAYS
+	ACTIVITY_CALORIES_LIMIT_DAYS = 30
+)
+
+type DailyValue struct {
+	Date  time.Time
+	Value float64
+}
+
+type timeSeriesFetchFunc func(context.Context, string, TimeSeriesResource, string, string) (map[string][]map[string]string, error)
+
+var timeSeriesResources = map[string]TimeSeriesResource{
+	"steps":                {Name: "steps", Path: "activities/steps", ValueType: IntValue, LimitDays: LIMIT_DAYS},
+	"distance":             {Name: "distance", Path: "activities/distance", Unit: "km", ValueType: FloatValue, LimitDays: LIMIT_DAYS},
+	"floors":               {Name: "floors", Path: "activities/floors", ValueType: IntValue, LimitDays: LIMIT_DAYS},
+	"elevation":            {Name: "elevation", Path: "activities/elevation", Unit: "m", ValueType: FloatValue, LimitDays: LIMIT_DAYS},
+	"calories":             {Name: "calories", Path: "activities/calories", Unit: "kcal", ValueType: IntValue, LimitDays: LIMIT_DAYS},
+	"activityCalories":     {Name: "activityCalories", Path: "activities/activityCalories", Unit: "kcal", ValueType: IntValue, LimitDays: ACTIVITY_CALORIES_LIMIT_DAYS},
+	"minutesSedentary":     {Name: "minutesSedentary", Path: "activities/minutesSedentary", Unit: "min", ValueType: IntValue, LimitDays: LIMIT_DAYS},
+	"minutesLightlyActive": {Name: "minutesLightlyActive", Path: "activities/minutesLightlyActive", Unit: "min", ValueType: IntValue, LimitDays: LIMIT_DAYS},
+	"minutesFairlyActive":  {Name: "minutesFairlyActive", Path: "activities/minutesFairlyActive", Unit: "min", ValueType: IntValue, LimitDays: LIMIT_DAYS},
+	"minutesVeryActive":    {Name: "minutesVeryActive", Path: "activities/minutesVeryActive", Unit: "min", ValueType: IntValue, LimitDays: LIMIT_DAYS},
+}
+
+var stepsResource = timeSeriesResources["steps"]
+
+func lookupTimeSeriesResource(name string) (TimeSeriesResource, error) {
+	resource, ok := timeSeriesResources[name]
+	if !ok {
+		return TimeSeriesResource{}, fmt.Errorf("%s: %s", UnknownTimeSeriesResource, name)
+	}
+	return resource, nil
+}
+
+// responseKey returns the key under which Fitbit returns the series, e.g. "activities-steps".
+func (resource TimeSeriesResource) responseKey() string {
+	return strings.ReplaceAll(resource.Path, "/", "-")
+}
+
+func (resource TimeSeriesResource) parseValue(value string) (float64, error) {
+	if resource.ValueType == IntValue {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, err
+		}
+		return float64(v), nil
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+func (resource TimeSeriesResource) formatValue(locale Locale, value float64) string {
+	var str string
+	if resource.ValueType == IntValue {
+		str = locale.formatInt(int(value))
+	} else {
+		str = locale.formatFloat(value)
+	}
+	return str + resource.Unit
+}
+
+func (resource TimeSeriesResource) title(locale Locale) string {
+	return locale.message("resource." + resource.Name)
+}
+
+// headingSuffix distinguishes the headings of non-steps reports, keeping the steps report as is.
+func (resource TimeSeriesResource) headingSuffix(locale Locale) string {
+	if resource.Path == stepsResource.Path {
+		return ""
+	}
+	return " (" + resource.title(locale) + ")"
+}
+
+func getLifetimeTimeSeriesHistory(ctx context.Context, access_token string, resource TimeSeriesResource, today time.Time, fetchFunc timeSeriesFetchFunc) (map[time.Time]float64, error) {
+	if resource.LimitDays <= 0 {
+		return nil, errors.New(InvalidLimitDays)
+	}
+
+	// Number of target days
+	restTargetDays := int(today.Sub(startDateParse).Hours() / 24)
+	count := 0
+
+	lifetimeData := map[time.Time]float64{}
+
+	for restTargetDays > 0 {
+		tmpEndDate := today.Add(-24 * time.Hour * time.Duration(1+resource.LimitDays*count))
+
+		var tmpStartDate time.Time
+		if restTargetDays > resource.LimitDays {
+			tmpStartDate = tmpEndDate.Add(-24 * time.Hour * time.Duration(resource.LimitDays-1))
+		} else {
+			tmpStartDate = startDateParse
+		}
+
+		tmpData, err := fetchFunc(ctx, access_token, resource, tmpStartDate.Format(DATE_FORMAT), tmpEndDate.Format(DATE_FORMAT))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, dailyHistory := range tmpData[resource.responseKey()] {
+			dateTime, err := time.Parse(DATE_FORMAT, dailyHistory["dateTime"])
+			if err != nil {
+				return nil, err
+			}
+
+			dateTime = time.Date(dateTime.Year(), dateTime.Month(), dateTime.Day(), 0, 0, 0, 0, time.Local)
+
+			value, err := resource.parseValue(dailyHistory["value"])
+			if err != nil {
+				return nil, err
+			}
+
+			lifetimeData[dateTime] = value
+		}
+
+		restTargetDays -= resource.LimitDays
+		count += 1
+	}
+
+	return lifetimeData, nil
+}
+
+// getDailyValue gets the value of a single day, e.g. the steps so far today.
+func getDailyValue(ctx context.Context, access_token string, resource TimeSeriesResource, date time.Time, fetchFunc timeSeriesFetchFunc) (float64, error) {
+	data, err := fetchFunc(ctx, access_token, resource, date.Format(DATE_FORMAT), date.Format(DATE_FORMAT))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, dailyHistory := range data[resource.responseKey()] {
+		if dailyHistory["dateTime"] == date.Format(DATE_FORMAT) {
+			return resource.parseValue(dailyHistory["value"])
+		}
+	}
+	return 0, nil
+}
+
+func getTimeSeriesByDateRange(ctx context.Context, access_token string, resource TimeSeriesResource, startDate string, endDate string) (map[string][]map[string]string, error) {
+	apiUrl := "https://api.fitbit.com/1/user/-/" + resource.Path + "/date/" + startDate + "/" + endDate + ".json"
+	client := fitbitHTTPClient
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Fitbit API request: %v", err)
+	}
+
+	req.Header.Add("Authorization", "Bearer "+access_token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Fitbit API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var responseData map[string][]map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&responseData); err != nil {
+		return nil, fmt.Errorf("failed to decode Fitbit API response: %v", err)
+	}
+
+	return responseData, nil
+}
+
+// sortDailyValues returns the data sorted by value in descending order, older dates first on ties.
+func sortDailyValues(data map[time.Time]float64) []DailyValue {
+	var items []DailyValue
+	for k, v := range data {
+		items = append(items, DailyValue{k, v})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Value == items[j].Value {
+			return items[i].Date.Before(items[j].Date)
+		}
+		return items[i].Value > items[j].Value
+	})
+	return items
+}
+
+// TimeSeriesReportData is the data model the time series report templates are rendered from.
+type TimeSeriesReportData struct {
+	Resource TimeSeriesResource
+	// set when rendering since it depends on the locale
+	HeadingSuffix string
+	Today         time.Time
+	// the days of Weekly
+	Window        ReportWindow
+	Weekly        []DailyValue
+	WeeklyTotal   float64
+	WeeklyAverage float64
+	// total of the window before Window
+	PreviousWeeklyTotal float64
+	YearlyTop           []DailyValue
+	LifetimeTop         []DailyValue
+	// this year as emoji, set when the heatmap is enabled in the settings
+	Heatmap []HeatmapBlock
+	// set by applyTopRecords
+	TopPeriods []PeriodRecords
+	// ranks of the days of Weekly with data
+	Ranks map[time.Time]*DailyRank
+	// rank of WeeklyTotal among the weeks in the lifetime, set when the week has data
+	WeeklyTotalRank int
+	WeekCount       int
+	// last sync of the tracker, zero when unknown. The days after it have no data yet.
+	LastSync time.Time
+}
+
+// NotSynced reports whether the tracker has not synced the day yet.
+func (data TimeSeriesReportData) NotSynced(date time.Time) bool {
+	if data.LastSync.IsZero() {
+		return false
+	}
+	lastSync := data.LastSync.In(date.Location())
+	return date.After(time.Date(lastSync.Year(), lastSync.Month(), lastSync.Day(), 0, 0, 0, 0, date.Location()))
+}
+
+func buildTimeSeriesReportData(resource TimeSeriesResource, lifetimeData map[time.Time]float64, today time.Time, window ReportWindow) TimeSeriesReportData {
+	data := TimeSeriesReportData{
+		Resource: resource,
+		Today:    today,
+		Window:   window,
+	}
+
+	// collect weekly data
+	for _, date := range window.Days() {
+		data.Weekly = append(data.Weekly, DailyValue{date, lifetimeData[date]})
+		data.WeeklyTotal += lifetimeData[date]
+	}
+
+	for _, date := range window.Previous().Days() {
+		data.PreviousWeeklyTotal += lifetimeData[date]
+	}
+
+	data.WeeklyAverage = data.WeeklyTotal / float64(window.Len())
+	if resource.ValueType == IntValue {
+		data.WeeklyAverage = float64(int(math.Round(data.WeeklyAverage*10) / 10))
+	}
+
+	items := sortDailyValues(lifetimeData)
+	data.YearlyTop, data.LifetimeTop = selectTopDailyValues(items, DEFAULT_TOP_N, today)
+
+	rankWeek(&data, items, lifetimeData)
+
+	return data
+}
+
+func generateTimeSeriesReport(resource TimeSeriesResource, lifetimeData map[time.Time]float64, today time.Time, settings UserSettings) (string, error) {
+	window, err := settings.reportWindow(today)
+	if err != nil {
+		return "", err
+	}
+	return renderTimeSeriesReport(buildTimeSeriesReportData(resource, lifetimeData, today, window), settings)
+}
+
+// lookupExtraTimeSeriesResources returns the resources of EXTRA_TIME_SERIES_RESOURCES.
+func lookupExtraTimeSeriesResources() ([]TimeSeriesResource, error) {
+	var resources []TimeSeriesResource
+	for _, name := range strings.Split(extraTimeSeriesResources, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		resource, err := lookupTimeSeriesResource(name)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+func generateExtraTimeSeriesReports(ctx context.Context, access_token string, today time.Time, settings UserSettings) ([]Report, error) {
+	resources, err := lookupExtraTimeSeriesResources()
+	if err != nil {
+		return nil, err
+	}
+
+	window, err := settings.reportWindow(today)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []Report
+	for _, resource := range resources {
+		lifetimeData, err := getLifetimeTimeSeriesHistory(ctx, access_token, resource, today, getTimeSeriesByDateRange)
+		if err != nil {
+			return nil, err
+		}
+
+		data := buildTimeSeriesReportData(resource, lifetimeData, today, window)
+		if err := applyTopRecords(&data, lifetimeData, settings); err != nil {
+			return nil, err
+		}
+
+		report, err := newTimeSeriesReport(data, settings)
+		if err != nil {
+			return nil, err
+		}
+
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mockGetTimeSeriesByDateRange(ctx context.Context, access_token string, resource TimeSeriesResource, startDate, endDate string) (map[string][]map[string]string, error) {
+	mockData := map[string][]map[string]string{
+		"activities-distance": {
+			{"dateTime": "2024-03-01", "value": "3.25"},
+			{"dateTime": "2024-03-02", "value": "10.5"},
+		},
+		"activities-floors": {
+			{"dateTime": "2024-03-01", "value": "12"},
+			{"dateTime": "2024-03-02", "value": "7"},
+		},
+	}
+	return mockData, nil
+}
+
+func TestGetLifetimeTimeSeriesHistory(t *testing.T) {
+	today := time.Date(2024, time.March, 3, 0, 0, 0, 0, time.Local)
+
+	distance, err := getLifetimeTimeSeriesHistory(context.Background(), "test_token", timeSeriesResources["distance"], today, mockGetTimeSeriesByDateRange)
+	assert.NoError(t, err)
+	assert.Equal(t, map[time.Time]float64{
+		time.Date(2024, time.March, 1, 0, 0, 0, 0, time.Local): 3.25,
+		time.Date(2024, time.March, 2, 0, 0, 0, 0, time.Local): 10.5,
+	}, distance)
+
+	floors, err := getLifetimeTimeSeriesHistory(context.Background(), "test_token", timeSeriesResources["floors"], today, mockGetTimeSeriesByDateRange)
+	assert.NoError(t, err)
+	assert.Equal(t, map[time.Time]float64{
+		time.Date(2024, time.March, 1, 0, 0, 0, 0, time.Local): 12,
+		time.Date(2024, time.March, 2, 0, 0, 0, 0, time.Local): 7,
+	}, floors)
+
+	// float value for an int resource
+	_, err = getLifetimeTimeSeriesHistory(context.Background(), "test_token", TimeSeriesResource{Path: "activities/distance", ValueType: IntValue, LimitDays: LIMIT_DAYS}, today, mockGetTimeSeriesByDateRange)
+	assert.Error(t, err)
+}
+
+func TestGetLifetimeTimeSeriesHistoryLimitDays(t *testing.T) {
+	original := startDateParse
+	defer func() { startDateParse = original }()
+	startDateParse = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local)
+	today := time.Date(2024, time.March, 3, 0, 0, 0, 0, time.Local)
+
+	var ranges [][2]string
+	fetch := func(ctx context.Context, access_token string, resource TimeSeriesResource, startDate, endDate string) (map[string][]map[string]string, error) {
+		ranges = append(ranges, [2]string{startDate, endDate})
+		return nil, nil
+	}
+
+	_, err := getLifetimeTimeSeriesHistory(context.Background(), "test_token", timeSeriesResources["activityCalories"], today, fetch)
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]string{
+		{"2024-02-02", "2024-03-02"},
+		{"2024-01-03", "2024-02-01"},
+		{"2024-01-01", "2024-01-02"},
+	}, ranges)
+
+	ranges = nil
+	_, err = getLifetimeTimeSeriesHistory(context.Background(), "test_token", timeSeriesResources["steps"], today, fetch)
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]string{{"2024-01-01", "2024-03-02"}}, ranges)
+}
+
+func TestLookupTimeSeriesResource(t *testing.T) {
+	resource, err := lookupTimeSeriesResource("minutesVeryActive")
+	assert.NoError(t, err)
+	assert.Equal(t, "activities-minutesVeryActive", resource.responseKey())
+
+	_, err = lookupTimeSeriesResource("unknown")
+	assert.ErrorContains(t, err, UnknownTimeSeriesResource)
+}
+
+func TestGenerateTimeSeriesReport(t *testing.T) {
+	lifetimeData := map[time.Time]float64{
+		time.Date(2023, time.December, 30, 0, 0, 0, 0, time.Local): 20.123,
+		time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local):   1.5,
+		time.Date(2024, time.January, 8, 0, 0, 0, 0, time.Local):   5.555,
+		time.Date(2024, time.January, 10, 0, 0, 0, 0, time.Local):  3,
+	}
+
+	expected := `
+======================
+Weekly Report (Distance)
+
+1/7 Sun 0km
+1/8 Mon 5.56km (P62, #1 of 2024)
+1/9 Tue 0km
+1/10 Wed 3km (P37, #2 of 2024)
+1/11 Thu 0km
+1/12 Fri 0km
+1/13 Sat 0km
+
+Total: 8.56km (#2 of 3 weeks)
+Average: 1.22km
+======================
+Top Records in This Year (Distance)
+
+5.56km(1/8)
+3km(1/10)
+1.5km(1/1)
+======================
+Top Records in Lifetime (Distance)
+
+20.12km(2023/12/30)
+5.56km(2024/1/8)
+3km(2024/1/10)
+1.5km(2024/1/1)
+`
+
+	today := time.Date(2024, time.January, 14, 0, 0, 0, 0, time.Local)
+	actual, err := generateTimeSeriesReport(timeSeriesResources["distance"], lifetimeData, today, UserSettings{})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	TRACK_SOURCE_GPX = "gpx"
+	TRACK_SOURCE_TCX = "tcx"
+
+	EARTH_RADIUS_KM = 6371.0088
+	// slower than this between two points is a pause, e.g. waiting at a crossing
+	MIN_MOVING_SPEED_KMH = 2.0
+	// climbs below this are noise of the GPS or the barometer
+	ELEVATION_GAIN_THRESHOLD = 2.0
+)
+
+// trackSports maps the sports of GPX and TCX files to the activity types of log-activity.
+var trackSports = map[string]string{
+	"running":  "run",
+	"run":      "run",
+	"walking":  "walk",
+	"walk":     "walk",
+	"hiking":   "hike",
+	"biking":   "bike",
+	"cycling":  "bike",
+	"swimming": "swim",
+}
+
+// TrackPoint is a recorded position of a track.
+type TrackPoint struct {
+	Time      time.Time
+	Latitude  float64
+	Longitude float64
+	// m, set when HasElevation
+	Elevation    float64
+	HasElevation bool
+}
+
+// Track is an activity recorded by another app, e.g. a run on a Garmin watch.
+type Track struct {
+	// as in the file, e.g. "running"
+	Sport  string
+	Source string
+	Points []TrackPoint
+}
+
+// TrackStats are computed from the points of a track.
+type TrackStats struct {
+	StartTime   time.Time
+	ElapsedTime time.Duration
+	// without the pauses
+	MovingTime time.Duration
+	// km
+	Distance float64
+	// per km of the moving time
+	Pace time.Duration
+	// m
+	ElevationGain float64
+}
+
+type gpxFile struct {
+	Tracks []struct {
+		Type     string `xml:"type"`
+		Segments []struct {
+			Points []struct {
+				Latitude  float64  `xml:"lat,attr"`
+				Longitude float64  `xml:"lon,attr"`
+				Elevation *float64 `xml:"ele"`
+				Time      string   `xml:"time"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+type tcxFile struct {
+	Activities []struct {
+		Sport string `xml:"Sport,attr"`
+		Laps  []struct {
+			Points []struct {
+				Time     string `xml:"Time"`
+				Position *struct {
+					Latitude  float64 `xml:"LatitudeDegrees"`
+					Longitude float64 `xml:"LongitudeDegrees"`
+				} `xml:"Position"`
+				Altitude *float64 `xml:"AltitudeMeters"`
+			} `xml:"Track>Trackpoint"`
+		} `xml:"Lap"`
+	} `xml:"Activities>Activity"`
+}
+
+// parseGPX reads the tracks of a GPX file, the segments of a track joined.
+func parseGPX(data []byte) ([]Track, error) {
+	var file gpxFile
+	if err := xml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	var tracks []Track
+	for _, trk := range file.Tracks {
+		track := Track{Sport: trk.Type, Source: TRACK_SOURCE_GPX}
+		for _, segment := range trk.Segments {
+			for _, point := range segment.Points {
+				t, err := time.Parse(time.RFC3339, point.Time)
+				if err != nil {
+					return nil, err
+				}
+				trackPoint := TrackPoint{Time: t, Latitude: point.Latitude, Longitude: point.Longitude}
+				if point.Elevation != nil {
+					trackPoint.Elevation, trackPoint.HasElevation = *point.Elevation, true
+				}
+				track.Points = append(track.Points, trackPoint)
+			}
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, nil
+}
+
+// parseTCX reads the activities of a TCX file, the laps of an activity joined. Points without
+// a position, e.g. of a treadmill, are skipped.
+func parseTCX(data []byte) ([]Track, error) {
+	var file tcxFile
+	if err := xml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	var tracks []Track
+	for _, activity := range file.Activities {
+		track := Track{Sport: activity.Sport, Source: TRACK_SOURCE_TCX}
+		for _, lap := range activity.Laps {
+			for _, point := range lap.Points {
+				if point.Position == nil {
+					continue
+				}
+				t, err := time.Parse(time.RFC3339, point.Time)
+				if err != nil {
+					return nil, err
+				}
+				trackPoint := TrackPoint{Time: t, Latitude: point.Position.Latitude, Longitude: point.Position.Longitude}
+				if point.Altitude != nil {
+					trackPoint.Elevation, trackPoint.HasElevation = *point.Altitude, true
+				}
+				track.Points = append(track.Points, trackPoint)
+			}
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, nil
+}
+
+// readTrackFile parses a .gpx or .tcx file by its extension.
+func readTrackFile(name string) ([]Track, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var tracks []Track
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gpx":
+		tracks, err = parseGPX(data)
+	case ".tcx":
+		tracks, err = parseTCX(data)
+	default:
+		return nil, errors.New(UnsupportedTrackFile + ": " + name)
+	}
+	if err != nil {
+		return nil, errors.New(InvalidTrackFile + ": " + name + ": " + err.Error())
+	}
+	return tracks, nil
+}
+
+// haversine returns the great-circle distance between two points in km.
+func haversine(from TrackPoint, to TrackPoint) float64 {
+	lat1 := from.Latitude * math.Pi / 180
+	lat2 := to.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (to.Longitude - from.Longitude) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EARTH_RADIUS_KM * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// computeTrackStats sums up the distance of all the points, and counts the intervals slower than
+// MIN_MOVING_SPEED_KMH as pauses.
+func computeTrackStats(points []TrackPoint) TrackStats {
+	stats := TrackStats{}
+	if len(points) == 0 {
+		return stats
+	}
+	stats.StartTime = points[0].Time
+	stats.ElapsedTime = points[len(points)-1].Time.Sub(points[0].Time)
+
+	// the elevation the next climb is measured from
+	var base *TrackPoint
+	for i := range points {
+		point := points[i]
+		if point.HasElevation {
+			switch {
+			case base == nil || point.Elevation < base.Elevation:
+				base = &points[i]
+			case point.Elevation-base.Elevation >= ELEVATION_GAIN_THRESHOLD:
+				stats.ElevationGain += point.Elevation - base.Elevation
+				base = &points[i]
+			}
+		}
+
+		if i == 0 {
+			continue
+		}
+		distance := haversine(points[i-1], point)
+		stats.Distance += distance
+		interval := point.Time.Sub(points[i-1].Time)
+		if interval > 0 && distance/interval.Hours() >= MIN_MOVING_SPEED_KMH {
+			stats.MovingTime += interval
+		}
+	}
+
+	if stats.Distance > 0 {
+		stats.Pace = time.Duration(float64(stats.MovingTime) / stats.Distance).Round(time.Second)
+	}
+	return stats
+}
+
+// activityType returns the type of the sport of the track. A sport not in trackSports, e.g.
+// "Other" of TCX or the numeric types of Strava, is an error, so that the running report does not
+// count it as a run.
+func (track Track) activityType() (ActivityType, error) {
+	// most apps leave the sport of runs unset
+	if track.Sport == "" {
+		return lookupActivityType("run")
+	}
+	sport, ok := trackSports[strings.ToLower(track.Sport)]
+	if !ok {
+		return ActivityType{}, errors.New(UnknownTrackSport + ": " + track.Sport)
+	}
+	return lookupActivityType(sport)
+}
+
+// record converts the track to an activity of the history. The log ID is the negative start
+// time, so it never clashes with a Fitbit log and importing a file again replaces the record.
+func (track Track) record() (ActivityRecord, TrackStats, error) {
+	activityType, err := track.activityType()
+	if err != nil {
+		return ActivityRecord{}, TrackStats{}, err
+	}
+
+	stats := computeTrackStats(track.Points)
+	if stats.StartTime.IsZero() {
+		return ActivityRecord{}, stats, errors.New(EmptyTrack)
+	}
+	return ActivityRecord{
+		LogID:     -stats.StartTime.Unix(),
+		Name:      activityType.Name,
+		StartTime: stats.StartTime.In(time.Local),
+		Duration:  stats.ElapsedTime,
+		Distance:  stats.Distance,
+		Source:    track.Source,
+	}, stats, nil
+}
+
+// overlapActivities reports whether two activities of the same type were recorded at the same
+// time, e.g. by a Fitbit tracker and a Garmin watch worn together.
+func overlapActivities(a ActivityRecord, b ActivityRecord) bool {
+	if a.Name != b.Name {
+		return false
+	}
+	// activities without a duration overlap when they start at about the same time
+	aEnd := a.StartTime.Add(max(a.Duration, DUPLICATE_ACTIVITY_TOLERANCE))
+	bEnd := b.StartTime.Add(max(b.Duration, DUPLICATE_ACTIVITY_TOLERANCE))
+	return a.StartTime.Before(bEnd) && b.StartTime.Before(aEnd)
+}
+
+func overlapsAny(record ActivityRecord, others []ActivityRecord) bool {
+	for _, other := range others {
+		if overlapActivities(record, other) {
+			return true
+		}
+	}
+	return false
+}
+
+// importTracks adds the tracks to the history, except the ones overlapping a Fitbit activity,
+// a stored activity or another track.
+func importTracks(ctx context.Context, history HistoryStore, records []ActivityRecord, fitbitRecords []ActivityRecord) (importSummary, error) {
+	summary := importSummary{}
+
+	storedRecords, err := history.GetActivities(ctx, time.Time{}, time.Now().AddDate(1, 0, 0))
+	if err != nil {
+		return summary, err
+	}
+	known := append(append([]ActivityRecord(nil), fitbitRecords...), storedRecords...)
+
+	var activities []ActivityRecord
+	for _, record := range records {
+		// a track imported before has the same log ID and is replaced, while the same run exported
+		// in another format and imported before has a different one
+		var others []ActivityRecord
+		for _, other := range known {
+			if other.LogID != record.LogID {
+				others = append(others, other)
+			}
+		}
+		if overlapsAny(record, others) || overlapsAny(record, activities) {
+			summary.SkippedActivities += 1
+			continue
+		}
+		activities = append(activities, record)
+	}
+	summary.Activities = len(activities)
+	if err := history.PutActivities(ctx, activities); err != nil {
+		return summary, err
+	}
+	return summary, nil
+}
+
+// runImportTracks imports GPX and TCX files of other apps, e.g.
+// `import-tracks morning-run.gpx 2024-01-06.tcx`, so that the running report includes them.
+func runImportTracks(args []string) error {
+	flags := flag.NewFlagSet("import-tracks", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New(MissingTrackFile)
+	}
+
+	ctx := context.TODO()
+	history, err := newHistoryStore(ctx)
+	if err != nil {
+		return err
+	}
+	if history == nil {
+		return errors.New(MissingHistoryStore)
+	}
+
+	var records []ActivityRecord
+	for _, name := range flags.Args() {
+		tracks, err := readTrackFile(name)
+		if err != nil {
+			return err
+		}
+		for _, track := range tracks {
+			if _, err := track.activityType(); err != nil {
+				log.Printf("%s: skipped: %v", name, err)
+				continue
+			}
+			record, stats, err := track.record()
+			if err != nil {
+				return fmt.Errorf("failed to import %s: %v", name, err)
+			}
+			log.Printf("%s: %s at %s, %.2fkm in %s (moving %s, %s/km), %.0fm gain", name, record.Name,
+				record.StartTime.Format(LOG_ACTIVITY_TIME_FORMAT), stats.Distance, stats.ElapsedTime, stats.MovingTime, stats.Pace, stats.ElevationGain)
+			records = append(records, record)
+		}
+	}
+
+	instances, err := newInstances(ctx)
+	if err != nil {
+		return err
+	}
+	accessToken, err := instances.getAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the activities of this year, the ones of the years before are in the history if backfilled
+	activityList, err := getActivityList(ctx, *accessToken, time.Now())
+	if err != nil {
+		return err
+	}
+	fitbitRecords, err := activityRecordsFromList(activityList)
+	if err != nil {
+		return err
+	}
+
+	summary, err := importTracks(ctx, history, records, fitbitRecords)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("imported %d activities (%d skipped)", summary.Activities, summary.SkippedActivities)
+	return nil
+}
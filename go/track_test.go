@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="Garmin Connect" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk>
+    <name>Morning Run</name>
+    <type>running</type>
+    <trkseg>
+      <trkpt lat="35.000" lon="139.000"><ele>10</ele><time>2024-01-06T07:00:00Z</time></trkpt>
+      <trkpt lat="35.001" lon="139.000"><ele>11</ele><time>2024-01-06T07:00:30Z</time></trkpt>
+    </trkseg>
+    <trkseg>
+      <trkpt lat="35.002" lon="139.000"><ele>13</ele><time>2024-01-06T07:01:00Z</time></trkpt>
+      <trkpt lat="35.002" lon="139.000"><ele>12.5</ele><time>2024-01-06T07:02:00Z</time></trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+
+const testTCX = `<?xml version="1.0" encoding="UTF-8"?>
+<TrainingCenterDatabase xmlns="http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2">
+  <Activities>
+    <Activity Sport="Biking">
+      <Id>2024-01-07T08:00:00Z</Id>
+      <Lap StartTime="2024-01-07T08:00:00Z">
+        <Track>
+          <Trackpoint><Time>2024-01-07T08:00:00Z</Time></Trackpoint>
+          <Trackpoint>
+            <Time>2024-01-07T08:00:10Z</Time>
+            <Position><LatitudeDegrees>35.000</LatitudeDegrees><LongitudeDegrees>139.000</LongitudeDegrees></Position>
+            <AltitudeMeters>20</AltitudeMeters>
+          </Trackpoint>
+          <Trackpoint>
+            <Time>2024-01-07T08:01:10Z</Time>
+            <Position><LatitudeDegrees>35.000</LatitudeDegrees><LongitudeDegrees>139.010</LongitudeDegrees></Position>
+          </Trackpoint>
+        </Track>
+      </Lap>
+    </Activity>
+  </Activities>
+</TrainingCenterDatabase>`
+
+func TestParseGPX(t *testing.T) {
+	tracks, err := parseGPX([]byte(testGPX))
+	assert.NoError(t, err)
+	assert.Len(t, tracks, 1)
+	assert.Equal(t, "running", tracks[0].Sport)
+	assert.Equal(t, TRACK_SOURCE_GPX, tracks[0].Source)
+	// the segments are joined
+	assert.Len(t, tracks[0].Points, 4)
+	assert.Equal(t, TrackPoint{Time: time.Date(2024, time.January, 6, 7, 0, 30, 0, time.UTC), Latitude: 35.001, Longitude: 139, Elevation: 11, HasElevation: true}, tracks[0].Points[1])
+}
+
+func TestParseTCX(t *testing.T) {
+	tracks, err := parseTCX([]byte(testTCX))
+	assert.NoError(t, err)
+	assert.Len(t, tracks, 1)
+	assert.Equal(t, "Biking", tracks[0].Sport)
+	// the point without a position is skipped
+	assert.Equal(t, []TrackPoint{
+		{Time: time.Date(2024, time.January, 7, 8, 0, 10, 0, time.UTC), Latitude: 35, Longitude: 139, Elevation: 20, HasElevation: true},
+		{Time: time.Date(2024, time.January, 7, 8, 1, 10, 0, time.UTC), Latitude: 35, Longitude: 139.01},
+	}, tracks[0].Points)
+}
+
+func TestReadTrackFile(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "run.GPX"), []byte(testGPX), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "run.fit"), []byte{}, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "broken.tcx"), []byte("<TrainingCenterDatabase>"), 0644))
+
+	tracks, err := readTrackFile(filepath.Join(dir, "run.GPX"))
+	assert.NoError(t, err)
+	assert.Len(t, tracks, 1)
+
+	_, err = readTrackFile(filepath.Join(dir, "run.fit"))
+	assert.ErrorContains(t, err, UnsupportedTrackFile)
+	_, err = readTrackFile(filepath.Join(dir, "broken.tcx"))
+	assert.ErrorContains(t, err, InvalidTrackFile)
+}
+
+func TestHaversine(t *testing.T) {
+	// a degree of a meridian and of the equator
+	origin := TrackPoint{Latitude: 0, Longitude: 0}
+	assert.InDelta(t, 111.195, haversine(origin, TrackPoint{Latitude: 1, Longitude: 0}), 0.001)
+	assert.InDelta(t, 111.195, haversine(origin, TrackPoint{Latitude: 0, Longitude: -1}), 0.001)
+	// a degree of longitude shrinks with the latitude
+	assert.InDelta(t, 111.195/2, haversine(TrackPoint{Latitude: 60, Longitude: 0}, TrackPoint{Latitude: 60, Longitude: 1}), 0.01)
+	assert.Equal(t, 0.0, haversine(origin, origin))
+}
+
+func TestComputeTrackStats(t *testing.T) {
+	tracks, err := parseGPX([]byte(testGPX))
+	assert.NoError(t, err)
+
+	stats := computeTrackStats(tracks[0].Points)
+	assert.Equal(t, time.Date(2024, time.January, 6, 7, 0, 0, 0, time.UTC), stats.StartTime)
+	assert.Equal(t, 2*time.Minute, stats.ElapsedTime)
+	// the last minute is a pause
+	assert.Equal(t, time.Minute, stats.MovingTime)
+	assert.InDelta(t, 0.2224, stats.Distance, 0.0001)
+	assert.Equal(t, 4*time.Minute+30*time.Second, stats.Pace)
+	// the climb of 1m is below the threshold until it reaches 13m
+	assert.Equal(t, 3.0, stats.ElevationGain)
+
+	assert.Equal(t, TrackStats{}, computeTrackStats(nil))
+}
+
+func TestTrackRecord(t *testing.T) {
+	tracks, err := parseGPX([]byte(testGPX))
+	assert.NoError(t, err)
+
+	record, _, err := tracks[0].record()
+	assert.NoError(t, err)
+	assert.Equal(t, "Run", record.Name)
+	assert.Equal(t, TRACK_SOURCE_GPX, record.Source)
+	assert.Equal(t, -time.Date(2024, time.January, 6, 7, 0, 0, 0, time.UTC).Unix(), record.LogID)
+	assert.Equal(t, 2*time.Minute, record.Duration)
+
+	tracks, err = parseTCX([]byte(testTCX))
+	assert.NoError(t, err)
+	record, _, err = tracks[0].record()
+	assert.NoError(t, err)
+	assert.Equal(t, "Bike", record.Name)
+
+	// runs when the sport is unset
+	record, _, err = Track{Points: tracks[0].Points}.record()
+	assert.NoError(t, err)
+	assert.Equal(t, "Run", record.Name)
+
+	// not a run when the sport is unknown, e.g. a ride of Strava
+	_, _, err = Track{Sport: "Other", Points: tracks[0].Points}.record()
+	assert.EqualError(t, err, UnknownTrackSport+": Other")
+	_, _, err = Track{Sport: "1", Points: tracks[0].Points}.record()
+	assert.EqualError(t, err, UnknownTrackSport+": 1")
+
+	_, _, err = Track{Sport: "running"}.record()
+	assert.EqualError(t, err, EmptyTrack)
+}
+
+func TestOverlapActivities(t *testing.T) {
+	start := time.Date(2024, time.January, 6, 7, 0, 0, 0, time.Local)
+	run := ActivityRecord{Name: "Run", StartTime: start, Duration: 30 * time.Minute}
+
+	assert.True(t, overlapActivities(run, ActivityRecord{Name: "Run", StartTime: start.Add(20 * time.Minute), Duration: 30 * time.Minute}))
+	assert.True(t, overlapActivities(run, ActivityRecord{Name: "Run", StartTime: start.Add(-5 * time.Minute)}))
+	assert.False(t, overlapActivities(run, ActivityRecord{Name: "Run", StartTime: start.Add(30 * time.Minute), Duration: 30 * time.Minute}))
+	assert.False(t, overlapActivities(run, ActivityRecord{Name: "Walk", StartTime: start, Duration: 30 * time.Minute}))
+}
+
+func TestImportTracks(t *testing.T) {
+	ctx := context.Background()
+	history := &FileHistoryStore{Path: filepath.Join(t.TempDir(), "history.json")}
+	start := time.Date(2024, time.January, 6, 7, 0, 0, 0, time.Local)
+
+	stored := ActivityRecord{LogID: 1, Name: "Run", StartTime: start.AddDate(0, 0, -1), Duration: 30 * time.Minute, Distance: 5, Source: "tracker"}
+	assert.NoError(t, history.PutActivities(ctx, []ActivityRecord{stored}))
+	fitbit := ActivityRecord{LogID: 2, Name: "Run", StartTime: start.AddDate(0, 0, 1).Add(2 * time.Minute), Duration: 30 * time.Minute, Distance: 5}
+
+	newRun := ActivityRecord{LogID: -start.Unix(), Name: "Run", StartTime: start, Duration: 40 * time.Minute, Distance: 7, Source: TRACK_SOURCE_GPX}
+	// the same run exported as TCX too
+	sameRun := ActivityRecord{LogID: -start.Add(time.Minute).Unix(), Name: "Run", StartTime: start.Add(time.Minute), Duration: 38 * time.Minute, Distance: 7, Source: TRACK_SOURCE_TCX}
+	storedRun := ActivityRecord{LogID: -start.AddDate(0, 0, -1).Unix(), Name: "Run", StartTime: start.AddDate(0, 0, -1), Duration: 30 * time.Minute, Distance: 5, Source: TRACK_SOURCE_GPX}
+	fitbitRun := ActivityRecord{LogID: -start.AddDate(0, 0, 1).Unix(), Name: "Run", StartTime: start.AddDate(0, 0, 1), Duration: 30 * time.Minute, Distance: 5, Source: TRACK_SOURCE_GPX}
+
+	summary, err := importTracks(ctx, history, []ActivityRecord{newRun, sameRun, storedRun, fitbitRun}, []ActivityRecord{fitbit})
+	assert.NoError(t, err)
+	assert.Equal(t, importSummary{Activities: 1, SkippedActivities: 3}, summary)
+
+	// importing the file again replaces the track
+	summary, err = importTracks(ctx, history, []ActivityRecord{newRun}, []ActivityRecord{fitbit})
+	assert.NoError(t, err)
+	assert.Equal(t, importSummary{Activities: 1}, summary)
+
+	// the other format of the run imported separately
+	summary, err = importTracks(ctx, history, []ActivityRecord{sameRun}, []ActivityRecord{fitbit})
+	assert.NoError(t, err)
+	assert.Equal(t, importSummary{SkippedActivities: 1}, summary)
+
+	activities, err := history.GetActivities(ctx, time.Time{}, start.AddDate(0, 0, 10))
+	assert.NoError(t, err)
+	assert.Len(t, activities, 2)
+
+	// merged into the running log of the weekly report
+	yearlyRunningLog := map[time.Time]float64{}
+	assert.NoError(t, mergeHistoryRuns(ctx, history, yearlyRunningLog, start.AddDate(0, 0, 10)))
+	assert.Equal(t, map[int64]float64{stored.StartTime.Unix(): 5, newRun.StartTime.Unix(): 7}, runningLogByUnix(yearlyRunningLog))
+
+	// the run was synced to Fitbit after the import
+	yearlyRunningLog = map[time.Time]float64{start.Add(3 * time.Minute): 7.1}
+	assert.NoError(t, mergeHistoryRuns(ctx, history, yearlyRunningLog, start.AddDate(0, 0, 10)))
+	assert.Equal(t, map[int64]float64{start.Add(3 * time.Minute).Unix(): 7.1, stored.StartTime.Unix(): 5}, runningLogByUnix(yearlyRunningLog))
+}
+
+func TestMergeHistoryRunsOverlap(t *testing.T) {
+	ctx := context.Background()
+	history := &FileHistoryStore{Path: filepath.Join(t.TempDir(), "history.json")}
+	start := time.Date(2024, time.January, 6, 7, 0, 0, 0, time.Local)
+
+	// the GPX and the TCX of a run stored before the overlap was checked on import
+	gpxRun := ActivityRecord{LogID: -start.Unix(), Name: "Run", StartTime: start, Duration: 40 * time.Minute, Distance: 7, Source: TRACK_SOURCE_GPX}
+	tcxRun := ActivityRecord{LogID: -start.Add(15 * time.Minute).Unix(), Name: "Run", StartTime: start.Add(15 * time.Minute), Duration: 25 * time.Minute, Distance: 4, Source: TRACK_SOURCE_TCX}
+	laterRun := ActivityRecord{LogID: -start.Add(time.Hour).Unix(), Name: "Run", StartTime: start.Add(time.Hour), Duration: 20 * time.Minute, Distance: 3, Source: TRACK_SOURCE_GPX}
+	assert.NoError(t, history.PutActivities(ctx, []ActivityRecord{gpxRun, tcxRun, laterRun}))
+
+	yearlyRunningLog := map[time.Time]float64{}
+	assert.NoError(t, mergeHistoryRuns(ctx, history, yearlyRunningLog, start.AddDate(0, 0, 10)))
+	assert.Equal(t, map[int64]float64{gpxRun.StartTime.Unix(): 7, laterRun.StartTime.Unix(): 3}, runningLogByUnix(yearlyRunningLog))
+
+	runningLog, err := getHistoryRunningLog(ctx, history, map[time.Time]float64{}, start.AddDate(1, 0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]float64{gpxRun.StartTime.Unix(): 7, laterRun.StartTime.Unix(): 3}, runningLogByUnix(runningLog))
+}
+
+// runningLogByUnix keys the log by the Unix time, as the zones of the stored times differ.
+func runningLogByUnix(runningLog map[time.Time]float64) map[int64]float64 {
+	byUnix := map[int64]float64{}
+	for startTime, distance := range runningLog {
+		byUnix[startTime.Unix()] = distance
+	}
+	return byUnix
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"math"
 	"strconv"
+	"strings"
 
 	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
 )
@@ -13,19 +15,30 @@ func roundToDecimal(num float64) float64 {
 }
 
 func formatNumberWithComma(number int) string {
+	return formatNumberWithSeparator(number, ",")
+}
+
+func formatNumberWithSeparator(number int, separator string) string {
+	sign := ""
+	if number < 0 {
+		sign = "-"
+	}
 	str := strconv.FormatInt(int64(number), 10)
+	str = strings.TrimPrefix(str, "-")
 	result := ""
 	for i := len(str); i > 0; i -= 3 {
 		if i-3 > 0 {
-			result = "," + str[i-3:i] + result
+			result = separator + str[i-3:i] + result
 		} else {
 			result = str[:i] + result
 		}
 	}
-	return result
+	return sign + result
 }
 
-func sendReports(token string, userID string, reports []string) error {
+// sendReports pushes the reports to the user of key. When ledger is not nil, reports
+// already delivered for the period of key are not sent again.
+func sendReports(ctx context.Context, token string, key DeliveryKey, reports []Report, ledger *DeliveryLedger) error {
 	bot, err := messaging_api.NewMessagingApiAPI(
 		token,
 	)
@@ -34,22 +47,12 @@ func sendReports(token string, userID string, reports []string) error {
 	}
 
 	var messageInterfaces []messaging_api.MessageInterface
-	for _, msg := range reports {
-		messageInterfaces = append(messageInterfaces, messaging_api.TextMessage{
-			Text: msg,
-		})
+	for _, report := range reports {
+		messageInterfaces = append(messageInterfaces, reportMessages(report)...)
 	}
 
-	_, err = bot.PushMessage(
-		&messaging_api.PushMessageRequest{
-			To:       userID,
-			Messages: messageInterfaces,
-		},
-		"",
-	)
-	if err != nil {
-		return err
+	if ledger == nil {
+		return pushMessages(bot, key.UserID, messageInterfaces)
 	}
-
-	return nil
+	return ledger.deliver(ctx, bot, key, messageInterfaces)
 }
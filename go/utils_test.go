@@ -29,6 +29,8 @@ func TestFormatNumberWithComma(t *testing.T) {
 		{123456, "123,456"},
 		{1234567, "1,234,567"},
 		{1234567890, "1,234,567,890"},
+		{-123, "-123"},
+		{-1234, "-1,234"},
 	}
 
 	for _, test := range tests {
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+const (
+	COMMAND_TODAY   = "today"
+	COMMAND_WEEK    = "week"
+	COMMAND_YEAR    = "year"
+	COMMAND_RUN     = "run"
+	COMMAND_RECORDS = "records"
+	COMMAND_HELP    = "help"
+	// replies with the link of the dashboard when DASHBOARD_URL is set
+	COMMAND_DASHBOARD = "dashboard"
+
+	LINE_MAX_MESSAGES_PER_REPLY = 5
+	DEFAULT_WEBHOOK_ADDR        = ":8080"
+)
+
+// commandAliases maps the text of a message to a command.
+var commandAliases = map[string]string{
+	COMMAND_TODAY:     COMMAND_TODAY,
+	COMMAND_WEEK:      COMMAND_WEEK,
+	COMMAND_YEAR:      COMMAND_YEAR,
+	COMMAND_RUN:       COMMAND_RUN,
+	COMMAND_RECORDS:   COMMAND_RECORDS,
+	COMMAND_HELP:      COMMAND_HELP,
+	COMMAND_DASHBOARD: COMMAND_DASHBOARD,
+	"今日":              COMMAND_TODAY,
+	"今週":              COMMAND_WEEK,
+	"週":               COMMAND_WEEK,
+	"今年":              COMMAND_YEAR,
+	"年":               COMMAND_YEAR,
+	"ラン":              COMMAND_RUN,
+	"ランニング":           COMMAND_RUN,
+	"記録":              COMMAND_RECORDS,
+	"ヘルプ":             COMMAND_HELP,
+	"ダッシュボード":         COMMAND_DASHBOARD,
+}
+
+// parseCommand returns the command of a message. Unknown messages are answered with the help.
+func parseCommand(text string) string {
+	command, ok := commandAliases[strings.ToLower(strings.TrimSpace(text))]
+	if !ok {
+		return COMMAND_HELP
+	}
+	return command
+}
+
+type messageReplier interface {
+	ReplyMessage(replyMessageRequest *messaging_api.ReplyMessageRequest) (*messaging_api.ReplyMessageResponse, error)
+}
+
+// WebhookServer answers the commands sent to the LINE channel with reports.
+type WebhookServer struct {
+	ChannelSecret string
+	Replier       messageReplier
+	// only these LINE users get reports, as the data is of a single Fitbit account
+	AllowedUserIDs []string
+	Respond        func(ctx context.Context, userID string, command string) ([]Report, error)
+}
+
+func (server *WebhookServer) allowed(userID string) bool {
+	for _, allowedUserID := range server.AllowedUserIDs {
+		if userID == allowedUserID {
+			return true
+		}
+	}
+	return false
+}
+
+func (server *WebhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	callbackRequest, err := webhook.ParseRequest(server.ChannelSecret, r)
+	if errors.Is(err, webhook.ErrInvalidSignature) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	for _, event := range callbackRequest.Events {
+		messageEvent, ok := event.(webhook.MessageEvent)
+		if !ok {
+			continue
+		}
+		message, ok := messageEvent.Message.(webhook.TextMessageContent)
+		if !ok {
+			continue
+		}
+		source, ok := messageEvent.Source.(webhook.UserSource)
+		if !ok || !server.allowed(source.UserId) {
+			continue
+		}
+
+		// LINE does not redeliver events answered with an error, so failures are only logged
+		if err := server.reply(r.Context(), messageEvent.ReplyToken, source.UserId, parseCommand(message.Text)); err != nil {
+			log.Printf("failed to reply to %s: %v", source.UserId, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (server *WebhookServer) reply(ctx context.Context, replyToken string, userID string, command string) error {
+	reports, err := server.Respond(ctx, userID, command)
+	if err != nil {
+		log.Printf("failed to create the report of %s: %v", command, err)
+		reports, err = commandFailedReports(userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	var messages []messaging_api.MessageInterface
+	for _, report := range reports {
+		messages = append(messages, reportMessages(report)...)
+	}
+	if len(messages) > LINE_MAX_MESSAGES_PER_REPLY {
+		messages = messages[:LINE_MAX_MESSAGES_PER_REPLY]
+	}
+
+	_, err = server.Replier.ReplyMessage(&messaging_api.ReplyMessageRequest{
+		ReplyToken: replyToken,
+		Messages:   messages,
+	})
+	return err
+}
+
+func commandFailedReports(userID string) ([]Report, error) {
+	settings, err := loadUserSettings(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	locale, err := lookupLocale(settings.Locale)
+	if err != nil {
+		return nil, err
+	}
+
+	return []Report{{Text: locale.message("commandFailed")}}, nil
+}
+
+// functionURLResponseWriter collects the response of the server for a Lambda function URL.
+type functionURLResponseWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *functionURLResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *functionURLResponseWriter) Write(data []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.body.Write(data)
+}
+
+func (w *functionURLResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+// response returns the collected response. A handler which wrote nothing responded with 200.
+func (w *functionURLResponseWriter) response() events.LambdaFunctionURLResponse {
+	statusCode := w.statusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	headers := map[string]string{}
+	for name := range w.header {
+		headers[name] = w.header.Get(name)
+	}
+	return events.LambdaFunctionURLResponse{
+		StatusCode: statusCode,
+		Headers:    headers,
+		Body:       w.body.String(),
+	}
+}
+
+// handleFunctionURL adapts the server to a Lambda function URL.
+func (server *WebhookServer) handleFunctionURL(ctx context.Context, request events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	body := request.Body
+	if request.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return events.LambdaFunctionURLResponse{StatusCode: http.StatusBadRequest}, nil
+		}
+		body = string(decoded)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, request.RequestContext.HTTP.Method, request.RawPath, strings.NewReader(body))
+	if err != nil {
+		return events.LambdaFunctionURLResponse{}, err
+	}
+	for name, value := range request.Headers {
+		r.Header.Set(name, value)
+	}
+
+	w := &functionURLResponseWriter{header: http.Header{}}
+	server.ServeHTTP(w, r)
+	return w.response(), nil
+}
+
+// reportResponder creates the reports replied to the commands.
+type reportResponder struct {
+	getAccessToken  func(ctx context.Context) (string, error)
+	fetchTimeSeries timeSeriesFetchFunc
+	getActivityList func(ctx context.Context, access_token string, today time.Time) ([]interface{}, error)
+	// nil when the dashboard is not served
+	dashboardURL func(userID string) string
+}
+
+func (responder *reportResponder) respond(ctx context.Context, userID string, command string) ([]Report, error) {
+	settings, err := loadUserSettings(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if command == COMMAND_HELP || command == COMMAND_DASHBOARD {
+		locale, err := lookupLocale(settings.Locale)
+		if err != nil {
+			return nil, err
+		}
+		if command == COMMAND_DASHBOARD && responder.dashboardURL != nil {
+			return []Report{{Text: locale.message("dashboardLink") + "\n" + responder.dashboardURL(userID)}}, nil
+		}
+		return []Report{{Text: locale.message("help")}}, nil
+	}
+
+	accessToken, err := responder.getAccessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	today := time.Now().Local()
+	window, err := settings.reportWindow(today)
+	if err != nil {
+		return nil, err
+	}
+
+	switch command {
+	case COMMAND_TODAY:
+		steps, err := getDailyValue(ctx, accessToken, stepsResource, today, responder.fetchTimeSeries)
+		if err != nil {
+			return nil, err
+		}
+
+		text, err := renderTodayReport(buildTodayReportData(steps, today, settings.stepsGoal()), settings)
+		if err != nil {
+			return nil, err
+		}
+		return []Report{{Text: text}}, nil
+
+	case COMMAND_RUN:
+		runningReportData, err := responder.runningReportData(ctx, accessToken, today, window)
+		if err != nil {
+			return nil, err
+		}
+
+		report, err := newRunningReport(runningReportData, settings)
+		if err != nil {
+			return nil, err
+		}
+		return []Report{report}, nil
+	}
+
+	lifetimeData, err := getLifetimeTimeSeriesHistory(ctx, accessToken, stepsResource, today, responder.fetchTimeSeries)
+	if err != nil {
+		return nil, err
+	}
+	stepsReportData := buildTimeSeriesReportData(stepsResource, lifetimeData, today, window)
+	if err := applyTopRecords(&stepsReportData, lifetimeData, settings); err != nil {
+		return nil, err
+	}
+
+	var stepsSection, runningSection string
+	switch command {
+	case COMMAND_WEEK:
+		stepsSection, runningSection = "weekly", "weekly"
+	case COMMAND_YEAR:
+		stepsSection, runningSection = "yearlyTop", "total"
+	default:
+		stepsSection = "lifetimeTop"
+	}
+
+	text, err := renderTimeSeriesSection(stepsReportData, settings, stepsSection)
+	if err != nil {
+		return nil, err
+	}
+	reports := []Report{{Text: text}}
+
+	if command == COMMAND_YEAR && settings.Heatmap {
+		stepsReportData.Heatmap = buildHeatmap(lifetimeData, today, settings.stepsGoal())
+		text, err := renderTimeSeriesSection(stepsReportData, settings, "heatmap")
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, Report{Text: text})
+	}
+
+	if runningSection != "" {
+		runningReportData, err := responder.runningReportData(ctx, accessToken, today, window)
+		if err != nil {
+			return nil, err
+		}
+
+		text, err := renderRunningSection(runningReportData, settings, runningSection)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, Report{Text: text})
+	}
+
+	return reports, nil
+}
+
+func (responder *reportResponder) runningReportData(ctx context.Context, accessToken string, today time.Time, window ReportWindow) (RunningReportData, error) {
+	activityList, err := responder.getActivityList(ctx, accessToken, today)
+	if err != nil {
+		return RunningReportData{}, err
+	}
+
+	yearlyRunningLog, err := extractRunningLog(activityList, today)
+	if err != nil {
+		return RunningReportData{}, err
+	}
+
+	return buildRunningReportData(yearlyRunningLog, today, window), nil
+}
+
+// newWebhookServer creates the server. tokens is shared with the other users of the Fitbit
+// account in the process, e.g. the scheduled runs of serve; the server has its own when nil.
+func newWebhookServer(ctx context.Context, tokens *accessTokenCache) (*WebhookServer, error) {
+	instances, err := newInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lineChannelSecret, err := instances.getParameter(os.Getenv("LINE_CHANNEL_SECRET_PARAMETER_NAME"))
+	if err != nil {
+		return nil, err
+	}
+	lineChannelToken, err := instances.getParameter(os.Getenv("LINE_CHANNEL_TOKEN_PARAMETER_NAME"))
+	if err != nil {
+		return nil, err
+	}
+	lineUserId, err := instances.getParameter(os.Getenv("LINE_USER_ID_PARAMETER_NAME"))
+	if err != nil {
+		return nil, err
+	}
+
+	bot, err := messaging_api.NewMessagingApiAPI(*lineChannelToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if tokens == nil {
+		tokens = &accessTokenCache{fetch: instances.getAccessToken}
+	}
+	responder := &reportResponder{
+		getAccessToken:  tokens.get,
+		fetchTimeSeries: getTimeSeriesByDateRange,
+		getActivityList: getActivityList,
+	}
+
+	if dashboardBaseURL := os.Getenv("DASHBOARD_URL"); dashboardBaseURL != "" {
+		dashboardSecret, err := instances.getParameter(os.Getenv("DASHBOARD_SECRET_PARAMETER_NAME"))
+		if err != nil {
+			return nil, err
+		}
+		responder.dashboardURL = func(userID string) string {
+			return dashboardLink(dashboardBaseURL, *dashboardSecret, userID, time.Now())
+		}
+	}
+
+	return &WebhookServer{
+		ChannelSecret:  *lineChannelSecret,
+		Replier:        bot,
+		AllowedUserIDs: parseUserIDs(*lineUserId),
+		Respond:        responder.respond,
+	}, nil
+}
+
+// runWebhook serves the webhook as a Lambda function URL when running on Lambda, otherwise as a local server.
+func runWebhook(args []string) error {
+	flags := flag.NewFlagSet("webhook", flag.ContinueOnError)
+	addr := flags.String("addr", DEFAULT_WEBHOOK_ADDR, "address to listen on")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	server, err := newWebhookServer(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		lambda.Start(server.handleFunctionURL)
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", server)
+	if err := mountBlobStore(mux); err != nil {
+		return err
+	}
+	return http.ListenAndServe(*addr, mux)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/stretchr/testify/assert"
+)
+
+const testChannelSecret = "secret"
+
+type mockReplier struct {
+	requests []*messaging_api.ReplyMessageRequest
+}
+
+func (m *mockReplier) ReplyMessage(replyMessageRequest *messaging_api.ReplyMessageRequest) (*messaging_api.ReplyMessageResponse, error) {
+	m.requests = append(m.requests, replyMessageRequest)
+	return &messaging_api.ReplyMessageResponse{}, nil
+}
+
+func sign(body string) string {
+	mac := hmac.New(sha256.New, []byte(testChannelSecret))
+	mac.Write([]byte(body))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func textMessageEvent(userID string, text string) string {
+	return `{"destination":"D","events":[{"type":"message","mode":"active","timestamp":0,"webhookEventId":"E","deliveryContext":{"isRedelivery":false},` +
+		`"replyToken":"R","source":{"type":"user","userId":"` + userID + `"},"message":{"type":"text","id":"1","quoteToken":"Q","text":"` + text + `"}}]}`
+}
+
+func newTestWebhookServer(replier messageReplier, respond func(context.Context, string, string) ([]Report, error)) *WebhookServer {
+	return &WebhookServer{
+		ChannelSecret:  testChannelSecret,
+		Replier:        replier,
+		AllowedUserIDs: []string{"U1"},
+		Respond:        respond,
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	assert.Equal(t, COMMAND_TODAY, parseCommand(" Today "))
+	assert.Equal(t, COMMAND_WEEK, parseCommand("今週"))
+	assert.Equal(t, COMMAND_RECORDS, parseCommand("記録"))
+	assert.Equal(t, COMMAND_HELP, parseCommand("hello"))
+}
+
+func TestWebhookServer(t *testing.T) {
+	replier := &mockReplier{}
+	var commands []string
+	server := newTestWebhookServer(replier, func(ctx context.Context, userID string, command string) ([]Report, error) {
+		commands = append(commands, command)
+		return []Report{{Text: "report", ImageURLs: []string{"1", "2", "3", "4", "5"}}}, nil
+	})
+
+	body := textMessageEvent("U1", "week")
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("X-Line-Signature", sign(body))
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{COMMAND_WEEK}, commands)
+	assert.Len(t, replier.requests, 1)
+	assert.Equal(t, "R", replier.requests[0].ReplyToken)
+	// capped at the number of messages a reply can have
+	assert.Len(t, replier.requests[0].Messages, LINE_MAX_MESSAGES_PER_REPLY)
+	assert.Equal(t, "report", replier.requests[0].Messages[0].(messaging_api.TextMessage).Text)
+
+	// messages from other users are ignored
+	body = textMessageEvent("U2", "week")
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("X-Line-Signature", sign(body))
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, commands, 1)
+
+	// invalid signature
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("X-Line-Signature", sign("other"))
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestWebhookServerRespondError(t *testing.T) {
+	replier := &mockReplier{}
+	server := newTestWebhookServer(replier, func(ctx context.Context, userID string, command string) ([]Report, error) {
+		return nil, errors.New("fitbit is down")
+	})
+
+	body := textMessageEvent("U1", "today")
+	response, err := server.handleFunctionURL(context.Background(), events.LambdaFunctionURLRequest{
+		Headers:         map[string]string{"x-line-signature": sign(body)},
+		Body:            base64.StdEncoding.EncodeToString([]byte(body)),
+		IsBase64Encoded: true,
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			HTTP: events.LambdaFunctionURLRequestContextHTTPDescription{Method: http.MethodPost},
+		},
+		RawPath: "/",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Len(t, replier.requests, 1)
+	assert.Equal(t, locales[DEFAULT_LOCALE].message("commandFailed"), replier.requests[0].Messages[0].(messaging_api.TextMessage).Text)
+}
+
+func TestFunctionURLResponseWriter(t *testing.T) {
+	w := &functionURLResponseWriter{header: http.Header{}}
+	http.Error(w, "invalid signature", http.StatusBadRequest)
+	response := w.response()
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	assert.Equal(t, "invalid signature\n", response.Body)
+	assert.Equal(t, "text/plain; charset=utf-8", response.Headers["Content-Type"])
+
+	// nothing written
+	w = &functionURLResponseWriter{header: http.Header{}}
+	assert.Equal(t, http.StatusOK, w.response().StatusCode)
+}
+
+func TestReportResponder(t *testing.T) {
+	today := time.Now().Local()
+	responder := &reportResponder{
+		getAccessToken: func(ctx context.Context) (string, error) {
+			return "token", nil
+		},
+		fetchTimeSeries: func(ctx context.Context, access_token string, resource TimeSeriesResource, startDate string, endDate string) (map[string][]map[string]string, error) {
+			return map[string][]map[string]string{
+				"activities-steps": {
+					{"dateTime": today.Format(DATE_FORMAT), "value": "6543"},
+				},
+			}, nil
+		},
+	}
+
+	reports, err := responder.respond(context.Background(), "U1", COMMAND_TODAY)
+	assert.NoError(t, err)
+	assert.Len(t, reports, 1)
+	assert.Contains(t, reports[0].Text, "6,543 / 10,000\nRemaining: 3,457")
+
+	reports, err = responder.respond(context.Background(), "U1", COMMAND_HELP)
+	assert.NoError(t, err)
+	assert.Equal(t, locales[DEFAULT_LOCALE].message("help"), reports[0].Text)
+
+	// the help without the dashboard
+	reports, err = responder.respond(context.Background(), "U1", COMMAND_DASHBOARD)
+	assert.NoError(t, err)
+	assert.Equal(t, locales[DEFAULT_LOCALE].message("help"), reports[0].Text)
+
+	responder.dashboardURL = func(userID string) string {
+		return dashboardLink("https://example.com", "secret", userID, time.Now())
+	}
+	reports, err = responder.respond(context.Background(), "U1", COMMAND_DASHBOARD)
+	assert.NoError(t, err)
+	assert.Contains(t, reports[0].Text, "https://example.com/dashboard/?expires=")
+}
+
+func TestRenderTimeSeriesSection(t *testing.T) {
+	data := TimeSeriesReportData{
+		Resource: stepsResource,
+		LifetimeTop: []DailyValue{
+			{time.Date(2023, time.December, 31, 0, 0, 0, 0, time.Local), 23456},
+		},
+	}
+
+	actual, err := renderTimeSeriesSection(data, UserSettings{}, "lifetimeTop")
+	assert.NoError(t, err)
+	assert.Equal(t, "Top Records in Lifetime\n\n23,456(2023/12/31)", actual)
+}
+
+func TestRenderTodayReport(t *testing.T) {
+	today := time.Date(2024, time.March, 3, 0, 0, 0, 0, time.Local)
+
+	actual, err := renderTodayReport(buildTodayReportData(12345, today, 10000), UserSettings{Locale: "ja"})
+	assert.NoError(t, err)
+	assert.Equal(t, "今日の歩数 (3月3日 日)\n\n12,345 / 10,000\n目標達成！", actual)
+}
+
+func TestAccessTokenCache(t *testing.T) {
+	calls := 0
+	cache := &accessTokenCache{fetch: func(ctx context.Context) (*string, error) {
+		calls += 1
+		token := "token"
+		return &token, nil
+	}}
+
+	for i := 0; i < 3; i++ {
+		token, err := cache.get(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "token", token)
+	}
+	assert.Equal(t, 1, calls)
+
+	cache.expiresAt = time.Now().Add(-time.Second)
+	_, err := cache.get(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+}
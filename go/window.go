@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// the 7 days before today
+	WINDOW_LAST_7_DAYS = "last7Days"
+	// the last week from Monday to Sunday which has ended
+	WINDOW_ISO_WEEK = "isoWeek"
+	// the last week from Sunday to Saturday which has ended
+	WINDOW_SUNDAY_WEEK = "sundayWeek"
+	// separates the first and the last day of a custom window, e.g. "2024-01-01/2024-01-14"
+	WINDOW_RANGE_SEPARATOR = "/"
+)
+
+// ReportWindow is the range of days a report covers, from Start to the day before End.
+// Both are midnight.
+type ReportWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+func lastSevenDays(today time.Time) ReportWindow {
+	todayDate := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+	return ReportWindow{Start: todayDate.AddDate(0, 0, -7), End: todayDate}
+}
+
+// lastWeek returns the last week starting on firstDay which has ended before today.
+func lastWeek(today time.Time, firstDay time.Weekday) ReportWindow {
+	todayDate := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+	end := todayDate.AddDate(0, 0, -(int(todayDate.Weekday()-firstDay)+7)%7)
+	return ReportWindow{Start: end.AddDate(0, 0, -7), End: end}
+}
+
+// newReportWindow returns the window of a definition as of today. The last 7 days are
+// reported when the definition is empty.
+func newReportWindow(definition string, today time.Time) (ReportWindow, error) {
+	switch definition {
+	case "", WINDOW_LAST_7_DAYS:
+		return lastSevenDays(today), nil
+	case WINDOW_ISO_WEEK:
+		return lastWeek(today, time.Monday), nil
+	case WINDOW_SUNDAY_WEEK:
+		return lastWeek(today, time.Sunday), nil
+	}
+
+	first, last, ok := strings.Cut(definition, WINDOW_RANGE_SEPARATOR)
+	if !ok {
+		return ReportWindow{}, fmt.Errorf("%s: %s", InvalidReportWindow, definition)
+	}
+	start, err := time.ParseInLocation(DATE_FORMAT, strings.TrimSpace(first), today.Location())
+	if err != nil {
+		return ReportWindow{}, fmt.Errorf("%s: %s", InvalidReportWindow, definition)
+	}
+	end, err := time.ParseInLocation(DATE_FORMAT, strings.TrimSpace(last), today.Location())
+	if err != nil || end.Before(start) {
+		return ReportWindow{}, fmt.Errorf("%s: %s", InvalidReportWindow, definition)
+	}
+	return ReportWindow{Start: start, End: end.AddDate(0, 0, 1)}, nil
+}
+
+// Days lists the dates of the window.
+func (window ReportWindow) Days() []time.Time {
+	var days []time.Time
+	for date := window.Start; date.Before(window.End); date = date.AddDate(0, 0, 1) {
+		days = append(days, date)
+	}
+	return days
+}
+
+// Len is the number of days of the window.
+func (window ReportWindow) Len() int {
+	return len(window.Days())
+}
+
+// Contains reports whether t, e.g. the start time of a run, is in the window.
+func (window ReportWindow) Contains(t time.Time) bool {
+	return !t.Before(window.Start) && t.Before(window.End)
+}
+
+// Previous returns the window of the same length just before this one.
+func (window ReportWindow) Previous() ReportWindow {
+	return ReportWindow{Start: window.Start.AddDate(0, 0, -window.Len()), End: window.Start}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewReportWindow(t *testing.T) {
+	// Wednesday
+	today := time.Date(2024, time.January, 17, 9, 30, 0, 0, time.Local)
+
+	tests := []struct {
+		definition string
+		start      time.Time
+		end        time.Time
+	}{
+		{"", time.Date(2024, time.January, 10, 0, 0, 0, 0, time.Local), time.Date(2024, time.January, 17, 0, 0, 0, 0, time.Local)},
+		{WINDOW_LAST_7_DAYS, time.Date(2024, time.January, 10, 0, 0, 0, 0, time.Local), time.Date(2024, time.January, 17, 0, 0, 0, 0, time.Local)},
+		{WINDOW_ISO_WEEK, time.Date(2024, time.January, 8, 0, 0, 0, 0, time.Local), time.Date(2024, time.January, 15, 0, 0, 0, 0, time.Local)},
+		{WINDOW_SUNDAY_WEEK, time.Date(2024, time.January, 7, 0, 0, 0, 0, time.Local), time.Date(2024, time.January, 14, 0, 0, 0, 0, time.Local)},
+		{"2024-01-01/2024-01-14", time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local), time.Date(2024, time.January, 15, 0, 0, 0, 0, time.Local)},
+	}
+	for _, test := range tests {
+		window, err := newReportWindow(test.definition, today)
+		assert.NoError(t, err, test.definition)
+		assert.Equal(t, ReportWindow{Start: test.start, End: test.end}, window, test.definition)
+	}
+}
+
+func TestLastWeekEndingToday(t *testing.T) {
+	// on Monday, the ISO week which ended yesterday
+	monday := time.Date(2024, time.January, 15, 9, 0, 0, 0, time.Local)
+	assert.Equal(t, ReportWindow{
+		Start: time.Date(2024, time.January, 8, 0, 0, 0, 0, time.Local),
+		End:   time.Date(2024, time.January, 15, 0, 0, 0, 0, time.Local),
+	}, lastWeek(monday, time.Monday))
+
+	// on Sunday, the week until yesterday
+	sunday := time.Date(2024, time.January, 14, 9, 0, 0, 0, time.Local)
+	assert.Equal(t, ReportWindow{
+		Start: time.Date(2024, time.January, 7, 0, 0, 0, 0, time.Local),
+		End:   time.Date(2024, time.January, 14, 0, 0, 0, 0, time.Local),
+	}, lastWeek(sunday, time.Sunday))
+}
+
+func TestNewReportWindowWithInvalidDefinition(t *testing.T) {
+	today := time.Date(2024, time.January, 17, 0, 0, 0, 0, time.Local)
+	for _, definition := range []string{"fortnight", "2024-01-01", "2024-01-14/2024-01-01", "2024-01-01/2024-02-30"} {
+		_, err := newReportWindow(definition, today)
+		assert.ErrorContains(t, err, InvalidReportWindow, definition)
+	}
+}
+
+func TestReportWindow(t *testing.T) {
+	window := ReportWindow{
+		Start: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local),
+		End:   time.Date(2024, time.January, 4, 0, 0, 0, 0, time.Local),
+	}
+
+	assert.Equal(t, []time.Time{
+		time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local),
+		time.Date(2024, time.January, 2, 0, 0, 0, 0, time.Local),
+		time.Date(2024, time.January, 3, 0, 0, 0, 0, time.Local),
+	}, window.Days())
+	assert.Equal(t, 3, window.Len())
+	assert.Equal(t, ReportWindow{
+		Start: time.Date(2023, time.December, 29, 0, 0, 0, 0, time.Local),
+		End:   time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local),
+	}, window.Previous())
+
+	assert.True(t, window.Contains(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local)))
+	assert.True(t, window.Contains(time.Date(2024, time.January, 3, 23, 59, 0, 0, time.Local)))
+	assert.False(t, window.Contains(time.Date(2024, time.January, 4, 0, 0, 0, 0, time.Local)))
+	assert.False(t, window.Contains(time.Date(2023, time.December, 31, 23, 59, 0, 0, time.Local)))
+}
+
+func TestReportsShareTheWindow(t *testing.T) {
+	// Wednesday morning, when the running report used to count from 7 days ago at this time
+	today := time.Date(2024, time.January, 17, 9, 30, 0, 0, time.Local)
+	window, err := newReportWindow(WINDOW_ISO_WEEK, today)
+	assert.NoError(t, err)
+
+	lifetimeData := map[time.Time]float64{}
+	for _, date := range append(window.Previous().Days(), window.Days()...) {
+		lifetimeData[date] = 1000
+	}
+	runningLog := map[time.Time]float64{
+		// Monday and Sunday of the week
+		time.Date(2024, time.January, 8, 6, 0, 0, 0, time.Local):   5,
+		time.Date(2024, time.January, 14, 20, 0, 0, 0, time.Local): 10,
+		// the week before and this week
+		time.Date(2024, time.January, 7, 20, 0, 0, 0, time.Local): 3,
+		time.Date(2024, time.January, 16, 6, 0, 0, 0, time.Local): 4,
+	}
+
+	steps := buildTimeSeriesReportData(stepsResource, lifetimeData, today, window)
+	running := buildRunningReportData(runningLog, today, window)
+
+	assert.Equal(t, window.Days()[0], steps.Weekly[0].Date)
+	assert.Equal(t, window.Days()[6], steps.Weekly[6].Date)
+	assert.Equal(t, float64(7000), steps.WeeklyTotal)
+	assert.Equal(t, float64(7000), steps.PreviousWeeklyTotal)
+
+	assert.Len(t, running.Weekly, 2)
+	assert.Equal(t, float64(15), running.WeeklyDistance)
+	assert.Equal(t, float64(3), running.PreviousWeeklyDistance)
+}
+
+func TestGenerateStepsReportWithCustomWindow(t *testing.T) {
+	today := time.Date(2024, time.January, 17, 0, 0, 0, 0, time.Local)
+	lifetimeStepsData := map[time.Time]int{
+		time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local): 1000,
+		time.Date(2024, time.January, 2, 0, 0, 0, 0, time.Local): 2000,
+		time.Date(2024, time.January, 3, 0, 0, 0, 0, time.Local): 6000,
+	}
+
+	actual, err := generateStepsReport(lifetimeStepsData, today, UserSettings{ReportWindow: "2024-01-01/2024-01-03"})
+	assert.NoError(t, err)
+	assert.Contains(t, actual, `1/1 Mon 1,000 (P16, #3 of 2024)
+1/2 Tue 2,000 (P50, #2 of 2024)
+1/3 Wed 6,000 (P83, #1 of 2024)
+
+Total: 9,000 (#1 of 1 weeks)
+Average: 3,000
+`)
+}